@@ -0,0 +1,166 @@
+package lgrep
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/olivere/elastic.v3"
+	"gopkg.in/olivere/elastic.v3/uritemplates"
+)
+
+// Backend is a store of documents that searches are run against. The
+// request bodies are those of the Elasticsearch search API, as are the
+// results, so that a backend may support as much of it as it's able.
+type Backend interface {
+	// Search runs the search body, returning a page of hits (or the
+	// aggregations asked for).
+	Search(ctx context.Context, target SearchTarget, body interface{}) (*elastic.SearchResult, error)
+	// Scroll runs the search body and returns the first page of hits
+	// along with the scroll's id when the id isn't given, otherwise
+	// the next page of the scroll. The scroll is kept for keepAlive
+	// between pages (ex: "30s"), it has ended when a page has no hits.
+	Scroll(ctx context.Context, target SearchTarget, body interface{}, keepAlive string, scrollID string) (*elastic.SearchResult, error)
+	// ClearScroll releases the scrolls before they expire.
+	ClearScroll(ctx context.Context, scrollIDs ...string) error
+	// Validate checks the query of the body without running it,
+	// explaining the query (or its error) for each index.
+	Validate(ctx context.Context, target SearchTarget, body interface{}) (ValidationResponse, error)
+	// Count returns the number of documents that match the query of
+	// the body.
+	Count(ctx context.Context, target SearchTarget, body interface{}) (int64, error)
+	// Mapping returns the mapping of each of the indices by name, as
+	// the Elasticsearch mapping API does.
+	Mapping(ctx context.Context, target SearchTarget) (map[string]interface{}, error)
+}
+
+// SearchTarget are the indices and types of documents that a request
+// applies to, all of them when not given.
+type SearchTarget struct {
+	Indices []string
+	Types   []string
+}
+
+// path returns the path of the endpoint for the target's indices and
+// types (ex: /logstash-*/logs/_search).
+func (t SearchTarget) path(endpoint string) (path string, err error) {
+	switch {
+	case len(t.Indices) > 0 && len(t.Types) > 0:
+		path, err = uritemplates.Expand("/{index}/{type}/", map[string]string{
+			"index": strings.Join(t.Indices, ","),
+			"type":  strings.Join(t.Types, ","),
+		})
+	case len(t.Indices) > 0:
+		path, err = uritemplates.Expand("/{index}/", map[string]string{
+			"index": strings.Join(t.Indices, ","),
+		})
+	case len(t.Types) > 0:
+		path, err = uritemplates.Expand("/_all/{type}/", map[string]string{
+			"type": strings.Join(t.Types, ","),
+		})
+	default:
+		path = "/"
+	}
+	return path + endpoint, err
+}
+
+// ElasticBackend runs the searches with an Elasticsearch client.
+type ElasticBackend struct {
+	Client *elastic.Client
+}
+
+// NewElasticBackend creates a backend searching with the client.
+func NewElasticBackend(client *elastic.Client) ElasticBackend {
+	return ElasticBackend{Client: client}
+}
+
+// Search runs the search body.
+func (b ElasticBackend) Search(ctx context.Context, target SearchTarget, body interface{}) (result *elastic.SearchResult, err error) {
+	path, err := target.path("_search")
+	if err != nil {
+		return result, err
+	}
+	return b.search(ctx, path, url.Values{}, body)
+}
+
+// Scroll starts or continues a scroll of the search body.
+func (b ElasticBackend) Scroll(ctx context.Context, target SearchTarget, body interface{}, keepAlive string, scrollID string) (result *elastic.SearchResult, err error) {
+	if scrollID != "" {
+		next := map[string]interface{}{"scroll": keepAlive, "scroll_id": scrollID}
+		return b.search(ctx, "/_search/scroll", url.Values{}, next)
+	}
+	path, err := target.path("_search")
+	if err != nil {
+		return result, err
+	}
+	return b.search(ctx, path, url.Values{"scroll": []string{keepAlive}}, body)
+}
+
+// search requests the path, decoding the search result.
+func (b ElasticBackend) search(ctx context.Context, path string, params url.Values, body interface{}) (result *elastic.SearchResult, err error) {
+	res, err := b.Client.PerformRequestC(ctx, "POST", path, params, body)
+	if err != nil {
+		return result, err
+	}
+	result = new(elastic.SearchResult)
+	if err = json.Unmarshal(res.Body, result); err != nil {
+		return nil, errors.Annotate(err, "Could not parse the search result")
+	}
+	if result.Hits == nil {
+		result.Hits = &elastic.SearchHits{}
+	}
+	return result, nil
+}
+
+// ClearScroll clears the scrolls.
+func (b ElasticBackend) ClearScroll(ctx context.Context, scrollIDs ...string) error {
+	if len(scrollIDs) == 0 {
+		return nil
+	}
+	body := map[string]interface{}{"scroll_id": scrollIDs}
+	_, err := b.Client.PerformRequestC(ctx, "DELETE", "/_search/scroll", url.Values{}, body)
+	return err
+}
+
+// Validate validates the query of the body with its explanation.
+func (b ElasticBackend) Validate(ctx context.Context, target SearchTarget, body interface{}) (result ValidationResponse, err error) {
+	path, err := target.path("_validate/query")
+	if err != nil {
+		return result, err
+	}
+	res, err := b.Client.PerformRequestC(ctx, "GET", path, url.Values{"explain": []string{"true"}}, body)
+	if err != nil {
+		return result, err
+	}
+	result.Explanations = make([]ValidationExplanation, 0)
+	err = json.Unmarshal(res.Body, &result)
+	return result, err
+}
+
+// Count counts the documents matching the query of the body.
+func (b ElasticBackend) Count(ctx context.Context, target SearchTarget, body interface{}) (count int64, err error) {
+	path, err := target.path("_count")
+	if err != nil {
+		return count, err
+	}
+	res, err := b.Client.PerformRequestC(ctx, "POST", path, url.Values{}, body)
+	if err != nil {
+		return count, err
+	}
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	err = json.Unmarshal(res.Body, &result)
+	return result.Count, err
+}
+
+// Mapping retrieves the mapping of the indices.
+func (b ElasticBackend) Mapping(ctx context.Context, target SearchTarget) (mapping map[string]interface{}, err error) {
+	service := b.Client.GetMapping().Index(target.Indices...)
+	if len(target.Types) != 0 {
+		service.Type(target.Types...)
+	}
+	return service.DoC(ctx)
+}
@@ -0,0 +1,95 @@
+package lgrep
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/olivere/elastic.v3"
+)
+
+// fakeBackend answers with a page of hits, counting the requests made
+// to it.
+type fakeBackend struct {
+	hits     []*elastic.SearchHit
+	searches int
+	scrolls  int
+	cleared  []string
+}
+
+func (b *fakeBackend) Search(ctx context.Context, target SearchTarget, body interface{}) (*elastic.SearchResult, error) {
+	b.searches++
+	return &elastic.SearchResult{Hits: &elastic.SearchHits{TotalHits: int64(len(b.hits)), Hits: b.hits}}, nil
+}
+
+func (b *fakeBackend) Scroll(ctx context.Context, target SearchTarget, body interface{}, keepAlive string, scrollID string) (*elastic.SearchResult, error) {
+	b.scrolls++
+	result := &elastic.SearchResult{ScrollId: "scroll1", Hits: &elastic.SearchHits{}}
+	if scrollID == "" {
+		result.Hits.Hits = b.hits
+	}
+	return result, nil
+}
+
+func (b *fakeBackend) ClearScroll(ctx context.Context, scrollIDs ...string) error {
+	b.cleared = append(b.cleared, scrollIDs...)
+	return nil
+}
+
+func (b *fakeBackend) Validate(ctx context.Context, target SearchTarget, body interface{}) (result ValidationResponse, err error) {
+	result.Valid = true
+	result.Shards.Successful = 1
+	return result, nil
+}
+
+func (b *fakeBackend) Count(ctx context.Context, target SearchTarget, body interface{}) (int64, error) {
+	return int64(len(b.hits)), nil
+}
+
+func (b *fakeBackend) Mapping(ctx context.Context, target SearchTarget) (map[string]interface{}, error) {
+	var mapping map[string]interface{}
+	err := json.Unmarshal([]byte(`{"logs-1": {"mappings": {"logs": {"properties": {
+		"message": {"type": "string"}, "status": {"type": "long"}
+	}}}}}`), &mapping)
+	return mapping, err
+}
+
+func newFakeBackend() *fakeBackend {
+	source := json.RawMessage(`{"message": "failed", "status": 500}`)
+	return &fakeBackend{hits: []*elastic.SearchHit{
+		{Index: "logs-1", Type: "logs", Id: "1", Source: &source},
+		{Index: "logs-1", Type: "logs", Id: "2", Source: &source},
+	}}
+}
+
+func TestBackend(t *testing.T) {
+	backend := newFakeBackend()
+	l := NewWithBackend(backend)
+	spec := &SearchOptions{Index: "logs-*", Size: 10}
+
+	if docs, err := l.SimpleSearch("status:500", spec); err != nil || len(docs) != 2 {
+		t.Errorf("Searched %d documents: %v", len(docs), err)
+	}
+	if backend.searches != 1 {
+		t.Errorf("Expected a search, made %d", backend.searches)
+	}
+
+	scroll := &SearchOptions{Index: "logs-*", Size: MaxSearchSize + 1}
+	if docs, err := l.SimpleSearch("status:500", scroll); err != nil || len(docs) != 2 {
+		t.Errorf("Scrolled %d documents: %v", len(docs), err)
+	}
+	if backend.scrolls != 2 || !reflect.DeepEqual(backend.cleared, []string{"scroll1"}) {
+		t.Errorf("Expected 2 pages scrolled and the scroll cleared, scrolled %d and cleared %v", backend.scrolls, backend.cleared)
+	}
+
+	if count, err := l.SimpleCount("status:500", spec); err != nil || count != 2 {
+		t.Errorf("Counted %d documents: %v", count, err)
+	}
+	if result, err := l.Validate("status:500", spec); err != nil || !result.Valid {
+		t.Errorf("Validated %t: %v", result.Valid, err)
+	}
+	if fields, err := l.Fields(spec); err != nil || !reflect.DeepEqual(fields, []string{"message", "status"}) {
+		t.Errorf("Fields %v: %v", fields, err)
+	}
+}
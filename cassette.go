@@ -0,0 +1,258 @@
+package lgrep
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+)
+
+// scrubbedHeaders are the headers that aren't recorded in cassettes,
+// as they hold credentials.
+var scrubbedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+// interaction is a request made to the server and its response (or
+// the error it failed with), as recorded in a cassette.
+type interaction struct {
+	Request  recordedRequest   `json:"request"`
+	Response *recordedResponse `json:"response,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type recordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type recordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// scrubHeader copies the header without the credentials it holds.
+func scrubHeader(header http.Header) http.Header {
+	scrubbed := make(http.Header, len(header))
+	for k, v := range header {
+		scrubbed[k] = append([]string(nil), v...)
+	}
+	for _, k := range scrubbedHeaders {
+		scrubbed.Del(k)
+	}
+	return scrubbed
+}
+
+// readBody reads the body, giving back a body that reads the same.
+func readBody(body io.ReadCloser) (data []byte, readAgain io.ReadCloser, err error) {
+	if body == nil {
+		return nil, nil, nil
+	}
+	data, err = ioutil.ReadAll(body)
+	body.Close()
+	return data, ioutil.NopCloser(bytes.NewReader(data)), err
+}
+
+// Recorder is a transport that records the requests made with it, and
+// the responses to them, in a cassette that a Replayer can serve back.
+// The credentials of the requests (auth headers, cookies and the
+// user of the URL) aren't recorded.
+type Recorder struct {
+	next http.RoundTripper
+
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewRecorder creates the cassette at the path, recording the
+// requests sent with next (http.DefaultTransport when nil).
+func NewRecorder(path string, next http.RoundTripper) (r *Recorder, err error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Could not create the cassette")
+	}
+	return &Recorder{next: next, file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// RoundTrip sends the request, recording it and its response.
+func (r *Recorder) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	// The request is copied rather than modified, as a RoundTripper
+	// mustn't change the request it's given.
+	sent := new(http.Request)
+	*sent = *req
+	body, sentBody, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	sent.Body = sentBody
+
+	u := *req.URL
+	u.User = nil
+	recorded := interaction{Request: recordedRequest{
+		Method: req.Method,
+		URL:    u.String(),
+		Header: scrubHeader(req.Header),
+		Body:   string(body),
+	}}
+
+	res, err = r.next.RoundTrip(sent)
+	if err != nil {
+		recorded.Error = err.Error()
+		r.record(recorded)
+		return res, err
+	}
+	body, res.Body, err = readBody(res.Body)
+	if err != nil {
+		return res, err
+	}
+	recorded.Response = &recordedResponse{
+		Status: res.StatusCode,
+		Header: scrubHeader(res.Header),
+		Body:   string(body),
+	}
+	r.record(recorded)
+	return res, nil
+}
+
+// record writes the interaction to the cassette as it's made, so that
+// the cassette holds the requests made before a crash.
+func (r *Recorder) record(recorded interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(recorded); err != nil {
+		log.Warnf("Could not record %s %s: %s", recorded.Request.Method, recorded.Request.URL, err)
+	}
+}
+
+// Close closes the cassette.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Replayer is a transport that serves the responses recorded in a
+// cassette rather than sending the requests. Requests are matched to
+// the recorded ones by their method, path, parameters and body, in the
+// order they were recorded; when the body differs (ex: the time
+// ranges of relative searches) the next request to the same path is
+// replayed. The host of the requests is ignored.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []interaction
+	played       []bool
+}
+
+// NewReplayer loads the cassette at the path.
+func NewReplayer(path string) (r *Replayer, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Could not open the cassette")
+	}
+	defer f.Close()
+	r = &Replayer{}
+	d := json.NewDecoder(f)
+	for {
+		var recorded interaction
+		if err = d.Decode(&recorded); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Annotatef(err, "Could not read interaction %d of the cassette", len(r.interactions)+1)
+		}
+		r.interactions = append(r.interactions, recorded)
+	}
+	r.played = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+// RoundTrip responds to the request as the server did when it was
+// recorded.
+func (r *Replayer) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	body, _, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	recorded, ok := r.match(req, string(body))
+	if !ok {
+		return nil, errors.Errorf("%s %s wasn't recorded in the cassette", req.Method, req.URL.RequestURI())
+	}
+	if recorded.Error != "" {
+		return nil, errors.New(recorded.Error)
+	}
+	response := recorded.Response
+	header := make(http.Header, len(response.Header))
+	for k, v := range response.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+		StatusCode:    response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(response.Body))),
+		ContentLength: int64(len(response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// match finds the recorded interaction that the request replays:
+// first the next one that's the same request, then the next one to
+// the same path and finally the last that's the same request (ex:
+// health checks, made as often as they're needed).
+func (r *Replayer) match(req *http.Request, body string) (recorded interaction, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	uri := req.URL.RequestURI()
+	same := func(i int, withBody bool) bool {
+		recorded := r.interactions[i].Request
+		if recorded.Method != req.Method || requestURI(recorded.URL) != uri {
+			return false
+		}
+		return !withBody || recorded.Body == body
+	}
+
+	for _, withBody := range []bool{true, false} {
+		for i := range r.interactions {
+			if !r.played[i] && same(i, withBody) {
+				r.played[i] = true
+				return r.interactions[i], true
+			}
+		}
+	}
+	for i := len(r.interactions) - 1; i >= 0; i-- {
+		if same(i, true) {
+			return r.interactions[i], true
+		}
+	}
+	return recorded, false
+}
+
+// requestURI returns the path and parameters of the recorded URL.
+func requestURI(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return u.RequestURI()
+}
@@ -0,0 +1,79 @@
+package lgrep_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+func TestCassette(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.ndjson")
+
+	server := lgreptest.NewServer()
+	if err = server.Load(testFixturesPath + "/journald.ndjson"); err != nil {
+		t.Fatal(err)
+	}
+	recorder, err := lgrep.NewRecorder(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL+"/", nil)
+	req.SetBasicAuth("elastic", "secret")
+	res, err := (&http.Client{Transport: recorder}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	spec := &lgrep.SearchOptions{Index: "journald-*", Size: 5, SortTime: lgrep.SortDesc}
+	l, err := lgrep.NewWithTransport(server.URL, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := l.SimpleSearch("service:kernel", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+	server.Close()
+
+	cassette, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(cassette), "Authorization") || strings.Contains(string(cassette), "secret") {
+		t.Errorf("The credentials were recorded:\n%s", cassette)
+	}
+
+	// The server's gone, the cassette answers in its place.
+	replayer, err := lgrep.NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err = lgrep.NewWithTransport(server.URL, replayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := l.SimpleSearch("service:kernel", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) == 0 || !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("Replayed %v, recorded %v", replayed, recorded)
+	}
+	if _, err = l.SimpleCount("service:kernel", spec); err == nil {
+		t.Error("Expected a request that wasn't recorded to fail")
+	}
+}
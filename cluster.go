@@ -0,0 +1,215 @@
+package lgrep
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// DefaultClusterField is the field whose values are clustered.
+	DefaultClusterField = "message"
+	// DefaultClusterSimilarity is the fraction of tokens a message
+	// must share with a pattern to be clustered with it.
+	DefaultClusterSimilarity = 0.5
+	// clusterWildcard replaces the tokens that vary in a pattern.
+	clusterWildcard = "<*>"
+)
+
+// clusterMasks replace the tokens that are expected to vary between
+// messages of the same pattern, in the order they're applied.
+var clusterMasks = []struct {
+	pattern *regexp.Regexp
+	mask    string
+}{
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<UUID>"},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`), "<IP>"},
+	{regexp.MustCompile(`\b(?:0[xX][0-9a-fA-F]+|[0-9a-fA-F]*[a-fA-F][0-9a-fA-F]*[0-9][0-9a-fA-F]*|[0-9a-fA-F]*[0-9][0-9a-fA-F]*[a-fA-F][0-9a-fA-F]*)\b`), "<HEX>"},
+	{regexp.MustCompile(`\b\d+(?:\.\d+)?`), "<NUM>"},
+}
+
+// minHexLength is the shortest mixed hex token that's masked, shorter
+// ones are more often words (ex: "add3", "be4").
+const minHexLength = 8
+
+// Cluster is a pattern of messages and the messages that matched it.
+type Cluster struct {
+	// Pattern is the message with the varying tokens masked.
+	Pattern string `json:"pattern"`
+	// Count is the number of messages that matched the pattern.
+	Count int64 `json:"count"`
+	// First and Last are the earliest and latest timestamps of the
+	// messages, zero when they had none.
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+	// Example is one of the messages as it was.
+	Example string `json:"example"`
+
+	tokens []string
+}
+
+// Clusterer groups messages into patterns as they're added using the
+// Drain algorithm: messages are grouped by their number of tokens and
+// first token, then matched to the most similar pattern of the group.
+// Tokens of a pattern that differ from a matched message are replaced
+// with a wildcard.
+type Clusterer struct {
+	// Similarity is the fraction of tokens a message must share with a
+	// pattern to match it.
+	Similarity float64
+
+	groups   map[string][]*Cluster
+	clusters []*Cluster
+}
+
+// NewClusterer creates a clusterer with the default similarity.
+func NewClusterer() *Clusterer {
+	return &Clusterer{
+		Similarity: DefaultClusterSimilarity,
+		groups:     make(map[string][]*Cluster),
+	}
+}
+
+// MaskMessage replaces the UUIDs, IPs, hex and numbers in the message.
+func MaskMessage(message string) string {
+	for _, m := range clusterMasks {
+		mask := m.mask
+		message = m.pattern.ReplaceAllStringFunc(message, func(s string) string {
+			if mask == "<HEX>" && len(s) < minHexLength && !strings.HasPrefix(strings.ToLower(s), "0x") {
+				return s
+			}
+			return mask
+		})
+	}
+	return message
+}
+
+// Add clusters the message, returning the cluster it was added to.
+func (c *Clusterer) Add(message string, ts time.Time) *Cluster {
+	tokens := strings.Fields(MaskMessage(message))
+	key := clusterKey(tokens)
+
+	var (
+		best           *Cluster
+		bestSimilarity float64
+	)
+	for _, cluster := range c.groups[key] {
+		similarity := tokenSimilarity(cluster.tokens, tokens)
+		if similarity >= c.Similarity && (best == nil || similarity > bestSimilarity) {
+			best, bestSimilarity = cluster, similarity
+		}
+	}
+	if best == nil {
+		best = &Cluster{tokens: tokens, Example: message}
+		c.groups[key] = append(c.groups[key], best)
+		c.clusters = append(c.clusters, best)
+	}
+	for i, token := range tokens {
+		if best.tokens[i] != token {
+			best.tokens[i] = clusterWildcard
+		}
+	}
+	best.Pattern = strings.Join(best.tokens, " ")
+	best.Count++
+	if !ts.IsZero() {
+		if best.First.IsZero() || ts.Before(best.First) {
+			best.First = ts
+		}
+		if ts.After(best.Last) {
+			best.Last = ts
+		}
+	}
+	return best
+}
+
+// Clusters returns the clusters with the most common first, ties are
+// kept in the order they were found.
+func (c *Clusterer) Clusters() []Cluster {
+	clusters := make([]Cluster, len(c.clusters))
+	for i, cluster := range c.clusters {
+		clusters[i] = *cluster
+	}
+	sort.Stable(byClusterCount(clusters))
+	return clusters
+}
+
+// ClusterStream reads the stream entirely, clustering the values of
+// the field. Results without the field aren't clustered.
+func ClusterStream(stream *SearchStream, field string) (clusters []Cluster, err error) {
+	if field == "" {
+		return clusters, errors.New("No field given to cluster")
+	}
+	c := NewClusterer()
+	err = stream.Each(func(r Result) error {
+		data, err := resultSource(r)
+		if err != nil {
+			return err
+		}
+		value, ok := fieldValue(data, field)
+		if !ok || value == nil {
+			return nil
+		}
+		message, ok := value.(string)
+		if !ok {
+			message = fmt.Sprint(value)
+		}
+		c.Add(message, resultTime(data))
+		return nil
+	}, func(e error) error { return e })
+	if err != nil {
+		return clusters, err
+	}
+	return c.Clusters(), nil
+}
+
+// resultTime returns the timestamp of the document, zero when it has
+// none.
+func resultTime(data map[string]interface{}) time.Time {
+	for _, field := range tsPreference {
+		if str, ok := data[field].(string); ok {
+			if ts, err := time.Parse(time.RFC3339, str); err == nil {
+				return ts
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// clusterKey groups the messages by their length and first token,
+// unless that's masked.
+func clusterKey(tokens []string) string {
+	if len(tokens) == 0 {
+		return "0"
+	}
+	first := tokens[0]
+	if strings.Contains(first, "<") {
+		first = clusterWildcard
+	}
+	return fmt.Sprintf("%d %s", len(tokens), first)
+}
+
+// tokenSimilarity is the fraction of the pattern's tokens that are the
+// same in the message, wildcards aren't counted as the same.
+func tokenSimilarity(pattern, tokens []string) float64 {
+	if len(pattern) == 0 {
+		return 1
+	}
+	same := 0
+	for i, token := range pattern {
+		if token != clusterWildcard && token == tokens[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(pattern))
+}
+
+// byClusterCount sorts the clusters with the most common first.
+type byClusterCount []Cluster
+
+func (c byClusterCount) Len() int           { return len(c) }
+func (c byClusterCount) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byClusterCount) Less(i, j int) bool { return c[i].Count > c[j].Count }
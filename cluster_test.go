@@ -0,0 +1,78 @@
+package lgrep
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaskMessage(t *testing.T) {
+	examples := map[string]string{
+		"Connection to 10.0.0.1:5432 timed out after 3000ms":  "Connection to <IP>:<NUM> timed out after <NUM>ms",
+		"request 0e8a3b52-11d4-4d2c-9a6b-7f1c2d3e4f50 failed": "request <UUID> failed",
+		"commit 3f9a2c1d8e and pointer 0xc42000e2a0":          "commit <HEX> and pointer <HEX>",
+		"add3 items in 2.5s":                                  "add3 items in <NUM>s",
+		"order 12345678 shipped":                              "order <NUM> shipped",
+	}
+	for message, expected := range examples {
+		if masked := MaskMessage(message); masked != expected {
+			t.Errorf("Masked '%s' => '%s', expected '%s'", message, masked, expected)
+		}
+	}
+}
+
+func TestClusterer(t *testing.T) {
+	at := func(minute int) time.Time {
+		return time.Date(2016, 4, 29, 13, minute, 0, 0, time.UTC)
+	}
+	c := NewClusterer()
+	c.Add("User alice logged in from 10.0.0.1", at(5))
+	c.Add("Disk full on /var", at(1))
+	c.Add("User bob logged in from 10.0.0.2", at(2))
+	c.Add("User carol logged in from 10.0.0.3", at(9))
+	c.Add("Disk full on /tmp", time.Time{})
+	c.Add("Shutting down", at(3))
+
+	clusters := c.Clusters()
+	if len(clusters) != 3 {
+		t.Fatalf("Expected 3 clusters, got %+v", clusters)
+	}
+	expected := []struct {
+		pattern string
+		count   int64
+	}{
+		{"User <*> logged in from <IP>", 3},
+		{"Disk full on <*>", 2},
+		{"Shutting down", 1},
+	}
+	for i, e := range expected {
+		if clusters[i].Pattern != e.pattern || clusters[i].Count != e.count {
+			t.Errorf("Cluster %d: expected '%s' x%d, got '%s' x%d",
+				i, e.pattern, e.count, clusters[i].Pattern, clusters[i].Count)
+		}
+	}
+	if !clusters[0].First.Equal(at(2)) || !clusters[0].Last.Equal(at(9)) {
+		t.Errorf("Expected the first cluster from %s to %s, got %s to %s",
+			at(2), at(9), clusters[0].First, clusters[0].Last)
+	}
+	if clusters[0].Example != "User alice logged in from 10.0.0.1" {
+		t.Errorf("Unexpected example '%s'", clusters[0].Example)
+	}
+}
+
+func TestClusterStream(t *testing.T) {
+	stream := newTestStream(
+		SourceResult(`{"@timestamp": "2016-04-29T13:00:00Z", "message": "GET /users/1 200"}`),
+		SourceResult(`{"@timestamp": "2016-04-29T13:01:00.5Z", "message": "GET /users/2 200"}`),
+		SourceResult(`{"@timestamp": "2016-04-29T13:02:00Z"}`),
+	)
+	clusters, err := ClusterStream(stream, "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].Pattern != "GET /users/<NUM> <NUM>" || clusters[0].Count != 2 {
+		t.Errorf("Unexpected clusters %+v", clusters)
+	}
+	if len(clusters) == 1 && clusters[0].Last.Sub(clusters[0].First) != 60500*time.Millisecond {
+		t.Errorf("Unexpected time range %s to %s", clusters[0].First, clusters[0].Last)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+const (
+	defaultBulkBatch   = 500
+	defaultBulkWorkers = 2
+	// defaultBulkType is the type of documents that don't have one
+	// (ex: read from files), for servers that require types.
+	defaultBulkType = "doc"
+	// bulkProgressInterval is how often the progress of bulk indexing
+	// is reported.
+	bulkProgressInterval = 5 * time.Second
+)
+
+// BulkFlags apply to the commands that index documents with the bulk
+// API.
+var BulkFlags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "batch-size",
+		Usage: "Number of documents indexed by each bulk request (at most 5MB of them)",
+		Value: defaultBulkBatch,
+	},
+	cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of bulk requests made concurrently",
+		Value: defaultBulkWorkers,
+	},
+}
+
+// bulkCounts are the documents indexed so far, counted as the bulk
+// requests complete.
+type bulkCounts struct {
+	read    int64
+	indexed int64
+	failed  int64
+	// skipped are the documents read that can't be indexed (ex: hits
+	// without their source).
+	skipped int64
+}
+
+func (c *bulkCounts) String() string {
+	return fmt.Sprintf("read %d, indexed %d, failed %d, skipped %d",
+		atomic.LoadInt64(&c.read), atomic.LoadInt64(&c.indexed), atomic.LoadInt64(&c.failed), atomic.LoadInt64(&c.skipped))
+}
+
+// after counts the results of a bulk request.
+func (c *bulkCounts) after(id int64, requests []elastic.BulkableRequest, res *elastic.BulkResponse, err error) {
+	if err != nil {
+		log.Error(errors.Annotatef(err, "Could not index %d documents", len(requests)))
+		atomic.AddInt64(&c.failed, int64(len(requests)))
+		return
+	}
+	failed := res.Failed()
+	// Every failure of a batch is likely the same, only the first is
+	// reported.
+	if len(failed) != 0 && failed[0].Error != nil {
+		log.Warnf("Could not index %d documents: %s: %s", len(failed), failed[0].Error.Type, failed[0].Error.Reason)
+	}
+	atomic.AddInt64(&c.failed, int64(len(failed)))
+	atomic.AddInt64(&c.indexed, int64(len(res.Items)-len(failed)))
+}
+
+// bulkIndexer indexes documents into a server with the bulk API,
+// reporting its progress.
+type bulkIndexer struct {
+	processor *elastic.BulkProcessor
+	counts    *bulkCounts
+	// typed is set when the server requires the documents' types.
+	typed bool
+	done  chan struct{}
+}
+
+// newBulkIndexer starts indexing into the server with the batch size
+// and workers of the command, the progress of the total documents is
+// reported as they're indexed (the total isn't known when it's 0).
+func newBulkIndexer(c *cli.Context, to lgrep.LGrep, total int) (b *bulkIndexer, err error) {
+	if c.Int("batch-size") < 1 || c.Int("workers") < 1 {
+		return nil, cli.NewExitError("The batch size and workers must be at least 1", 3)
+	}
+	b = &bulkIndexer{
+		counts: &bulkCounts{},
+		// Types were removed in Elasticsearch 7.
+		typed: to.Version.APIVersion() < 7,
+		done:  make(chan struct{}),
+	}
+	b.processor, err = to.BulkProcessor().
+		Name("lgrep").
+		Workers(c.Int("workers")).
+		BulkActions(c.Int("batch-size")).
+		After(b.counts.after).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(bulkProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-ticker.C:
+				if total > 0 {
+					log.Infof("Indexing %d documents: %s", total, b.counts)
+				} else {
+					log.Infof("Indexing documents: %s", b.counts)
+				}
+			}
+		}
+	}()
+	return b, nil
+}
+
+// Add indexes the source as the document, with the type when the
+// server requires it.
+func (b *bulkIndexer) Add(index string, typ string, id string, source interface{}) {
+	req := elastic.NewBulkIndexRequest().Index(index).Id(id).Doc(source)
+	if b.typed {
+		if typ == "" {
+			typ = defaultBulkType
+		}
+		req.Type(typ)
+	}
+	atomic.AddInt64(&b.counts.read, 1)
+	b.processor.Add(req)
+}
+
+// Skip counts a document that was read but can't be indexed.
+func (b *bulkIndexer) Skip() {
+	atomic.AddInt64(&b.counts.read, 1)
+	atomic.AddInt64(&b.counts.skipped, 1)
+}
+
+// Close waits for the documents to be indexed and reports how many
+// were, documents that failed to be indexed are an error.
+func (b *bulkIndexer) Close() error {
+	err := b.processor.Close()
+	close(b.done)
+	fmt.Fprintf(os.Stderr, "Indexed documents: %s\n", b.counts)
+	if err != nil {
+		return err
+	}
+	if failed := atomic.LoadInt64(&b.counts.failed); failed != 0 {
+		return cli.NewExitError(fmt.Sprintf("%d documents could not be indexed", failed), 1)
+	}
+	return nil
+}
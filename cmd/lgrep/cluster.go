@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cogolabs/lgrep"
+)
+
+// printClusters groups the values of the cluster field into patterns
+// and writes each with its count, time range and an example, the most
+// common first. With raw output the clusters are written as JSON.
+func (c Config) printClusters(out io.Writer) (err error) {
+	stream, err := c.searchStream()
+	if err != nil {
+		return queryError(err)
+	}
+	clusters, err := lgrep.ClusterStream(stream, c.cluster)
+	if err != nil {
+		return queryError(err)
+	}
+	if len(clusters) == 0 {
+		log.Warn("0 results returned")
+		return nil
+	}
+	for i := range clusters {
+		if c.formatReverse {
+			i = len(clusters) - 1 - i
+		}
+		if c.formatRaw {
+			data, err := json.Marshal(clusters[i])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s\n", data)
+			continue
+		}
+		writeCluster(out, clusters[i])
+	}
+	return nil
+}
+
+// writeCluster writes the cluster's count and pattern, followed by its
+// time range and example indented beneath.
+func writeCluster(out io.Writer, cluster lgrep.Cluster) {
+	fmt.Fprintf(out, "%7d %s\n", cluster.Count, cluster.Pattern)
+	fmt.Fprintf(out, "%7s %s .. %s\n", "", formatClusterTime(cluster.First), formatClusterTime(cluster.Last))
+	fmt.Fprintf(out, "%7s e.g. %s\n", "", cluster.Example)
+}
+
+// formatClusterTime formats a cluster's timestamp, "-" when unknown.
+func formatClusterTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
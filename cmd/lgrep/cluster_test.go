@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cogolabs/lgrep"
+)
+
+func TestWriteCluster(t *testing.T) {
+	var out bytes.Buffer
+	writeCluster(&out, lgrep.Cluster{
+		Pattern: "Disk full on <*>",
+		Count:   12,
+		First:   time.Date(2016, 4, 29, 13, 0, 0, 0, time.UTC),
+		Example: "Disk full on /var",
+	})
+	expected := `     12 Disk full on <*>
+        2016-04-29T13:00:00Z .. -
+        e.g. Disk full on /var
+`
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
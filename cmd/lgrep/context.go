@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+const (
+	// contextSeparator is written between groups of results and their
+	// context that aren't adjacent to each other.
+	contextSeparator = "--"
+	// contextMarkerHit marks the result that matched the query.
+	contextMarkerHit = "> "
+	// contextMarker marks the documents surrounding a result.
+	contextMarker = "  "
+)
+
+// withContext determines if the run is to show the documents
+// surrounding each result.
+func (c Config) withContext() bool {
+	return c.contextBefore > 0 || c.contextAfter > 0
+}
+
+// contextDoc is a document written with its context.
+type contextDoc struct {
+	result lgrep.Result
+	// hit is set when the document matched the query, even if it
+	// was also retrieved as the context of another.
+	hit  bool
+	time time.Time
+	// seq orders the documents logged at the same time as they were
+	// retrieved.
+	seq int
+}
+
+// contextDocs sorts documents oldest first.
+type contextDocs []*contextDoc
+
+func (d contextDocs) Len() int      { return len(d) }
+func (d contextDocs) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d contextDocs) Less(i, j int) bool {
+	if !d[i].time.Equal(d[j].time) {
+		return d[i].time.Before(d[j].time)
+	}
+	return d[i].seq < d[j].seq
+}
+
+// contextRuns sorts runs of documents by their first document.
+type contextRuns []contextDocs
+
+func (r contextRuns) Len() int           { return len(r) }
+func (r contextRuns) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r contextRuns) Less(i, j int) bool { return contextDocs{r[i][0], r[j][0]}.Less(0, 1) }
+
+// mergeContext merges the groups (the keys of a result and its
+// context) that share documents into runs, the runs and their
+// documents are sorted oldest first.
+func mergeContext(groups [][]string, docs map[string]*contextDoc) (runs contextRuns) {
+	parent := make([]int, len(groups))
+	find := func(i int) int {
+		for parent[i] != i {
+			i = parent[i]
+		}
+		return i
+	}
+	first := make(map[string]int)
+	for i, keys := range groups {
+		parent[i] = i
+		for _, key := range keys {
+			j, ok := first[key]
+			if !ok {
+				first[key] = i
+			} else if root := find(j); root != find(i) {
+				parent[root] = find(i)
+			}
+		}
+	}
+
+	merged := make(map[int]int)
+	added := make(map[string]bool)
+	for i, keys := range groups {
+		root := find(i)
+		n, ok := merged[root]
+		if !ok {
+			n = len(runs)
+			merged[root] = n
+			runs = append(runs, nil)
+		}
+		for _, key := range keys {
+			if !added[key] {
+				added[key] = true
+				runs[n] = append(runs[n], docs[key])
+			}
+		}
+	}
+	for _, run := range runs {
+		sort.Sort(run)
+	}
+	sort.Sort(runs)
+	return runs
+}
+
+// contextFormatter returns a function that collects each result along
+// with the documents from the same stream that surround it, they're
+// written to `out` when flushed. Results and their context that
+// overlap are merged, documents are written once in the order they
+// were logged and the results are marked even when they're also the
+// context of another. The results must be hits (RawResult), their ids
+// tell documents with the same content apart.
+func (c Config) contextFormatter(out io.Writer) (f func(lgrep.Result) error, flush func(), err error) {
+	marked, flushMarked, err := c.markedFormatter(out)
+	if err != nil {
+		return f, flushMarked, err
+	}
+	l, err := c.client()
+	if err != nil {
+		return f, flushMarked, err
+	}
+	opts := lgrep.ContextOptions{
+		Before:       c.contextBefore,
+		After:        c.contextAfter,
+		StreamFields: c.contextFields,
+	}
+	spec := c.searchOptions()
+	var (
+		docs   = make(map[string]*contextDoc)
+		groups [][]string
+	)
+
+	f = func(r lgrep.Result) error {
+		before, after, err := l.Context(r, opts, spec)
+		if err != nil {
+			log.Warn(errors.Annotate(err, "Could not retrieve the context of a result"))
+		}
+		group := append(append(before, r), after...)
+		keys := make([]string, len(group))
+		for i, doc := range group {
+			keys[i] = lgrep.ResultKey(doc)
+			d, ok := docs[keys[i]]
+			if !ok {
+				d = &contextDoc{result: doc, time: lgrep.ResultTime(doc), seq: len(docs)}
+				docs[keys[i]] = d
+			}
+			d.hit = d.hit || i == len(before)
+		}
+		groups = append(groups, keys)
+		return nil
+	}
+
+	flush = func() {
+		defer flushMarked()
+		for i, run := range mergeContext(groups, docs) {
+			if i != 0 {
+				marked(contextSeparator, nil)
+			}
+			for _, d := range run {
+				marker := contextMarker
+				if d.hit {
+					marker = contextMarkerHit
+				}
+				result, _ := c.hitResult(d.result)
+				if err := marked(marker, result); err != nil {
+					log.Warn(errors.Annotate(err, "error formatting result"))
+				}
+			}
+		}
+	}
+	return f, flush, nil
+}
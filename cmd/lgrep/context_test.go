@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+func TestContextFormatter(t *testing.T) {
+	server := lgreptest.NewServer()
+	defer server.Close()
+	// The same message is logged twice, only the ids tell them apart.
+	messages := []string{"one", "two", "three", "four", "five", "four", "seven"}
+	for i, message := range messages {
+		source := map[string]interface{}{
+			"@timestamp": fmt.Sprintf("2016-04-29T10:0%d:00Z", i),
+			"host":       "web1",
+			"service":    "sshd",
+			"message":    message,
+			"n":          i,
+		}
+		if err := server.Index("streams", "log", fmt.Sprintf("n%d", i), source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Another stream in between isn't part of the context.
+	other := map[string]interface{}{"@timestamp": "2016-04-29T10:05:30Z", "host": "web2", "service": "sshd", "message": "web2"}
+	if err := server.Index("streams", "log", "web2", other); err != nil {
+		t.Fatal(err)
+	}
+
+	run := Config{
+		endpoint:       server.URL,
+		queryIndex:     "streams",
+		querySize:      1,
+		contextBefore:  1,
+		contextAfter:   1,
+		formatTemplate: "{{.message}}",
+	}
+	l, err := run.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	f, flush, err := run.contextFormatter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The results come newest first, as they're searched by default.
+	// two and three are the context of one but results themselves,
+	// only seven's context is separated from the rest.
+	for _, n := range []int{6, 2, 1, 0} {
+		results, err := l.SimpleSearch(fmt.Sprintf("n:%d", n), run.searchOptions())
+		if err != nil || len(results) != 1 {
+			t.Fatalf("Could not find %d: %v", n, err)
+		}
+		if err = f(results[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected the results to be written once flushed, got:\n%s", out.String())
+	}
+	flush()
+	expected := "> one\n> two\n> three\n  four\n--\n  four\n> seven\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+var (
+	// CopyCommand copies the results of a query into an index of
+	// another cluster.
+	CopyCommand = cli.Command{
+		Name:      "copy",
+		Usage:     "Copy the documents matching the query into another index",
+		ArgsUsage: "QUERY",
+		Description: `The matching documents are read from the endpoint (or --file) and indexed
+   into the index given with --to using the bulk API, ex:
+
+   lgrep copy --to http://other:9200/incident-1234 -Qi 'logs-*' 'host:web1'
+
+   The documents are given new ids unless --keep-ids is given. Servers older
+   than Elasticsearch 7 are given the documents' types, or --type.`,
+		Action: RunCopy,
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "to",
+				Usage: "URL of the index the documents are copied into (ex: http://other:9200/incident-1234)",
+			},
+			cli.StringFlag{
+				Name:  "query-index, Qi",
+				Usage: "Query this index in elasticsearch, if not provided - all indicies",
+			},
+			cli.IntFlag{
+				Name:  "size, n",
+				Usage: "Copy at most N documents, all of the matching documents when 0",
+			},
+			cli.BoolFlag{
+				Name:  "keep-ids",
+				Usage: "Index the documents with their ids, replacing those of the target with the same ids",
+			},
+			cli.StringFlag{
+				Name:  "type",
+				Usage: "Type of the copied documents, for servers older than Elasticsearch 7 (default: their types)",
+			},
+		}, BulkFlags...),
+	}
+)
+
+// copyTarget is where documents are copied to.
+type copyTarget struct {
+	endpoint string
+	index    string
+}
+
+// parseCopyTarget splits the URL of the index into the endpoint of its
+// cluster and the index's name.
+func parseCopyTarget(rawurl string) (target copyTarget, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return target, errors.Errorf("The target '%s' must be the URL of an index (ex: http://other:9200/incident-1234)", rawurl)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	target.index = parts[len(parts)-1]
+	if target.index == "" || strings.ContainsAny(target.index, "*,") {
+		return target, errors.Errorf("The target '%s' must name a single index (ex: http://other:9200/incident-1234)", rawurl)
+	}
+	u.Path = strings.Join(parts[:len(parts)-1], "/") + "/"
+	u.RawQuery, u.Fragment = "", ""
+	target.endpoint = u.String()
+	return target, nil
+}
+
+// RunCopy copies the documents matching the query.
+func RunCopy(c *cli.Context) (err error) {
+	query := strings.Join(c.Args(), " ")
+	if query == "" || c.String("to") == "" {
+		return cli.NewExitError("A query and a target (--to) must be provided", 3)
+	}
+	target, err := parseCopyTarget(c.String("to"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 3)
+	}
+	if _, err = lgrep.ParseLucene(query); err != nil {
+		return queryError(err)
+	}
+
+	run := Config{endpoint: c.GlobalString("endpoint"), file: c.GlobalString("file")}
+	l, err := run.client()
+	if err != nil {
+		return queryError(err)
+	}
+	to, err := newClient(target.endpoint)
+	if err != nil {
+		log.Error(errors.Annotate(err, "Could not connect to the target"))
+		return err
+	}
+
+	spec := &lgrep.SearchOptions{
+		Index:      c.String("query-index"),
+		SortTime:   lgrep.SortAsc,
+		QueryDebug: c.GlobalBool("debug"),
+		RawResult:  true,
+	}
+	total, err := l.SimpleCount(query, spec)
+	if err != nil {
+		return queryError(err)
+	}
+	if size := int64(c.Int("size")); size > 0 && size < total {
+		total = size
+	}
+	if total == 0 {
+		log.Warn("0 results returned")
+		return nil
+	}
+	spec.Size = int(total)
+	indexer, err := newBulkIndexer(c, to, spec.Size)
+	if err != nil {
+		return err
+	}
+	stream, err := l.SimpleSearchStream(query, spec)
+	if err != nil {
+		indexer.Close()
+		return queryError(err)
+	}
+	err = stream.Each(func(r lgrep.Result) error {
+		hit, ok := r.(lgrep.HitResult)
+		if !ok || hit.Source == nil {
+			return errors.New("The documents' sources weren't returned")
+		}
+		id, typ := "", c.String("type")
+		if c.Bool("keep-ids") {
+			id = hit.Id
+		}
+		if typ == "" {
+			typ = hit.Type
+		}
+		indexer.Add(target.index, typ, id, hit.Source)
+		return nil
+	}, func(e error) error { return e })
+	if cerr := indexer.Close(); err == nil {
+		return cerr
+	}
+	log.Error(err)
+	return err
+}
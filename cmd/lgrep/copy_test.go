@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+func TestParseCopyTarget(t *testing.T) {
+	examples := map[string]copyTarget{
+		"http://other:9200/incident-1234":           {"http://other:9200/", "incident-1234"},
+		"https://user:pw@other/es/incident-1234/?x": {"https://user:pw@other/es/", "incident-1234"},
+	}
+	for rawurl, expected := range examples {
+		if target, err := parseCopyTarget(rawurl); err != nil || target != expected {
+			t.Errorf("Parsed %s as %+v (%v), expected %+v", rawurl, target, err, expected)
+		}
+	}
+	for _, invalid := range []string{"incident-1234", "http://other:9200/", "http://other:9200/logs-*"} {
+		if _, err := parseCopyTarget(invalid); err == nil {
+			t.Errorf("Expected %s not to parse", invalid)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	source := newTestServer(t)
+	defer source.Close()
+	target := lgreptest.NewServer()
+	defer target.Close()
+
+	args := []string{"lgrep", "-E", source.URL, "copy", "--to", target.URL + "/incident-1", "--batch-size", "2", "--keep-ids", "-Qi", "journald-*", "service:kernel"}
+	if err := App().Run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := lgrep.New(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := l.SimpleSearch("service:kernel", &lgrep.SearchOptions{Index: "incident-1", Type: "journald", Size: 10, RawResult: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Errorf("Copied %d documents, expected 3", len(results))
+	}
+	for _, r := range results {
+		if hit := r.(lgrep.HitResult); hit.Id[0] != 'j' {
+			t.Errorf("The id of %s wasn't kept", hit.Id)
+		}
+	}
+}
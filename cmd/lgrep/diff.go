@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+)
+
+const (
+	diffAppeared    = "appeared"
+	diffDisappeared = "disappeared"
+	diffIncreased   = "increased"
+	diffDecreased   = "decreased"
+
+	defaultDiffRatio = 2.0
+	defaultDiffTerms = 100
+)
+
+var (
+	// DiffCommand compares the values of a field between two windows
+	// of time.
+	DiffCommand = cli.Command{
+		Name:      "diff",
+		Usage:     "Compare the values of a field in a recent window of time to a baseline",
+		ArgsUsage: "QUERY",
+		Description: `The most common values of the field are counted in the last window of time
+   and in the same window of time the baseline ago, ex: with --window 1h and
+   --baseline 24h the last hour is compared to the same hour yesterday.
+
+   Values that appeared, disappeared or changed by more than the ratio are
+   printed, the most significant changes first. Only the most common values
+   (see --size) of each window are compared, so a value may appear only
+   because it wasn't among the most common of the baseline.`,
+		Action: RunDiff,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "field, f",
+				Usage: "Field whose values are compared (ex: service.raw)",
+			},
+			cli.DurationFlag{
+				Name:  "window, w",
+				Usage: "Length of the window of time compared",
+				Value: time.Hour,
+			},
+			cli.DurationFlag{
+				Name:  "baseline, b",
+				Usage: "How long before the window the baseline window is",
+				Value: 24 * time.Hour,
+			},
+			cli.Float64Flag{
+				Name:  "ratio, r",
+				Usage: "Values whose counts changed by more than this ratio are printed",
+				Value: defaultDiffRatio,
+			},
+			cli.IntFlag{
+				Name:  "size, n",
+				Usage: "Number of the most common values counted in each window",
+				Value: defaultDiffTerms,
+			},
+			cli.StringFlag{
+				Name:  "time-field",
+				Usage: "Field the windows of time apply to",
+				Value: "@timestamp",
+			},
+			cli.StringFlag{
+				Name:  "query-index, Qi",
+				Usage: "Query this index in elasticsearch, if not provided - all indicies",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Output the changes as JSON (1 line per value)",
+			},
+		},
+	}
+)
+
+// valueChange is how the count of a value changed between the
+// baseline and the current window.
+type valueChange struct {
+	Value    interface{} `json:"value"`
+	Change   string      `json:"change"`
+	Current  int64       `json:"current"`
+	Baseline int64       `json:"baseline"`
+	// Ratio is the current count over the baseline count, omitted
+	// when either is 0.
+	Ratio float64 `json:"ratio,omitempty"`
+	// Significance ranks the changes, it's the difference of the
+	// counts relative to the deviation expected of them by chance.
+	Significance float64 `json:"significance"`
+}
+
+// RunDiff compares the values of the field and prints the changes.
+func RunDiff(c *cli.Context) (err error) {
+	field := strings.TrimPrefix(c.String("field"), ".")
+	query := strings.Join(c.Args(), " ")
+	if field == "" || query == "" {
+		return cli.NewExitError("A field and a query must be provided", 3)
+	}
+	window, baseline := c.Duration("window"), c.Duration("baseline")
+	if window < time.Second || baseline < window {
+		return cli.NewExitError("The window must be at least 1s and the baseline at least the window", 3)
+	}
+	if c.Float64("ratio") <= 1 {
+		return cli.NewExitError("The ratio must be greater than 1", 3)
+	}
+
+	if _, err = lgrep.ParseLucene(query); err != nil {
+		return queryError(err)
+	}
+	l, err := newClient(c.GlobalString("endpoint"))
+	if err != nil {
+		return queryError(err)
+	}
+	spec := &lgrep.SearchOptions{
+		Index:      c.String("query-index"),
+		Size:       c.Int("size"),
+		QueryDebug: c.GlobalBool("debug"),
+	}
+	timeField := c.String("time-field")
+	current, err := l.SimpleTerms(windowQueryAgo(query, timeField, window, 0), field, spec)
+	if err != nil {
+		return queryError(err)
+	}
+	previous, err := l.SimpleTerms(windowQueryAgo(query, timeField, window, baseline), field, spec)
+	if err != nil {
+		return queryError(err)
+	}
+
+	changes := diffValues(field, current, previous, c.Float64("ratio"))
+	if c.Bool("json") {
+		for _, change := range changes {
+			data, err := json.Marshal(change)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "%s\n", data)
+		}
+		return nil
+	}
+	return printDiff(os.Stdout, field, changes)
+}
+
+// diffValues compares the counts of the field's values, returning
+// those that changed by more than the ratio with the most significant
+// first.
+func diffValues(field string, current, baseline []lgrep.Distinct, ratio float64) (changes []valueChange) {
+	counts := make(map[string]*valueChange)
+	var keys []string
+	count := func(values []lgrep.Distinct, current bool) {
+		for _, d := range values {
+			value := d.Values[field]
+			key := fmt.Sprint(value)
+			change, ok := counts[key]
+			if !ok {
+				change = &valueChange{Value: value}
+				counts[key] = change
+				keys = append(keys, key)
+			}
+			if current {
+				change.Current += d.Count
+			} else {
+				change.Baseline += d.Count
+			}
+		}
+	}
+	count(current, true)
+	count(baseline, false)
+
+	for _, key := range keys {
+		change := counts[key]
+		switch {
+		case change.Baseline == 0:
+			change.Change = diffAppeared
+		case change.Current == 0:
+			change.Change = diffDisappeared
+		default:
+			change.Ratio = float64(change.Current) / float64(change.Baseline)
+			switch {
+			case change.Ratio > ratio:
+				change.Change = diffIncreased
+			case change.Ratio < 1/ratio:
+				change.Change = diffDecreased
+			default:
+				continue
+			}
+		}
+		// The counts are treated as Poisson distributed, the
+		// difference is compared to its standard deviation.
+		diff := float64(change.Current - change.Baseline)
+		change.Significance = math.Abs(diff) / math.Sqrt(float64(change.Current+change.Baseline))
+		changes = append(changes, *change)
+	}
+	sort.Stable(bySignificance(changes))
+	return changes
+}
+
+// printDiff writes the changes as a table.
+func printDiff(out io.Writer, field string, changes []valueChange) error {
+	run := Config{
+		formatTemplate: fmt.Sprintf(".change .%s .current .baseline .ratio", field),
+		formatTabulate: true,
+	}
+	formatter, flush, err := run.markedFormatter(out)
+	if err != nil {
+		return err
+	}
+	defer flush()
+
+	for _, change := range changes {
+		ratio := "-"
+		if change.Ratio != 0 {
+			ratio = formatStat(change.Ratio)
+		}
+		row := map[string]interface{}{
+			"change":   change.Change,
+			field:      change.Value,
+			"current":  strconv.FormatInt(change.Current, 10),
+			"baseline": strconv.FormatInt(change.Baseline, 10),
+			"ratio":    ratio,
+		}
+		if err = formatter("", lgrep.NestFields(row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bySignificance sorts the changes with the most significant first.
+type bySignificance []valueChange
+
+func (s bySignificance) Len() int           { return len(s) }
+func (s bySignificance) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySignificance) Less(i, j int) bool { return s[i].Significance > s[j].Significance }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cogolabs/lgrep"
+)
+
+func TestWindowQueryAgo(t *testing.T) {
+	examples := []struct {
+		window, ago time.Duration
+		expected    string
+	}{
+		{time.Hour, 0, "(a:b) AND @timestamp:[now-3600s TO now]"},
+		{time.Hour, 24 * time.Hour, "(a:b) AND @timestamp:[now-90000s TO now-86400s]"},
+	}
+	for _, example := range examples {
+		if q := windowQueryAgo("a:b", "@timestamp", example.window, example.ago); q != example.expected {
+			t.Errorf("Expected '%s', got '%s'", example.expected, q)
+		}
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	distinct := func(counts map[string]int64) (values []lgrep.Distinct) {
+		for value, count := range counts {
+			values = append(values, lgrep.Distinct{Values: map[string]interface{}{"service": value}, Count: count})
+		}
+		return values
+	}
+	current := distinct(map[string]int64{"api": 100, "web": 400, "db": 10, "new": 50, "cache": 5})
+	baseline := distinct(map[string]int64{"api": 90, "web": 100, "db": 100, "old": 20})
+
+	changes := diffValues("service", current, baseline, 2)
+	expected := []struct {
+		value, change string
+	}{
+		{"web", diffIncreased},
+		{"db", diffDecreased},
+		{"new", diffAppeared},
+		{"old", diffDisappeared},
+		{"cache", diffAppeared},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %+v", len(expected), changes)
+	}
+	for i, e := range expected {
+		if changes[i].Value != e.value || changes[i].Change != e.change {
+			t.Errorf("Change %d: expected %s %s, got %+v", i, e.value, e.change, changes[i])
+		}
+	}
+	if changes[0].Ratio != 4 || changes[2].Ratio != 0 {
+		t.Errorf("Unexpected ratios %v and %v", changes[0].Ratio, changes[2].Ratio)
+	}
+}
+
+func TestPrintDiff(t *testing.T) {
+	changes := []valueChange{
+		{Value: "web", Change: diffIncreased, Current: 400, Baseline: 100, Ratio: 4},
+		{Value: "new", Change: diffAppeared, Current: 50},
+	}
+	var out bytes.Buffer
+	if err := printDiff(&out, "service.raw", changes); err != nil {
+		t.Fatal(err)
+	}
+	expected := `change     service.raw  current  baseline  ratio
+increased  web          400      100       4
+appeared   new          50       0         -
+`
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cogolabs/lgrep"
+)
+
+// distinctValues finds the unique values of the distinct fields. The
+// server aggregates the values of all of the matching documents when
+// it can, otherwise they're counted from the results on the client.
+func (c Config) distinctValues() (distinct []lgrep.Distinct, err error) {
+	aggregate, err := c.aggregateDistinct()
+	if err != nil {
+		return distinct, err
+	}
+	if !aggregate {
+		stream, err := c.searchStream()
+		if err != nil {
+			return distinct, err
+		}
+		return lgrep.DistinctStream(stream, c.distinct)
+	}
+
+	log.Debugf("Aggregating the distinct values of %v", c.distinct)
+	l, err := c.client()
+	if err != nil {
+		return distinct, err
+	}
+	return l.SimpleDistinct(c.query, c.distinct, c.searchOptions())
+}
+
+// aggregateDistinct determines if the distinct values are aggregated
+// by the server, which counts every matching document rather than the
+// size of the query. The values of files, query files and documents
+// matched on the client are counted on the client.
+func (c Config) aggregateDistinct() (aggregate bool, err error) {
+	if c.queryFile != "" || c.file != "" || len(c.queryMatches) != 0 {
+		return false, nil
+	}
+	for _, q := range c.lucenePatterns() {
+		if _, err = lgrep.ParseLucene(q); err != nil {
+			return false, err
+		}
+	}
+	l, err := c.client()
+	if err != nil {
+		return false, err
+	}
+	// Composite aggregations were added during 6.x, only the major
+	// version of the server is known.
+	return l.Version.APIVersion() >= 7, nil
+}
+
+// printDistinct writes each of the distinct values to `out`, prefixed
+// with their count when asked for.
+func (c Config) printDistinct(out io.Writer) (err error) {
+	formatter, flush, err := c.markedFormatter(out)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer flush()
+
+	distinct, err := c.distinctValues()
+	if err != nil {
+		return queryError(err)
+	}
+	if len(distinct) == 0 {
+		log.Warn("0 results returned")
+		return nil
+	}
+	for i := range distinct {
+		if c.formatReverse {
+			i = len(distinct) - 1 - i
+		}
+		marker := ""
+		if c.distinctCount {
+			marker = fmt.Sprintf("%7d ", distinct[i].Count)
+		}
+		if err = formatter(marker, distinct[i].Result()); err != nil {
+			log.Warn(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+func TestAggregateDistinct(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	server.Version = "7.17.9"
+
+	base := Config{endpoint: server.URL, query: "service:kernel", queryIndex: "journald-*", distinct: []string{"host"}}
+	examples := []struct {
+		size      int
+		matches   []lgrep.FieldMatch
+		file      string
+		aggregate bool
+	}{
+		// Whatever the size of the query, all of the documents are
+		// aggregated.
+		{100, nil, "", true},
+		{lgrep.MaxSearchSize + 1, nil, "", true},
+		// Matched on the client, the documents must be read.
+		{100, []lgrep.FieldMatch{{Field: "host", Pattern: regexp.MustCompile("^web")}}, "", false},
+		{100, nil, "query.json", false},
+	}
+	// Older servers can't page through composite aggregations.
+	old := newTestServer(t)
+	defer old.Close()
+	run := base
+	run.endpoint, run.querySize = old.URL, 100
+	if aggregate, err := run.aggregateDistinct(); err != nil || aggregate {
+		t.Errorf("Aggregated %t (%v) with Elasticsearch %s", aggregate, err, lgreptest.DefaultVersion)
+	}
+
+	for _, ex := range examples {
+		run := base
+		run.querySize, run.queryMatches, run.queryFile = ex.size, ex.matches, ex.file
+		if aggregate, err := run.aggregateDistinct(); err != nil || aggregate != ex.aggregate {
+			t.Errorf("Aggregated %t (%v) with -n %d, %d matches and query file %q, expected %t", aggregate, err, ex.size, len(ex.matches), ex.file, ex.aggregate)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+const (
+	// exporterPrefix is the prefix of the exporter's own metrics.
+	exporterPrefix = "lgrep_exporter_"
+
+	defaultExporterInterval = time.Minute
+	defaultExporterTimeout  = 10 * time.Second
+	defaultExporterTerms    = 10
+)
+
+var (
+	// ExporterCommand exposes query counts as Prometheus metrics.
+	ExporterCommand = cli.Command{
+		Name:      "exporter",
+		Usage:     "Expose the counts of queries as Prometheus metrics",
+		ArgsUsage: "METRICS_FILE",
+		Description: `The metrics file is JSON, ex:
+
+   {"metrics": [{
+     "name": "api_errors",
+     "help": "API errors in the last 5 minutes",
+     "query": "service:api AND status:500",
+     "index": "logstash-*",
+     "window": "5m",
+     "interval": "1m",
+     "timeout": "10s",
+     "labels": {"env": "prod"}
+   }, {
+     "name": "api_errors_by_host",
+     "saved": "api-errors",
+     "window": "5m",
+     "terms": "host.raw",
+     "label": "host",
+     "size": 20
+   }]}
+
+   Each metric is a gauge of the number of documents matching its query (or
+   saved search) in the last window, all documents when no window is given.
+   With terms, the gauge is labeled with each of the most common values of
+   the field instead. The queries are run on their interval in the
+   background, scrapes are given the latest values. A metric has no values
+   while its last query failed or timed out, and its query isn't run again
+   until a query that timed out finishes.`,
+		Action: RunExporter,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "listen",
+				Usage: "Address to serve /metrics on",
+				Value: ":9480",
+			},
+		},
+	}
+
+	// metricName matches valid Prometheus metric and label names.
+	metricName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// labelReplacer escapes Prometheus label values.
+	labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// exporterMetrics is the metrics file.
+type exporterMetrics struct {
+	Metrics []*exporterMetric `json:"metrics"`
+}
+
+// exporterMetric is a gauge of a query's count, or of the counts of
+// the terms of a field.
+type exporterMetric struct {
+	Name string `json:"name"`
+	Help string `json:"help"`
+	// Query is the lucene query counted, or Saved is the name of a
+	// saved search whose query is counted.
+	Query string `json:"query"`
+	Saved string `json:"saved"`
+	Index string `json:"index"`
+	// Window limits the count to the documents of the last window of
+	// time, documents of any time are counted when not given.
+	Window    watchDuration `json:"window"`
+	TimeField string        `json:"time_field"`
+	// Interval is how often the query is run.
+	Interval watchDuration `json:"interval"`
+	// Timeout is how long the query may run before it's abandoned.
+	Timeout watchDuration `json:"timeout"`
+	// Terms is a field whose most common values are each counted.
+	Terms string `json:"terms"`
+	// Label is the label the terms are given as, the field's name by
+	// default.
+	Label string `json:"label"`
+	// Size is the number of terms counted.
+	Size int `json:"size"`
+	// Labels are added to each of the metric's samples.
+	Labels map[string]string `json:"labels"`
+
+	mu sync.Mutex
+	// samples are the values of the metric's last query, none when it
+	// failed.
+	samples []exporterSample
+	// running is set while a query is running, even once it's been
+	// abandoned.
+	running bool
+	// queries, errors, skips and seconds count the queries run, those
+	// that failed, the refreshes skipped while a query was still
+	// running and the time the queries have taken.
+	queries int64
+	errors  int64
+	skips   int64
+	seconds float64
+}
+
+// exporterSample is a value of a metric and its label, if any.
+type exporterSample struct {
+	label string
+	value int64
+}
+
+// loadExporterMetrics reads and checks the metrics file, resolving the
+// saved searches from the configuration file.
+func loadExporterMetrics(path string, cfg *configFile) (metrics []*exporterMetric, err error) {
+	data, err := ioutil.ReadFile(expandPath(path))
+	if err != nil {
+		return metrics, errors.Annotate(err, "Could not read the metrics file")
+	}
+	var file exporterMetrics
+	if err = json.Unmarshal(data, &file); err != nil {
+		return metrics, errors.Annotate(err, "Could not parse the metrics file")
+	}
+	if len(file.Metrics) == 0 {
+		return metrics, errors.New("The metrics file has no metrics")
+	}
+	names := make(map[string]bool)
+	for _, m := range file.Metrics {
+		if !metricName.MatchString(m.Name) {
+			return metrics, errors.Errorf("Metric name '%s' must only have letters, digits and _", m.Name)
+		}
+		if names[m.Name] {
+			return metrics, errors.Errorf("Metric '%s' is given more than once", m.Name)
+		}
+		names[m.Name] = true
+		if m.Saved != "" {
+			saved, err := cfg.SavedSearch(m.Saved)
+			if err != nil {
+				return metrics, err
+			}
+			if saved.Query == "" {
+				return metrics, errors.Errorf("Saved search '%s' of metric '%s' has no lucene query", m.Saved, m.Name)
+			}
+			m.Query = saved.Query
+			if m.Index == "" {
+				m.Index = saved.Index
+			}
+		}
+		if m.Query == "" {
+			return metrics, errors.Errorf("Metric '%s' has no query", m.Name)
+		}
+		if _, err = lgrep.ParseLucene(m.Query); err != nil {
+			return metrics, errors.Annotatef(err, "Metric '%s' has an invalid query", m.Name)
+		}
+		if m.Terms != "" && m.Label == "" {
+			m.Label = strings.NewReplacer(".", "_", "@", "").Replace(m.Terms)
+		}
+		if m.Terms != "" && !metricName.MatchString(m.Label) {
+			return metrics, errors.Errorf("Metric '%s' label '%s' must only have letters, digits and _", m.Name, m.Label)
+		}
+		for label := range m.Labels {
+			if !metricName.MatchString(label) || label == m.Label {
+				return metrics, errors.Errorf("Metric '%s' has an invalid label '%s'", m.Name, label)
+			}
+		}
+		if m.Interval <= 0 {
+			m.Interval = watchDuration(defaultExporterInterval)
+		}
+		if m.Timeout <= 0 {
+			m.Timeout = watchDuration(defaultExporterTimeout)
+		}
+		if m.TimeField == "" {
+			m.TimeField = "@timestamp"
+		}
+		if m.Size == 0 {
+			m.Size = defaultExporterTerms
+		}
+		if m.Help == "" {
+			m.Help = fmt.Sprintf("Documents matching %s", m.Query)
+		}
+	}
+	return file.Metrics, nil
+}
+
+// lucene is the metric's query, limited to its window if any.
+func (m *exporterMetric) lucene() string {
+	if m.Window <= 0 {
+		return m.Query
+	}
+	return windowQuery(m.Query, m.TimeField, time.Duration(m.Window))
+}
+
+// exporterSearcher runs the metrics' queries, this is satisfied by
+// lgrep.LGrep.
+type exporterSearcher interface {
+	SimpleCount(q string, spec *lgrep.SearchOptions) (int64, error)
+	SimpleTerms(q string, field string, spec *lgrep.SearchOptions) ([]lgrep.Distinct, error)
+}
+
+// exporter keeps the metrics up to date and serves them.
+type exporter struct {
+	searcher exporterSearcher
+	metrics  []*exporterMetric
+}
+
+// Refresh runs the metric's query, its values are dropped when it
+// fails or times out. The refresh is skipped while the previous query
+// is still running, so that abandoned queries don't pile up.
+func (e *exporter) Refresh(m *exporterMetric) (err error) {
+	m.mu.Lock()
+	if m.running {
+		m.skips++
+		m.mu.Unlock()
+		return errors.Errorf("Skipped refreshing metric '%s', its previous query is still running", m.Name)
+	}
+	m.running = true
+	m.mu.Unlock()
+
+	type outcome struct {
+		samples []exporterSample
+		err     error
+	}
+	// The query is left to finish in the background when it times
+	// out, its outcome is dropped.
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		samples, err := e.query(m)
+		m.mu.Lock()
+		m.running = false
+		m.mu.Unlock()
+		done <- outcome{samples, err}
+	}()
+
+	var result outcome
+	select {
+	case result = <-done:
+	case <-time.After(time.Duration(m.Timeout)):
+		result.err = errors.Errorf("Query timed out after %s", time.Duration(m.Timeout))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries++
+	m.seconds += time.Since(start).Seconds()
+	if result.err != nil {
+		m.errors++
+		m.samples = nil
+		return errors.Annotatef(result.err, "Could not refresh metric '%s'", m.Name)
+	}
+	m.samples = result.samples
+	return nil
+}
+
+// query runs the metric's query.
+func (e *exporter) query(m *exporterMetric) (samples []exporterSample, err error) {
+	spec := &lgrep.SearchOptions{Index: m.Index, Size: m.Size}
+	if m.Terms == "" {
+		count, err := e.searcher.SimpleCount(m.lucene(), spec)
+		return []exporterSample{{value: count}}, err
+	}
+	terms, err := e.searcher.SimpleTerms(m.lucene(), m.Terms, spec)
+	if err != nil {
+		return samples, err
+	}
+	for _, t := range terms {
+		samples = append(samples, exporterSample{label: fmt.Sprint(t.Values[m.Terms]), value: t.Count})
+	}
+	return samples, nil
+}
+
+// Run refreshes each metric on its interval until quit is closed.
+func (e *exporter) Run(quit <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, m := range e.metrics {
+		wg.Add(1)
+		go func(m *exporterMetric) {
+			defer wg.Done()
+			ticker := time.NewTicker(time.Duration(m.Interval))
+			defer ticker.Stop()
+			for {
+				if err := e.Refresh(m); err != nil {
+					log.Error(err)
+				}
+				select {
+				case <-quit:
+					return
+				case <-ticker.C:
+				}
+			}
+		}(m)
+	}
+	wg.Wait()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	e.WriteMetrics(w)
+}
+
+// WriteMetrics writes the latest values of the metrics and the
+// exporter's own metrics.
+func (e *exporter) WriteMetrics(out io.Writer) {
+	for _, m := range e.metrics {
+		m.mu.Lock()
+		fmt.Fprintf(out, "# HELP %s %s\n", m.Name, strings.Replace(m.Help, "\n", " ", -1))
+		fmt.Fprintf(out, "# TYPE %s gauge\n", m.Name)
+		for _, s := range m.samples {
+			labels := m.Labels
+			if m.Terms != "" {
+				labels = make(map[string]string, len(m.Labels)+1)
+				for k, v := range m.Labels {
+					labels[k] = v
+				}
+				labels[m.Label] = s.label
+			}
+			fmt.Fprintf(out, "%s%s %d\n", m.Name, formatLabels(labels), s.value)
+		}
+		m.mu.Unlock()
+	}
+
+	own := []struct {
+		name, help, typ string
+		value           func(m *exporterMetric) string
+	}{
+		{"queries_total", "Queries run for each metric", "counter",
+			func(m *exporterMetric) string { return fmt.Sprint(m.queries) }},
+		{"query_errors_total", "Queries that failed or timed out for each metric", "counter",
+			func(m *exporterMetric) string { return fmt.Sprint(m.errors) }},
+		{"query_skips_total", "Refreshes skipped while the previous query of each metric was still running", "counter",
+			func(m *exporterMetric) string { return fmt.Sprint(m.skips) }},
+		{"query_duration_seconds_total", "Time spent running the queries of each metric", "counter",
+			func(m *exporterMetric) string { return fmt.Sprintf("%g", m.seconds) }},
+	}
+	for _, o := range own {
+		fmt.Fprintf(out, "# HELP %s%s %s\n", exporterPrefix, o.name, o.help)
+		fmt.Fprintf(out, "# TYPE %s%s %s\n", exporterPrefix, o.name, o.typ)
+		for _, m := range e.metrics {
+			m.mu.Lock()
+			fmt.Fprintf(out, "%s%s%s %s\n", exporterPrefix, o.name, formatLabels(map[string]string{"metric": m.Name}), o.value(m))
+			m.mu.Unlock()
+		}
+	}
+}
+
+// formatLabels formats the labels of a sample, sorted by name.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelReplacer.Replace(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// RunExporter serves the metrics until the server fails.
+func RunExporter(c *cli.Context) (err error) {
+	if c.Args().First() == "" {
+		return cli.NewExitError("A metrics file must be provided", 3)
+	}
+	cfg, err := loadConfigFile(c.GlobalString("config"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 3)
+	}
+	metrics, err := loadExporterMetrics(c.Args().First(), cfg)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 3)
+	}
+	l, err := newClient(c.GlobalString("endpoint"))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	e := &exporter{searcher: l, metrics: metrics}
+	quit := make(chan struct{})
+	go e.Run(quit)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		close(quit)
+		os.Exit(0)
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	addr := c.String("listen")
+	log.Infof("Serving %d metrics on %s/metrics", len(metrics), addr)
+	if err = http.ListenAndServe(addr, mux); err != nil {
+		log.Error(err)
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+// fakeExporterSearcher returns its count and terms, after its delay.
+type fakeExporterSearcher struct {
+	count int64
+	terms []lgrep.Distinct
+	delay time.Duration
+	err   error
+}
+
+func (f *fakeExporterSearcher) SimpleCount(q string, spec *lgrep.SearchOptions) (int64, error) {
+	time.Sleep(f.delay)
+	return f.count, f.err
+}
+
+func (f *fakeExporterSearcher) SimpleTerms(q string, field string, spec *lgrep.SearchOptions) ([]lgrep.Distinct, error) {
+	time.Sleep(f.delay)
+	return f.terms, f.err
+}
+
+func TestLoadExporterMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &configFile{Saved: map[string]savedSearch{
+		"errors": {Query: "status:500", Index: "logstash-*"},
+		"raw":    {QueryFile: "query.json"},
+	}}
+
+	examples := []struct {
+		metrics string
+		err     string
+	}{
+		{`{"metrics": [{"name": "errors", "query": "status:500"}]}`, ""},
+		{`{"metrics": [{"name": "errors", "saved": "errors", "terms": "host.raw"}]}`, ""},
+		{`{"metrics": []}`, "no metrics"},
+		{`{"metrics": [{"name": "api-errors", "query": "status:500"}]}`, "must only have"},
+		{`{"metrics": [{"name": "errors", "query": "a"}, {"name": "errors", "query": "b"}]}`, "more than once"},
+		{`{"metrics": [{"name": "errors"}]}`, "has no query"},
+		{`{"metrics": [{"name": "errors", "saved": "raw"}]}`, "no lucene query"},
+		{`{"metrics": [{"name": "errors", "query": "status:(500"}]}`, "invalid query"},
+		{`{"metrics": [{"name": "errors", "query": "a", "labels": {"env-name": "prod"}}]}`, "invalid label"},
+	}
+	for i, example := range examples {
+		path := filepath.Join(dir, "metrics.json")
+		if err = ioutil.WriteFile(path, []byte(example.metrics), 0600); err != nil {
+			t.Fatal(err)
+		}
+		metrics, err := loadExporterMetrics(path, cfg)
+		if example.err != "" {
+			if err == nil || !strings.Contains(err.Error(), example.err) {
+				t.Errorf("Example %d: expected error '%s', got %v", i, example.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Example %d: %s", i, err)
+			continue
+		}
+		m := metrics[0]
+		if time.Duration(m.Interval) != defaultExporterInterval || time.Duration(m.Timeout) != defaultExporterTimeout {
+			t.Errorf("Example %d: expected the default interval and timeout, got %s and %s",
+				i, time.Duration(m.Interval), time.Duration(m.Timeout))
+		}
+		if m.Query != "status:500" {
+			t.Errorf("Example %d: expected query 'status:500', got '%s'", i, m.Query)
+		}
+		if m.Terms != "" && (m.Label != "host_raw" || m.Index != "logstash-*") {
+			t.Errorf("Example %d: expected label host_raw of logstash-*, got %s of %s", i, m.Label, m.Index)
+		}
+	}
+}
+
+func TestExporterRefresh(t *testing.T) {
+	count := &exporterMetric{Name: "errors", Help: "Errors", Query: "status:500",
+		Timeout: watchDuration(time.Second), Labels: map[string]string{"env": "prod"}}
+	terms := &exporterMetric{Name: "errors_by_host", Help: "Errors by host", Query: "status:500",
+		Timeout: watchDuration(time.Second), Terms: "host.raw", Label: "host"}
+	searcher := &fakeExporterSearcher{count: 42, terms: []lgrep.Distinct{
+		{Values: map[string]interface{}{"host.raw": "web1"}, Count: 30},
+		{Values: map[string]interface{}{"host.raw": `we"b2`}, Count: 12},
+	}}
+	e := &exporter{searcher: searcher, metrics: []*exporterMetric{count, terms}}
+	for _, m := range e.metrics {
+		if err := e.Refresh(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Failures and timeouts drop the previous values.
+	searcher.err = errors.New("unavailable")
+	if err := e.Refresh(count); err == nil {
+		t.Error("Expected the refresh to fail")
+	}
+	searcher.err, searcher.count = nil, 0
+	terms.Timeout = watchDuration(10 * time.Millisecond)
+	searcher.delay = 200 * time.Millisecond
+	start := time.Now()
+	if err := e.Refresh(terms); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the refresh to time out, got %v", err)
+	}
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Errorf("Expected the refresh to be abandoned, took %s", took)
+	}
+	// The abandoned query is still running.
+	if err := e.Refresh(terms); err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Errorf("Expected the refresh to be skipped, got %v", err)
+	}
+
+	var out bytes.Buffer
+	e.WriteMetrics(&out)
+	for _, expected := range []string{
+		"# HELP errors Errors\n# TYPE errors gauge\n# HELP",
+		"# TYPE errors_by_host gauge\n# HELP",
+		"lgrep_exporter_queries_total{metric=\"errors\"} 2\n",
+		"lgrep_exporter_queries_total{metric=\"errors_by_host\"} 2\n",
+		"lgrep_exporter_query_errors_total{metric=\"errors\"} 1\n",
+		"lgrep_exporter_query_errors_total{metric=\"errors_by_host\"} 1\n",
+		"lgrep_exporter_query_skips_total{metric=\"errors_by_host\"} 1\n",
+		"# TYPE lgrep_exporter_query_duration_seconds_total counter\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the metrics to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+
+	// Once the abandoned query finishes, the metric is refreshed again.
+	for running := true; running; {
+		time.Sleep(10 * time.Millisecond)
+		terms.mu.Lock()
+		running = terms.running
+		terms.mu.Unlock()
+	}
+	terms.Timeout = watchDuration(time.Second)
+	searcher.delay = 0
+	if err := e.Refresh(terms); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	e.WriteMetrics(&out)
+	if expected := "errors_by_host{host=\"web1\"} 30\nerrors_by_host{host=\"we\\\"b2\"} 12\n"; !strings.Contains(out.String(), expected) {
+		t.Errorf("Expected the metrics to contain %q, got:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// historyLimit is the number of lines of history kept.
+	historyLimit = 1000
+	// wordBreaks separate the word being completed from the rest of
+	// the line.
+	wordBreaks = " \t()+-!\"'"
+
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyTab       = 9
+	keyLineFeed  = 10
+	keyEnter     = 13
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyBackspace = 127
+	keyCtrlH     = 8
+)
+
+// errInterrupted is returned when the line is abandoned with Ctrl-C.
+var errInterrupted = errors.New("Interrupted")
+
+// lineEditor reads lines from a terminal with editing, history and tab
+// completion. When the input isn't a terminal, lines are read as they
+// are.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// fd is the terminal's file descriptor, -1 if the input isn't a
+	// terminal.
+	fd int
+
+	// history holds the lines previously entered, oldest first.
+	history     []string
+	historyFile string
+	// complete returns the completions of the word being typed.
+	complete func(word string) []string
+}
+
+// newLineEditor creates an editor reading from stdin, the history is
+// loaded from the file when given.
+func newLineEditor(historyFile string, complete func(string) []string) *lineEditor {
+	e := &lineEditor{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		fd:          -1,
+		historyFile: historyFile,
+		complete:    complete,
+	}
+	if isTerminal(int(os.Stdin.Fd())) {
+		e.fd = int(os.Stdin.Fd())
+	}
+	if historyFile != "" {
+		if data, err := os.Open(historyFile); err == nil {
+			scanner := bufio.NewScanner(data)
+			for scanner.Scan() {
+				e.history = append(e.history, scanner.Text())
+			}
+			data.Close()
+		}
+		if len(e.history) > historyLimit {
+			e.history = e.history[len(e.history)-historyLimit:]
+		}
+	}
+	return e
+}
+
+// ReadLine prompts for and reads a line, io.EOF is returned when the
+// input is closed (or Ctrl-D is pressed on an empty line) and
+// errInterrupted when Ctrl-C is pressed.
+func (e *lineEditor) ReadLine(prompt string) (line string, err error) {
+	if e.fd == -1 {
+		line, err = e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return line, errors.Annotate(err, "Could not read from the terminal")
+	}
+	defer restore()
+	return e.edit(prompt)
+}
+
+// AddHistory records the line in the history, persisting it to the
+// history file.
+func (e *lineEditor) AddHistory(line string) error {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	if n := len(e.history); n != 0 && e.history[n-1] == line {
+		return nil
+	}
+	e.history = append(e.history, line)
+	if e.historyFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Annotate(err, "Could not save the history")
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
+
+// edit reads keys from the raw terminal, editing the line until it's
+// entered.
+func (e *lineEditor) edit(prompt string) (string, error) {
+	var (
+		line    []rune
+		pos     int
+		histPos = len(e.history)
+		// pending is the line being typed while browsing the history.
+		pending []rune
+	)
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(l []rune) {
+		line = append([]rune{}, l...)
+		pos = len(line)
+	}
+	redraw()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return string(line), err
+		}
+		switch r {
+		case keyEnter, keyLineFeed:
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case keyBackspace, keyCtrlH:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case keyCtrlA:
+			pos = 0
+		case keyCtrlE:
+			pos = len(line)
+		case keyCtrlU:
+			line = line[pos:]
+			pos = 0
+		case keyTab:
+			line, pos = e.completeLine(line, pos)
+		case keyEscape:
+			seq := e.escapeSequence()
+			switch seq {
+			case "[A", "OA":
+				if histPos > 0 {
+					if histPos == len(e.history) {
+						pending = line
+					}
+					histPos--
+					setLine([]rune(e.history[histPos]))
+				}
+			case "[B", "OB":
+				if histPos < len(e.history) {
+					histPos++
+					if histPos == len(e.history) {
+						setLine(pending)
+					} else {
+						setLine([]rune(e.history[histPos]))
+					}
+				}
+			case "[C", "OC":
+				if pos < len(line) {
+					pos++
+				}
+			case "[D", "OD":
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH":
+				pos = 0
+			case "[F", "OF":
+				pos = len(line)
+			}
+		default:
+			if r < ' ' || r == utf8.RuneError {
+				continue
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// escapeSequence reads the remainder of an escape sequence (ex: "[A"
+// for the up arrow).
+func (e *lineEditor) escapeSequence() string {
+	first, err := e.in.ReadByte()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+	seq := []byte{first}
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, b)
+		// The sequence ends with its final letter (or ~).
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || b == '~' {
+			return string(seq)
+		}
+	}
+}
+
+// completeLine completes the word before the cursor. A single
+// completion replaces the word, otherwise the word is extended as far
+// as the completions agree and they're listed when it can't be.
+func (e *lineEditor) completeLine(line []rune, pos int) ([]rune, int) {
+	if e.complete == nil {
+		return line, pos
+	}
+	start := strings.LastIndexAny(string(line[:pos]), wordBreaks) + 1
+	// The index is of bytes, the word's start is needed in runes.
+	start = utf8.RuneCountInString(string(line[:pos])[:start])
+	word := string(line[start:pos])
+
+	completions := e.complete(word)
+	if len(completions) == 0 {
+		return line, pos
+	}
+	completion := commonPrefix(completions)
+	if len(completions) > 1 && completion == word {
+		sort.Strings(completions)
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(completions, "  "))
+		return line, pos
+	}
+	replaced := append(append(append([]rune{}, line[:start]...), []rune(completion)...), line[pos:]...)
+	return replaced, start + utf8.RuneCountInString(completion)
+}
+
+// commonPrefix returns the longest prefix that all the strings share.
+func commonPrefix(strs []string) string {
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
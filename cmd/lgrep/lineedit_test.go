@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineEditorEdit(t *testing.T) {
+	complete := func(word string) (completions []string) {
+		for _, field := range []string{"host:", "hostname:", "service:"} {
+			if strings.HasPrefix(field, word) {
+				completions = append(completions, field)
+			}
+		}
+		return completions
+	}
+	examples := []struct {
+		keys     string
+		expected string
+		err      error
+	}{
+		{"error\r", "error", nil},
+		{"errx\x7for\r", "error", nil},
+		{"rror\x01e\r", "error", nil},
+		{"err\x1b[D\x1b[Dx\r", "exrr", nil},
+		{"\x1b[A\r", "second", nil},
+		{"\x1b[A\x1b[A\r", "first", nil},
+		{"new\x1b[A\x1b[B\r", "new", nil},
+		{"se\t\r", "service:", nil},
+		{"error AND ho\t\r", "error AND host", nil},
+		{"(hostn\tweb1)\r", "(hostname:web1)", nil},
+		{"abc\x15x\r", "x", nil},
+		{"error\x03", "", errInterrupted},
+		{"\x04", "", io.EOF},
+	}
+	for _, ex := range examples {
+		var out bytes.Buffer
+		e := &lineEditor{
+			in:       bufio.NewReader(strings.NewReader(ex.keys)),
+			out:      &out,
+			history:  []string{"first", "second"},
+			complete: complete,
+		}
+		line, err := e.edit("> ")
+		if line != ex.expected || err != ex.err {
+			t.Errorf("Keys %q => %q, %v (expected %q, %v)", ex.keys, line, err, ex.expected, ex.err)
+		}
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	if p := commonPrefix([]string{"host:", "hostname:", "hostgroup:"}); p != "host" {
+		t.Errorf("Common prefix '%s'", p)
+	}
+	if p := commonPrefix([]string{"host:", "service:"}); p != "" {
+		t.Errorf("Common prefix '%s'", p)
+	}
+}
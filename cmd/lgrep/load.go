@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+var (
+	// LoadCommand indexes the documents of dumps, the reverse of
+	// searching with -j or -J.
+	LoadCommand = cli.Command{
+		Name:      "load",
+		Usage:     "Index the documents of files written with -j or -J into the endpoint",
+		ArgsUsage: "FILE...",
+		Description: `The documents are read from the files (1 per line, .gz or .zst compressed or
+   not) and indexed into the endpoint with the bulk API, ex:
+
+   lgrep -Qi 'logs-*' --save-mapping mapping.json -J -n 10000 'host:web1' > dump.ndjson
+   lgrep -E http://localhost:9200 load --index repro --mapping mapping.json dump.ndjson
+
+   Entire hits (-J) are indexed with their _index, _type and _id, unless
+   --override-index is given, while sources (-j) are indexed into --index.
+   Hits without their _source have nothing to index and are skipped.
+   With --mapping, the indices that don't exist yet are created with the
+   mapping saved by --save-mapping, made compatible with the endpoint.`,
+		Action: RunLoad,
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "index",
+				Usage: "Index the documents that don't have an _index are loaded into",
+			},
+			cli.BoolFlag{
+				Name:  "override-index",
+				Usage: "Load all of the documents into --index, even those with an _index",
+			},
+			cli.StringFlag{
+				Name:  "mapping",
+				Usage: "Create the indices with the mapping saved in the FILE by --save-mapping",
+			},
+		}, BulkFlags...),
+	}
+)
+
+// loadIndex returns the index the hit is loaded into.
+func loadIndex(hit *elastic.SearchHit, index string, override bool) string {
+	if override || hit.Index == "" {
+		return index
+	}
+	return hit.Index
+}
+
+// eachHit calls fn with the hits of the dump at the path as they're
+// read, with their number in the dump.
+func eachHit(path string, fn func(n int, hit *elastic.SearchHit) error) error {
+	r, err := lgrep.OpenDump(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hits := lgrep.NewHitReader(r)
+	for {
+		hit, err := hits.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Annotatef(err, "Could not read %s", path)
+		}
+		if err = fn(hits.N, hit); err != nil {
+			return err
+		}
+	}
+}
+
+// RunLoad indexes the documents of the files, they're read as they're
+// indexed rather than all at once.
+func RunLoad(c *cli.Context) (err error) {
+	if len(c.Args()) == 0 {
+		return cli.NewExitError("The files to load must be provided", 3)
+	}
+	index, override := c.String("index"), c.Bool("override-index")
+	if override && index == "" {
+		return cli.NewExitError("The index to load the documents into (--index) must be provided", 3)
+	}
+
+	// The types of each index, for servers that require types. The
+	// files are read beforehand when the indices must be created or
+	// documents may not have one, so nothing's indexed if one doesn't.
+	indices := make(map[string]map[string]bool)
+	mapping := c.String("mapping")
+	if mapping != "" || index == "" {
+		for _, path := range c.Args() {
+			err = eachHit(path, func(n int, hit *elastic.SearchHit) error {
+				name := loadIndex(hit, index, override)
+				if name == "" {
+					return errors.Errorf("Document %d of %s doesn't have an _index, one must be provided (--index)", n, path)
+				}
+				if indices[name] == nil {
+					indices[name] = make(map[string]bool)
+				}
+				indices[name][hit.Type] = true
+				return nil
+			})
+			if err != nil {
+				return cli.NewExitError(err.Error(), 3)
+			}
+		}
+	}
+
+	l, err := newClient(c.GlobalString("endpoint"))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if mapping != "" {
+		if err = createIndices(l, mapping, indices); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	indexer, err := newBulkIndexer(c, l, 0)
+	if err != nil {
+		return err
+	}
+	for _, path := range c.Args() {
+		err = eachHit(path, func(n int, hit *elastic.SearchHit) error {
+			// Hits dumped without their source (ex: searched with
+			// _source disabled) have nothing to index.
+			if hit.Source == nil {
+				log.Warnf("Skipping document %d of %s, it doesn't have a _source", n, path)
+				indexer.Skip()
+				return nil
+			}
+			indexer.Add(loadIndex(hit, index, override), hit.Type, hit.Id, hit.Source)
+			return nil
+		})
+		if err != nil {
+			indexer.Close()
+			return cli.NewExitError(err.Error(), 3)
+		}
+	}
+	return indexer.Close()
+}
+
+// createIndices creates the indices that don't exist yet with the
+// mapping saved at the path.
+func createIndices(l lgrep.LGrep, path string, indices map[string]map[string]bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Annotate(err, "Could not read the mapping")
+	}
+	var captured map[string]interface{}
+	if err = json.Unmarshal(data, &captured); err != nil {
+		return errors.Annotatef(err, "Could not parse the mapping %s", path)
+	}
+
+	names := make([]string, 0, len(indices))
+	for name := range indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var types []string
+		for typ := range indices[name] {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		mappings, err := lgrep.IndexMappings(captured, name, types, l.Version)
+		if err != nil {
+			return err
+		}
+		switch err = l.CreateIndex(name, mappings); err {
+		case nil:
+			log.Infof("Created %s with the mapping", name)
+		case lgrep.ErrIndexExists:
+			log.Warnf("%s already exists, its mapping is kept", name)
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := newTestServer(t)
+	defer source.Close()
+	target := lgreptest.NewServer()
+	defer target.Close()
+
+	mapping := filepath.Join(dir, "mapping.json")
+	args := []string{"lgrep", "-E", source.URL, "-Qi", "journald-*", "--save-mapping", mapping, "-n", "1", "*"}
+	if err = App().Run(args); err != nil {
+		t.Fatal(err)
+	}
+	sources := filepath.Join(dir, "sources.ndjson")
+	// Hits dumped without their source are skipped rather than failing.
+	dump := `{"host": "web9", "message": "Disk full", "extra": true}
+{"_index": "repro", "_id": "nosource"}
+{"_index": "repro", "_id": "nullsource", "_source": null}
+`
+	if err = ioutil.WriteFile(sources, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args = []string{"lgrep", "-E", target.URL, "load", "--index", "repro", "--mapping", mapping, "../../test/fixtures/journald.ndjson", sources}
+	if err = App().Run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := lgrep.New(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := l.SimpleSearch("host:web1", &lgrep.SearchOptions{Index: "journald-*", Size: 10, RawResult: true})
+	if err != nil || len(results) != 4 {
+		t.Fatalf("Loaded %d documents of web1: %v", len(results), err)
+	}
+	for _, r := range results {
+		if hit := r.(lgrep.HitResult); hit.Type != "journald" || hit.Id[0] != 'j' {
+			t.Errorf("Loaded %s/%s/%s, expected the original type and id", hit.Index, hit.Type, hit.Id)
+		}
+	}
+	// The mapping was saved before the documents were loaded, so the
+	// extra field isn't mapped.
+	fields, err := l.Fields(&lgrep.SearchOptions{Index: "repro"})
+	expected := []string{"@timestamp", "host", "message", "service", "type"}
+	if err != nil || !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Mapped repro with %v (%v), expected %v", fields, err, expected)
+	}
+	if count, err := l.SimpleCount("host:web9", &lgrep.SearchOptions{Index: "repro"}); err != nil || count != 1 {
+		t.Errorf("Loaded %d sources into repro: %v", count, err)
+	}
+	if count, err := l.SimpleCount("*", &lgrep.SearchOptions{Index: "repro"}); err != nil || count != 1 {
+		t.Errorf("Loaded %d documents into repro, expected the hits without sources to be skipped: %v", count, err)
+	}
+}
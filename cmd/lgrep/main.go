@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"regexp"
@@ -25,11 +26,19 @@ const (
 	StdlineFormat = ".timestamp.Local .host .service .message"
 )
 
+var (
+	// transport sends the requests made to Elasticsearch, the
+	// default transport unless a cassette is recorded or replayed.
+	transport http.RoundTripper
+	// recorder records the cassette given with --record.
+	recorder *lgrep.Recorder
+)
+
 var (
 	// GlobalFlags apply to the entire application
 	GlobalFlags = []cli.Flag{
 		cli.StringFlag{
-			Name:   "endpoint, e",
+			Name:   "endpoint, E",
 			Value:  "http://localhost:9200/",
 			Usage:  "Elasticsearch Endpoint",
 			EnvVar: "LGREP_ENDPOINT",
@@ -43,6 +52,26 @@ var (
 			Name:  "check-for-updates, U",
 			Usage: "Check github for a new release",
 		},
+		// -v inverts the match as it does with grep, so the version
+		// has no short flag.
+		cli.BoolFlag{
+			Name:  "version",
+			Usage: "print the version",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Value:  DefaultConfigFile,
+			Usage:  "Configuration file holding saved searches",
+			EnvVar: "LGREP_CONFIG",
+		},
+		cli.StringFlag{
+			Name:  "record",
+			Usage: "Record the requests made to Elasticsearch, and its responses, in a cassette FILE (credentials are scrubbed)",
+		},
+		cli.StringFlag{
+			Name:  "replay",
+			Usage: "Replay the responses recorded in a cassette FILE rather than requesting Elasticsearch",
+		},
 	}
 
 	// QueryFlags apply to runs that query with lgrep
@@ -90,6 +119,96 @@ var (
 			Name:  "query-file, Qf",
 			Usage: "Raw elasticsearch json query to submit",
 		},
+		cli.StringFlag{
+			Name:  "output-file, o",
+			Usage: "Write the results to files named by the PATTERN (ex: export-%03d.ndjson.gz), compressed when named .gz or .zst",
+		},
+		cli.StringFlag{
+			Name:  "rotate-size",
+			Usage: "Rotate the output files once they reach the size (ex: 100M)",
+		},
+		cli.IntFlag{
+			Name:  "rotate-count",
+			Usage: "Rotate the output files once they hold N results",
+		},
+		cli.StringFlag{
+			Name:  "checkpoint",
+			Usage: "Save where the export to the output files is in this file (default: PATTERN.checkpoint)",
+		},
+		cli.StringFlag{
+			Name:  "save-mapping",
+			Usage: "Save the mapping of the searched indices to a FILE, for lgrep load --mapping",
+		},
+		cli.BoolFlag{
+			Name:  "resume",
+			Usage: "Resume the export to the output files from its checkpoint",
+		},
+		cli.StringFlag{
+			Name:  "file",
+			Usage: "Search the JSON documents of a file (1 per line, .gz or .zst compressed or not) instead of Elasticsearch",
+		},
+		cli.StringSliceFlag{
+			Name:  "pattern, e",
+			Usage: "Lucene query to match, may be repeated to match any of them (see --all)",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "Match all of the queries given with -e instead of any of them",
+		},
+		cli.BoolFlag{
+			Name:  "invert-match, v",
+			Usage: "Return the documents that do not match the query",
+		},
+		cli.StringFlag{
+			Name:  "sort",
+			Usage: "Sort the results by fields (ex: field1:asc,field2:desc), timestamp breaks ties",
+		},
+		cli.BoolFlag{
+			Name:  "reverse, chrono",
+			Usage: "Print the results oldest first, while still searching for the newest (like tail)",
+		},
+		cli.StringSliceFlag{
+			Name:  "match",
+			Usage: "Only return results whose FIELD=REGEX (or FIELD!~REGEX doesn't), matched on the client",
+		},
+		cli.IntFlag{
+			Name:  "after-context, A",
+			Usage: "Show N documents from the same stream after each result",
+		},
+		cli.IntFlag{
+			Name:  "before-context, B",
+			Usage: "Show N documents from the same stream before each result",
+		},
+		cli.IntFlag{
+			Name:  "context, C",
+			Usage: "Show N documents from the same stream before and after each result",
+		},
+		cli.StringFlag{
+			Name:  "context-fields",
+			Usage: "Fields that documents share with a result to be in the same stream",
+			Value: strings.Join(lgrep.DefaultStreamFields, ","),
+		},
+		cli.StringFlag{
+			Name:  "distinct",
+			Usage: "Print each unique combination of the template's fields once (ex: '.host .service'), aggregated over all of the matching documents by Elasticsearch 7 or later",
+		},
+		cli.BoolFlag{
+			Name:  "count, c",
+			Usage: "Prefix each of the distinct values with the number of documents that had them",
+		},
+		cli.BoolFlag{
+			Name:  "cluster",
+			Usage: "Group the messages into patterns, printing each with its count, time range and an example",
+		},
+		cli.StringFlag{
+			Name:  "cluster-field",
+			Usage: "Field whose values are grouped into patterns with --cluster",
+			Value: lgrep.DefaultClusterField,
+		},
+		cli.BoolFlag{
+			Name:  "strict",
+			Usage: "Check the fields used in the query and format exist before searching",
+		},
 	}
 )
 
@@ -99,14 +218,30 @@ func App() *cli.App {
 	app.Name = "lgrep"
 	app.Version = fmt.Sprintf("%s (%s)", Version, Commit)
 	app.EnableBashCompletion = true
+	// The version is printed by RunPrepareApp, cli's own flag would
+	// take -v.
+	app.HideVersion = true
 
 	// Set up the application based on flags before handing off to the action
 	app.Before = RunPrepareApp
+	app.After = RunCloseApp
 	app.Action = RunQuery
 	app.OnUsageError = RunCheckUpdateOnError
-	app.UsageText = "lgrep [options] QUERY"
+	app.UsageText = "lgrep [options] QUERY\n   lgrep [options] @SAVED [QUERY]"
 	app.Flags = append(app.Flags, GlobalFlags...)
 	app.Flags = append(app.Flags, QueryFlags...)
+	app.Commands = []cli.Command{
+		SavedCommand,
+		ValidateCommand,
+		StatsCommand,
+		ShellCommand,
+		ServeCommand,
+		WatchCommand,
+		ExporterCommand,
+		DiffCommand,
+		CopyCommand,
+		LoadCommand,
+	}
 	app.Usage = `
 
 Reference time: Mon Jan 2 15:04:05 -0700 MST 2006
@@ -127,12 +262,48 @@ func dumpFlags(c *cli.Context) (err error) {
 	return nil
 }
 
+var (
+	// localHostPort matches the host:port endpoints that can't be
+	// mistaken for a lucene field query.
+	localHostPort = regexp.MustCompile(`^(localhost|\d{1,3}(\.\d{1,3}){3}|\[[0-9A-Fa-f:.]+\]):\d{1,5}/?$`)
+	// dottedHostPort matches hostnames with a port, which are also
+	// field queries such as http.status:500.
+	dottedHostPort = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+:\d{1,5}/?$`)
+)
+
+// endpointPatterns separates an endpoint given with -e from the
+// patterns, the last one is the endpoint. URLs and local host:port
+// values are endpoints, other host:port values are returned as
+// ambiguous while being kept as patterns.
+func endpointPatterns(values []string) (endpoint string, patterns, ambiguous []string) {
+	for _, v := range values {
+		if u, err := url.Parse(v); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			endpoint = v
+			continue
+		}
+		if localHostPort.MatchString(v) {
+			endpoint = "http://" + v
+			continue
+		}
+		if dottedHostPort.MatchString(v) {
+			ambiguous = append(ambiguous, v)
+		}
+		patterns = append(patterns, v)
+	}
+	return endpoint, patterns, ambiguous
+}
+
 // RunPrepareApp sets defaults and verifies the arguments and flags
 // passed to the application.
 func RunPrepareApp(c *cli.Context) (err error) {
 	// query might have been provided via a file or another flag
 	var queryProvided bool
 
+	if c.Bool("version") {
+		cli.ShowVersion(c)
+		os.Exit(0)
+	}
+
 	if c.Bool("check-for-updates") {
 		update, err := checkForUpdates(Version)
 		if err != nil {
@@ -146,6 +317,18 @@ func RunPrepareApp(c *cli.Context) (err error) {
 		os.Exit(0)
 	}
 
+	// -e was the endpoint's short flag before it was given to the
+	// patterns, URLs are still taken as the endpoint.
+	endpoint, patterns, ambiguous := endpointPatterns(c.StringSlice("pattern"))
+	for _, v := range ambiguous {
+		log.Warnf("-e %s is taken as a pattern, use -E http://%s if it's the endpoint", v, v)
+	}
+	if endpoint != "" {
+		log.Warnf("-e is for patterns now, use -E %s for the endpoint", endpoint)
+		*c.Generic("pattern").(*cli.StringSlice) = patterns
+		c.Set("endpoint", endpoint)
+	}
+
 	if endpoint := c.String("endpoint"); endpoint == "" {
 		return cli.NewExitError("Endpoint must be set", 1)
 	} else if _, err := url.Parse(endpoint); err != nil {
@@ -161,6 +344,23 @@ func RunPrepareApp(c *cli.Context) (err error) {
 		c.Set("format", StdlineFormat)
 	}
 
+	if c.IsSet("record") && c.IsSet("replay") {
+		return cli.NewExitError("A cassette can't be both recorded and replayed", 1)
+	}
+	if path := c.String("record"); path != "" {
+		if recorder, err = lgrep.NewRecorder(path, nil); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		transport = recorder
+	}
+	if path := c.String("replay"); path != "" {
+		replayer, err := lgrep.NewReplayer(path)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		transport = replayer
+	}
+
 	if c.Bool("debug") {
 		log.SetLevel(log.DebugLevel)
 		log.Debug("Using debug level logging")
@@ -172,12 +372,18 @@ func RunPrepareApp(c *cli.Context) (err error) {
 		if _, err := os.Stat(c.String("query-file")); err != nil {
 			return cli.NewExitError("Query file provided cannot be read", 3)
 		}
+		if len(c.StringSlice("pattern")) != 0 || c.Bool("invert-match") {
+			return cli.NewExitError("Patterns (-e) and inverting (-v) only apply to lucene queries, not query files", 3)
+		}
+		queryProvided = true
+	}
+	if len(c.StringSlice("pattern")) != 0 {
 		queryProvided = true
 	}
 
 	// Can't provide both a query via a file and via lucene search via
 	// args.
-	if len(c.Args()) > 0 && queryProvided {
+	if len(c.Args()) > 0 && c.IsSet("query-file") {
 		return cli.NewExitError("You've provided multiple queries (file and lucene perhaps?)", 3)
 	}
 	if len(c.Args()) == 0 && !queryProvided {
@@ -187,12 +393,28 @@ func RunPrepareApp(c *cli.Context) (err error) {
 	return err
 }
 
+// RunCloseApp closes the cassette being recorded, if any.
+func RunCloseApp(c *cli.Context) (err error) {
+	if recorder != nil {
+		return recorder.Close()
+	}
+	return nil
+}
+
+// newClient creates the client for the endpoint, whose requests are
+// recorded or replayed when asked.
+func newClient(endpoint string) (lgrep.LGrep, error) {
+	return lgrep.NewWithTransport(endpoint, transport)
+}
+
 // Config represents the configuration for the lgrep run based on the
 // flags provided.
 type Config struct {
 	// General client configuration
 	endpoint string
 	debug    bool
+	// file is searched instead of the endpoint when given.
+	file string
 
 	// Query configuration
 	queryFile      string
@@ -202,28 +424,61 @@ type Config struct {
 	queryFields    []string
 	queryRawResult bool
 	query          string
+	// queryPatterns are additional lucene queries given with -e.
+	queryPatterns    []string
+	queryPatternsAll bool
+	queryInvert      bool
+	queryMatches     []lgrep.FieldMatch
+	querySort        []lgrep.SortField
+	strict           bool
+
+	// Context configuration
+	contextBefore int
+	contextAfter  int
+	contextFields []string
+
+	// Distinct configuration
+	distinct      []string
+	distinctCount bool
+
+	// cluster is the field whose values are grouped into patterns.
+	cluster string
 
 	// Formatting configuration
 	formatTemplate string
 	formatRaw      bool
 	formatTabulate bool
+	formatReverse  bool
+
+	// Output file configuration
+	outputFile        string
+	outputRotateSize  int64
+	outputRotateCount int
+	outputCheckpoint  string
+	outputResume      bool
+	// searchAfter resumes the search after these sort values.
+	searchAfter []interface{}
 }
 
 // Run the user's configured search
 func (c Config) searchStream() (stream *lgrep.SearchStream, err error) {
-	l, err := lgrep.New(c.endpoint)
+	// Check the syntax before connecting to the server at all.
+	for _, q := range c.lucenePatterns() {
+		if _, err = lgrep.ParseLucene(q); err != nil {
+			return stream, err
+		}
+	}
+	l, err := c.client()
 	if err != nil {
 		log.Error(err)
 		return stream, err
 	}
 
-	spec := &lgrep.SearchOptions{
-		Index:      c.queryIndex,
-		Size:       c.querySize,
-		SortTime:   lgrep.SortDesc,
-		QueryDebug: c.queryDebug,
-		Fields:     c.queryFields,
-		RawResult:  c.queryRawResult,
+	spec := c.searchOptions()
+	if c.outputFile != "" {
+		// Exports are resumed after the sort values of the last result
+		// written, which must be unique for none to be skipped.
+		spec.TieBreaker = l.TieBreaker()
 	}
 	if c.debug {
 		fmt.Fprintf(os.Stderr, "q> SearchOptions: %#+v\n", spec)
@@ -245,15 +500,118 @@ func (c Config) searchStream() (stream *lgrep.SearchStream, err error) {
 		stream, err = l.SearchWithSourceStream(json.RawMessage(d), spec)
 	}
 
-	if c.query != "" {
+	if c.query != "" || len(c.queryPatterns) != 0 {
 		stream, err = l.SimpleSearchStream(c.query, spec)
 	}
 
 	return stream, err
 }
 
+// client creates the client that searches the file, if given, or the
+// endpoint.
+func (c Config) client() (l lgrep.LGrep, err error) {
+	if c.file == "" {
+		return newClient(c.endpoint)
+	}
+	backend, err := lgrep.NewFileBackend(c.file)
+	if err != nil {
+		return l, err
+	}
+	return lgrep.NewWithBackend(backend), nil
+}
+
+// saveMapping writes the mapping of the indices searched to the path.
+func (c Config) saveMapping(path string) error {
+	l, err := c.client()
+	if err != nil {
+		return err
+	}
+	mapping, err := l.Mapping(c.searchOptions())
+	if err != nil {
+		return errors.Annotate(err, "Could not retrieve the mapping")
+	}
+	data, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return err
+	}
+	return errors.Annotate(ioutil.WriteFile(path, data, 0644), "Could not save the mapping")
+}
+
+// searchOptions creates the search specification for the run.
+func (c Config) searchOptions() *lgrep.SearchOptions {
+	return &lgrep.SearchOptions{
+		Index:       c.queryIndex,
+		Size:        c.querySize,
+		SortTime:    lgrep.SortDesc,
+		QueryDebug:  c.queryDebug,
+		Fields:      c.queryFields,
+		Patterns:    c.queryPatterns,
+		PatternsAll: c.queryPatternsAll,
+		Invert:      c.queryInvert,
+		Matches:     c.queryMatches,
+		Sort:        c.querySort,
+		// The hits written to files are needed for their sort values,
+		// those shown with their context for their ids.
+		RawResult:   c.queryRawResult || c.outputFile != "" || c.withContext(),
+		SearchAfter: c.searchAfter,
+	}
+}
+
+// lucenePatterns returns all of the lucene queries given for the run.
+func (c Config) lucenePatterns() (patterns []string) {
+	if c.query != "" {
+		patterns = append(patterns, c.query)
+	}
+	return append(patterns, c.queryPatterns...)
+}
+
+// referencedFields returns the fields that the query and the format
+// template refer to.
+func (c Config) referencedFields() (fields []string) {
+	for _, p := range c.lucenePatterns() {
+		if q, err := lgrep.ParseLucene(p); err == nil {
+			fields = append(fields, lgrep.LuceneFields(q)...)
+		}
+	}
+	if !c.formatRaw {
+		for _, path := range lgrep.FieldPaths(c.formatTemplate) {
+			// The normalized timestamp, and its methods (ex:
+			// timestamp.Local), are provided by lgrep itself.
+			if strings.SplitN(path, ".", 2)[0] != "timestamp" {
+				fields = append(fields, path)
+			}
+		}
+	}
+	return fields
+}
+
+// checkFields looks up the referenced fields in the mapping of the
+// searched indices and suggests replacements for unknown fields.
+func (c Config) checkFields() (unknown []lgrep.FieldSuggestion, err error) {
+	fields := c.referencedFields()
+	if len(fields) == 0 {
+		return unknown, nil
+	}
+	l, err := c.client()
+	if err != nil {
+		return unknown, err
+	}
+	return l.CheckFields(fields, c.searchOptions())
+}
+
 // formatter returns a function that writes a formatted result to `out`.
 func (c Config) formatter(out io.Writer) (f func(lgrep.Result) error, flush func(), err error) {
+	marked, flush, err := c.markedFormatter(out)
+	f = func(r lgrep.Result) error {
+		return marked("", r)
+	}
+	return f, flush, err
+}
+
+// markedFormatter returns a function that writes a formatted result
+// to `out` prefixed with a marker, a nil result writes the marker
+// alone as a separator.
+func (c Config) markedFormatter(out io.Writer) (f func(marker string, r lgrep.Result) error, flush func(), err error) {
 	if c.formatRaw {
 		c.formatTemplate = lgrep.FormatRaw
 	}
@@ -278,12 +636,16 @@ func (c Config) formatter(out io.Writer) (f func(lgrep.Result) error, flush func
 		return f, flush, err
 	}
 
-	f = func(r lgrep.Result) error {
+	f = func(marker string, r lgrep.Result) error {
+		if r == nil {
+			fmt.Fprintln(out, marker)
+			return nil
+		}
 		msg, err := lformat(r)
 		if err != nil {
 			return err
 		}
-		fmt.Fprintf(out, "%s\n", msg)
+		fmt.Fprintf(out, "%s%s\n", marker, msg)
 		return nil
 	}
 
@@ -295,6 +657,7 @@ func RunQuery(c *cli.Context) (err error) {
 	run := Config{
 		endpoint: c.String("endpoint"),
 		debug:    c.Bool("debug"),
+		file:     c.String("file"),
 
 		queryFile:      c.String("query-file"),
 		querySize:      c.Int("query-size"),
@@ -304,9 +667,89 @@ func RunQuery(c *cli.Context) (err error) {
 		queryRawResult: c.Bool("raw-doc-json"),
 		query:          strings.Join(c.Args(), " "),
 
-		formatTemplate: c.String("format"),
-		formatRaw:      c.Bool("raw-json") || c.Bool("raw-doc-json"),
-		formatTabulate: c.Bool("tabulate"),
+		formatTemplate:   c.String("format"),
+		formatRaw:        c.Bool("raw-json") || c.Bool("raw-doc-json"),
+		formatTabulate:   c.Bool("tabulate"),
+		formatReverse:    c.Bool("reverse"),
+		queryPatterns:    c.StringSlice("pattern"),
+		queryPatternsAll: c.Bool("all"),
+		queryInvert:      c.Bool("invert-match"),
+		strict:           c.Bool("strict"),
+
+		contextBefore: c.Int("before-context"),
+		contextAfter:  c.Int("after-context"),
+		contextFields: strings.Split(c.String("context-fields"), ","),
+
+		distinctCount: c.Bool("count"),
+
+		outputFile:        c.String("output-file"),
+		outputRotateCount: c.Int("rotate-count"),
+		outputCheckpoint:  c.String("checkpoint"),
+		outputResume:      c.Bool("resume"),
+	}
+	if n := c.Int("context"); n != 0 {
+		if !c.IsSet("before-context") {
+			run.contextBefore = n
+		}
+		if !c.IsSet("after-context") {
+			run.contextAfter = n
+		}
+	}
+
+	if args := c.Args(); isSavedRef(args.First()) {
+		cfg, err := loadConfigFile(c.String("config"))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		saved, err := cfg.SavedSearch(args.First())
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		err = saved.apply(c, &run, args.Tail())
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	if sort := c.String("sort"); sort != "" {
+		run.querySort, err = lgrep.ParseSortFields(sort)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 3)
+		}
+	}
+
+	for _, m := range c.StringSlice("match") {
+		match, err := lgrep.ParseFieldMatch(m)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 3)
+		}
+		run.queryMatches = append(run.queryMatches, match)
+	}
+
+	if tmpl := c.String("distinct"); tmpl != "" {
+		run.distinct = lgrep.FieldPaths(tmpl)
+		if len(run.distinct) == 0 {
+			return cli.NewExitError("The distinct template doesn't use any fields", 3)
+		}
+		if run.withContext() {
+			return cli.NewExitError("Context (-A/-B/-C) can't be shown for distinct values", 3)
+		}
+		run.formatTemplate = tmpl
+	}
+
+	if c.Bool("cluster") {
+		if len(run.distinct) != 0 || run.withContext() {
+			return cli.NewExitError("Distinct values or context (-A/-B/-C) can't be shown for clusters", 3)
+		}
+		run.cluster = strings.TrimPrefix(c.String("cluster-field"), ".")
+		if run.cluster == "" {
+			return cli.NewExitError("A field to cluster must be provided", 3)
+		}
+		// Only the clustered field is needed from the results.
+		run.formatTemplate = "." + run.cluster
 	}
 
 	if !run.formatRaw {
@@ -320,9 +763,83 @@ func RunQuery(c *cli.Context) (err error) {
 	// Always fetch fields *and* timestamp fields!
 	if len(run.queryFields) != 0 {
 		run.queryFields = append(run.queryFields, "@timestamp", "date")
+		for _, m := range run.queryMatches {
+			run.queryFields = append(run.queryFields, m.Field)
+		}
+		if run.withContext() {
+			run.queryFields = append(run.queryFields, run.contextFields...)
+		}
+	}
+
+	if run.strict {
+		unknown, err := run.checkFields()
+		if err != nil {
+			log.Error(errors.Annotate(err, "Could not check the fields used"))
+			return err
+		}
+		for _, u := range unknown {
+			log.Error(u)
+		}
+		if len(unknown) != 0 {
+			return cli.NewExitError("The query or format uses unknown fields", 3)
+		}
+	}
+
+	if run.outputFile != "" {
+		if len(run.distinct) != 0 || run.cluster != "" || run.withContext() || run.formatReverse || run.formatTabulate {
+			return cli.NewExitError("Distinct values, clusters, context, reversed or tabulated results can't be written to output files", 3)
+		}
+		if _, err = outputCompression(run.outputFile); err != nil {
+			return cli.NewExitError(err.Error(), 3)
+		}
+		if size := c.String("rotate-size"); size != "" {
+			if run.outputRotateSize, err = parseByteSize(size); err != nil {
+				return cli.NewExitError(err.Error(), 3)
+			}
+		}
+	} else if c.Bool("resume") {
+		return cli.NewExitError("Only exports to output files (-o) can be resumed", 3)
+	}
+
+	if path := c.String("save-mapping"); path != "" {
+		if err = run.saveMapping(path); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	if len(run.distinct) != 0 {
+		return run.printDistinct(os.Stdout)
+	}
+	if run.cluster != "" {
+		return run.printClusters(os.Stdout)
 	}
 
-	formatter, flush, err := run.formatter(os.Stdout)
+	var (
+		formatter func(lgrep.Result) error
+		flush     func()
+		output    *outputFiles
+	)
+	if run.outputFile != "" {
+		output, err = run.openOutput()
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		defer func() {
+			if cerr := output.Close(); cerr != nil {
+				log.Error(cerr)
+				if err == nil {
+					err = cerr
+				}
+			}
+		}()
+		formatter, flush, err = run.formatter(output)
+	} else if run.withContext() {
+		formatter, flush, err = run.contextFormatter(os.Stdout)
+	} else {
+		formatter, flush, err = run.formatter(os.Stdout)
+	}
 	if err != nil {
 		log.Error(err)
 		return err
@@ -330,33 +847,77 @@ func RunQuery(c *cli.Context) (err error) {
 	defer flush()
 	stream, err := run.searchStream()
 	if err != nil {
-		log.Error(err)
-		return err
+		return queryError(err)
 	}
 	count := 0
-	resultFn := func(r lgrep.Result) error {
-		count++
-		err = formatter(r)
+	printFn := func(r lgrep.Result) error {
+		err := formatter(r)
 		if err != nil {
 			log.Warn(errors.Annotate(err, "error formatting result"))
 		}
 		return nil
 	}
+	// Reversed results are collected and printed after the stream
+	// has been read entirely.
+	reversed := &reverseBuffer{}
+	defer reversed.Close()
+	resultFn := func(r lgrep.Result) error {
+		count++
+		if output != nil {
+			result, sort := run.hitResult(r)
+			printFn(result)
+			return output.Written(sort)
+		}
+		if run.formatReverse {
+			return reversed.Add(r)
+		}
+		return printFn(r)
+	}
 	errFn := func(e error) error { return e }
 	err = stream.Each(resultFn, errFn)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
+	if run.formatReverse {
+		if err = reversed.Each(printFn); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	if len(run.queryMatches) != 0 {
+		log.Infof("Scanned %d documents, %d matched", stream.Scanned(), stream.Emitted())
+	}
 
 	if count == 0 {
 		log.Warn("0 results returned")
+		// A misspelled field is a common cause of finding nothing.
+		if !run.strict {
+			unknown, err := run.checkFields()
+			if err != nil {
+				log.Debug(errors.Annotate(err, "Could not check the fields used"))
+			}
+			for _, u := range unknown {
+				log.Warn(u)
+			}
+		}
 		return nil
 	}
 
 	return err
 }
 
+// queryError reports an error with the query to the user, syntax
+// errors are shown with their position in the query.
+func queryError(err error) error {
+	if serr, ok := err.(*lgrep.LuceneSyntaxError); ok {
+		fmt.Fprintln(os.Stderr, serr.Explain())
+		return cli.NewExitError("", 3)
+	}
+	log.Error(err)
+	return err
+}
+
 // tabifyFormat crafts a tabular format from a format string.
 func tabifyFormat(format string, stripTokens bool) (str string) {
 	// Format first for consistency in replacements
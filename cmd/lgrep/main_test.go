@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+// newTestServer starts a fake server with the fixtures.
+func newTestServer(t *testing.T) *lgreptest.Server {
+	server := lgreptest.NewServer()
+	for _, fixture := range []string{"../../test/fixtures/journald.ndjson", "../../test/fixtures/inbound.ndjson"} {
+		if err := server.Load(fixture); err != nil {
+			server.Close()
+			t.Fatal(err)
+		}
+	}
+	return server
+}
+
+func TestSearchStream(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	run := Config{
+		endpoint:       server.URL,
+		query:          "service:kernel",
+		queryIndex:     "journald-*",
+		querySize:      10,
+		formatTemplate: ".host .message",
+	}
+	run.queryFields = lgrep.FieldTokens(run.formatTemplate)
+	stream, err := run.searchStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	formatter, flush, err := run.formatter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.Each(formatter, func(e error) error { return e }); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	// Newest first.
+	expected := "db1 eth0: link up\nweb2 eth0: link up\nweb1 eth0: link up\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestCheckFieldsServer(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	run := Config{endpoint: server.URL, query: "route.fromdomian:example.com", queryIndex: "inbound-*", formatTemplate: ".message"}
+	unknown, err := run.checkFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unknown) != 1 || unknown[0].Field != "route.fromdomian" || unknown[0].Suggestions[0] != "route.fromdomain" {
+		t.Errorf("Unknown fields %v", unknown)
+	}
+}
+
+func TestReferencedFields(t *testing.T) {
+	run := Config{
+		query:          "service:kernel AND route.fromdomain:example.com",
+		formatTemplate: `{{.timestamp|ftime "15:04"}} {{.host | printf "%-8s"}} {{.route.todomain}}`,
+	}
+	fields := run.referencedFields()
+	expected := []string{"service", "route.fromdomain", "host", "route.todomain"}
+	if strings.Join(fields, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected the fields %v, got %v", expected, fields)
+	}
+
+	server := newTestServer(t)
+	defer server.Close()
+	run = Config{endpoint: server.URL, queryIndex: "journald-*", formatTemplate: `{{.timestamp|ftime "15:04"}} {{.host}} {{.message}}`}
+	if unknown, err := run.checkFields(); err != nil || len(unknown) != 0 {
+		t.Errorf("Expected the piped format to be valid, unknown fields %v (%v)", unknown, err)
+	}
+	// The stdline format passes --strict.
+	if err := App().Run([]string{"lgrep", "-E", server.URL, "-Qi", "journald-*", "--strict", "-tt", "-n", "1", "host:web1"}); err != nil {
+		t.Errorf("Expected the stdline format to pass --strict: %s", err)
+	}
+}
+
+func TestEndpointPattern(t *testing.T) {
+	endpoint, patterns, _ := endpointPatterns([]string{"host:web1", "http://other:9200/", "service:sshd"})
+	if endpoint != "http://other:9200/" || strings.Join(patterns, " ") != "host:web1 service:sshd" {
+		t.Errorf("Separated %q and %v", endpoint, patterns)
+	}
+	if endpoint, _, _ = endpointPatterns([]string{"url:http\\://other"}); endpoint != "" {
+		t.Errorf("Took the pattern as the endpoint %q", endpoint)
+	}
+	for _, v := range []string{"localhost:9200", "127.0.0.1:9200/", "[::1]:9200"} {
+		if endpoint, _, _ = endpointPatterns([]string{v}); endpoint != "http://"+v {
+			t.Errorf("Took %s as the endpoint %q", v, endpoint)
+		}
+	}
+	endpoint, patterns, ambiguous := endpointPatterns([]string{"status:500", "es.example.com:9200", "http.status:404"})
+	if endpoint != "" || len(patterns) != 3 {
+		t.Errorf("Separated %q and %v", endpoint, patterns)
+	}
+	if strings.Join(ambiguous, " ") != "es.example.com:9200 http.status:404" {
+		t.Errorf("Found the ambiguous patterns %v", ambiguous)
+	}
+
+	// Scripts written for -e as the endpoint keep working.
+	var run Config
+	app := App()
+	app.Action = func(c *cli.Context) error {
+		run = Config{endpoint: c.String("endpoint"), queryPatterns: c.StringSlice("pattern")}
+		return nil
+	}
+	if err := app.Run([]string{"lgrep", "-e", "http://other:9200", "-e", "host:web1", "service:sshd"}); err != nil {
+		t.Fatal(err)
+	}
+	if run.endpoint != "http://other:9200" || strings.Join(run.queryPatterns, " ") != "host:web1" {
+		t.Errorf("Ran against %s with the patterns %v", run.endpoint, run.queryPatterns)
+	}
+	if err := app.Run([]string{"lgrep", "-e", "localhost:9201", "service:sshd"}); err != nil {
+		t.Fatal(err)
+	}
+	if run.endpoint != "http://localhost:9201" || len(run.queryPatterns) != 0 {
+		t.Errorf("Ran against %s with the patterns %v", run.endpoint, run.queryPatterns)
+	}
+}
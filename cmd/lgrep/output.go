@@ -0,0 +1,336 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// checkpointInterval is how many results are written between
+// checkpoints.
+const checkpointInterval = 1000
+
+// outputCheckpoint is where an export to files stopped, saved so that
+// it may be resumed.
+type outputCheckpoint struct {
+	// Part is the number of the file being written.
+	Part int `json:"part"`
+	// Offset is the size of the file at the checkpoint, what's
+	// written after it is truncated when resuming.
+	Offset int64 `json:"offset"`
+	// PartWritten is the number of results in the file.
+	PartWritten int `json:"part_written"`
+	// Written is the number of results written to all of the files.
+	Written int `json:"written"`
+	// SearchAfter are the sort values of the last result written.
+	SearchAfter []interface{} `json:"search_after"`
+}
+
+// loadCheckpoint reads the checkpoint at the path.
+func loadCheckpoint(path string) (cp outputCheckpoint, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cp, errors.Annotate(err, "Could not read the checkpoint to resume from")
+	}
+	d := json.NewDecoder(strings.NewReader(string(data)))
+	// Sort values are kept as they were given, large longs included.
+	d.UseNumber()
+	if err = d.Decode(&cp); err != nil {
+		return cp, errors.Annotatef(err, "Could not parse the checkpoint %s", path)
+	}
+	return cp, nil
+}
+
+// save writes the checkpoint to the path, replacing the previous one
+// only once it's written entirely.
+func (cp outputCheckpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return errors.Annotate(err, "Could not write the checkpoint")
+	}
+	return errors.Annotate(os.Rename(path+".tmp", path), "Could not write the checkpoint")
+}
+
+// countingFile counts the bytes written to the file, which zstd
+// writes to from other goroutines. The file isn't embedded, for copies
+// to it not to bypass Write.
+type countingFile struct {
+	file *os.File
+	size int64
+}
+
+func (f *countingFile) Write(p []byte) (n int, err error) {
+	n, err = f.file.Write(p)
+	atomic.AddInt64(&f.size, int64(n))
+	return n, err
+}
+
+// Size returns the size of the file.
+func (f *countingFile) Size() int64 {
+	return atomic.LoadInt64(&f.size)
+}
+
+// Close closes the file.
+func (f *countingFile) Close() error {
+	return f.file.Close()
+}
+
+// nopWriteCloser writes uncompressed output.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// outputCompression returns the compression of the files named by the
+// pattern: gzip (.gz), zstd (.zst) or none.
+func outputCompression(pattern string) (compression string, err error) {
+	switch {
+	case strings.HasSuffix(pattern, ".gz"):
+		return "gzip", nil
+	case strings.HasSuffix(pattern, ".zst"):
+		return "zstd", nil
+	}
+	for _, ext := range []string{".bz2", ".xz", ".lz4"} {
+		if strings.HasSuffix(pattern, ext) {
+			return "", errors.Errorf("The output file '%s' can only be compressed with gzip (.gz) or zstd (.zst)", pattern)
+		}
+	}
+	return "", nil
+}
+
+// outputFiles writes the results to files named by a pattern
+// (ex: export-%03d.ndjson.gz), rotated once they're large or hold
+// enough results. A checkpoint is saved as the results are written,
+// and each time a file is rotated, for the export to be resumed.
+//
+// Compressed files are written as a series of streams (gzip members,
+// zstd frames) ended at each checkpoint, so that what's written up to
+// a checkpoint is a complete file that can be appended to.
+type outputFiles struct {
+	pattern     string
+	compression string
+	rotateSize  int64
+	rotateCount int
+	// checkpointPath is where the checkpoint is saved.
+	checkpointPath string
+
+	cp          outputCheckpoint
+	file        *countingFile
+	w           io.WriteCloser
+	uncommitted int
+	// zstd is reused for each of the zstd frames.
+	zstd *zstd.Encoder
+}
+
+// newOutputFiles prepares the files, resuming from the checkpoint when
+// asked.
+func newOutputFiles(pattern string, rotateSize int64, rotateCount int, checkpointPath string, resume bool) (o *outputFiles, err error) {
+	rotated := rotateSize > 0 || rotateCount > 0
+	if hasVerb := strings.Contains(pattern, "%"); rotated && !hasVerb {
+		return nil, errors.Errorf("The output file '%s' must be numbered (ex: export-%%03d.ndjson.gz) to be rotated", pattern)
+	} else if hasVerb && strings.Contains(fmt.Sprintf(pattern, 1), "%!") {
+		return nil, errors.Errorf("The output file '%s' must be numbered with a single integer (ex: %%03d)", pattern)
+	}
+	compression, err := outputCompression(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if checkpointPath == "" {
+		checkpointPath = pattern + ".checkpoint"
+	}
+	o = &outputFiles{
+		pattern:        pattern,
+		compression:    compression,
+		rotateSize:     rotateSize,
+		rotateCount:    rotateCount,
+		checkpointPath: checkpointPath,
+		cp:             outputCheckpoint{Part: 1},
+	}
+	if resume {
+		if o.cp, err = loadCheckpoint(checkpointPath); err != nil {
+			return nil, err
+		}
+		log.Infof("Resuming after %d results, in %s", o.cp.Written, o.name())
+	}
+	return o, o.open()
+}
+
+// openOutput opens the output files of the run, which is resumed
+// after the results that were written when asked.
+func (c *Config) openOutput() (o *outputFiles, err error) {
+	o, err = newOutputFiles(c.outputFile, c.outputRotateSize, c.outputRotateCount, c.outputCheckpoint, c.outputResume)
+	if err != nil || !c.outputResume || o.cp.Written == 0 {
+		return o, err
+	}
+	if len(o.cp.SearchAfter) == 0 {
+		o.Close()
+		return nil, errors.New("The checkpoint doesn't have the sort values to resume after")
+	}
+	if c.querySize <= o.cp.Written {
+		o.Close()
+		return nil, errors.Errorf("The export already wrote the %d results asked for", o.cp.Written)
+	}
+	c.querySize -= o.cp.Written
+	c.searchAfter = o.cp.SearchAfter
+	return o, nil
+}
+
+// name returns the name of the file being written.
+func (o *outputFiles) name() string {
+	if !strings.Contains(o.pattern, "%") {
+		return o.pattern
+	}
+	return fmt.Sprintf(o.pattern, o.cp.Part)
+}
+
+// open opens the file being written, truncated to the checkpoint.
+func (o *outputFiles) open() error {
+	f, err := os.OpenFile(o.name(), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Annotate(err, "Could not open the output file")
+	}
+	if err = f.Truncate(o.cp.Offset); err == nil {
+		_, err = f.Seek(o.cp.Offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return errors.Annotatef(err, "Could not resume writing %s", o.name())
+	}
+	o.file = &countingFile{file: f, size: o.cp.Offset}
+	return nil
+}
+
+// Write writes to the file, starting a compressed stream if needed.
+func (o *outputFiles) Write(p []byte) (n int, err error) {
+	if o.w == nil {
+		switch o.compression {
+		case "gzip":
+			o.w = gzip.NewWriter(o.file)
+		case "zstd":
+			if o.zstd == nil {
+				if o.zstd, err = zstd.NewWriter(o.file); err != nil {
+					return 0, errors.Annotate(err, "Could not compress the output")
+				}
+			} else {
+				o.zstd.Reset(o.file)
+			}
+			o.w = o.zstd
+		default:
+			o.w = nopWriteCloser{o.file}
+		}
+	}
+	return o.w.Write(p)
+}
+
+// Written records that a result, with the sort values, was written,
+// rotating the file or saving a checkpoint when it's time to.
+func (o *outputFiles) Written(sort []interface{}) error {
+	o.cp.Written++
+	o.cp.PartWritten++
+	o.cp.SearchAfter = sort
+	o.uncommitted++
+
+	if (o.rotateCount > 0 && o.cp.PartWritten >= o.rotateCount) ||
+		(o.rotateSize > 0 && o.file.Size() >= o.rotateSize) {
+		return o.rotate()
+	}
+	if o.uncommitted >= checkpointInterval {
+		return o.commit()
+	}
+	return nil
+}
+
+// commit ends the compressed stream and saves the checkpoint.
+func (o *outputFiles) commit() error {
+	if err := o.endStream(); err != nil {
+		return err
+	}
+	o.cp.Offset = o.file.Size()
+	return o.cp.save(o.checkpointPath)
+}
+
+// endStream ends the compressed stream being written.
+func (o *outputFiles) endStream() error {
+	o.uncommitted = 0
+	if o.w == nil {
+		return nil
+	}
+	err := o.w.Close()
+	o.w = nil
+	return errors.Annotatef(err, "Could not write %s", o.name())
+}
+
+// rotate finishes the file and starts the next one, the checkpoint is
+// saved once it's started.
+func (o *outputFiles) rotate() error {
+	if err := o.endStream(); err != nil {
+		return err
+	}
+	if err := o.file.Close(); err != nil {
+		return errors.Annotatef(err, "Could not write %s", o.name())
+	}
+	log.Debugf("Wrote %d results to %s", o.cp.PartWritten, o.name())
+	o.cp.Part++
+	o.cp.PartWritten = 0
+	o.cp.Offset = 0
+	if err := o.open(); err != nil {
+		return err
+	}
+	return o.cp.save(o.checkpointPath)
+}
+
+// Close finishes the file being written, saving the checkpoint. The
+// file is removed when the export ended just as it was rotated.
+func (o *outputFiles) Close() error {
+	if err := o.commit(); err != nil {
+		return err
+	}
+	if err := o.file.Close(); err != nil {
+		return errors.Annotatef(err, "Could not write %s", o.name())
+	}
+	if o.file.Size() == 0 && o.cp.Part > 1 {
+		return os.Remove(o.name())
+	}
+	return nil
+}
+
+// hitResult unwraps the hit requested to know its sort values into the
+// result that was asked for.
+func (c Config) hitResult(r lgrep.Result) (result lgrep.Result, sort []interface{}) {
+	hit, ok := r.(lgrep.HitResult)
+	if !ok || c.queryRawResult {
+		return r, hit.Sort
+	}
+	if (len(c.queryFields) != 0 && len(hit.Fields) != 0) || hit.Source == nil {
+		return lgrep.FieldResult(hit.Fields), hit.Sort
+	}
+	return lgrep.SourceResult(*hit.Source), hit.Sort
+}
+
+// parseByteSize parses a size given in bytes or with a K, M or G
+// suffix (ex: 100M).
+func parseByteSize(text string) (size int64, err error) {
+	units := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(text)), "B"), "I")
+	unit := strings.TrimLeft(s, "0123456789")
+	size, err = strconv.ParseInt(strings.TrimSuffix(s, unit), 10, 64)
+	if _, ok := units[unit]; err != nil || !ok || size <= 0 {
+		return 0, errors.Errorf("Size '%s' should be given in bytes, or with K, M or G (ex: 100M)", text)
+	}
+	return size * units[unit], nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+// readLines reads the lines of the compressed file.
+func readLines(t *testing.T, path string) (lines []string) {
+	r, err := lgrep.OpenDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if err = s.Err(); err != nil {
+		t.Fatalf("Could not read %s: %s", path, err)
+	}
+	return lines
+}
+
+func TestOutputFilesResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pattern := filepath.Join(dir, "export-%03d.ndjson.gz")
+
+	o, err := newOutputFiles(pattern, 0, 2, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, line := range []string{"a", "b", "c"} {
+		io.WriteString(o, line+"\n")
+		if err = o.Written([]interface{}{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Interrupted without closing, the third result wasn't checkpointed.
+	o.w.(*gzip.Writer).Flush()
+	o.file.Close()
+
+	o, err = newOutputFiles(pattern, 0, 2, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.cp.Written != 2 || o.cp.Part != 2 || len(o.cp.SearchAfter) != 1 {
+		t.Errorf("Resumed from %+v", o.cp)
+	}
+	for i, line := range []string{"c", "d", "e"} {
+		io.WriteString(o, line+"\n")
+		if err = o.Written([]interface{}{i + 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"export-001.ndjson.gz": {"a", "b"},
+		"export-002.ndjson.gz": {"c", "d"},
+		"export-003.ndjson.gz": {"e"},
+	}
+	for name, lines := range expected {
+		if found := readLines(t, filepath.Join(dir, name)); len(found) != len(lines) || found[0] != lines[0] {
+			t.Errorf("%s holds %v, expected %v", name, found, lines)
+		}
+	}
+}
+
+func TestOutputFilesZstd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "export.ndjson.zst")
+
+	o, err := newOutputFiles(path, 0, 0, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each checkpoint ends a frame, the encoder is reused for the next.
+	for i, line := range []string{"a", "b", "c"} {
+		io.WriteString(o, line+"\n")
+		if err = o.Written([]interface{}{i}); err != nil {
+			t.Fatal(err)
+		}
+		if err = o.commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if o, err = newOutputFiles(path, 0, 0, "", true); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(o, "d\n")
+	if err = o.Written([]interface{}{3}); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := readLines(t, path); strings.Join(lines, " ") != "a b c d" {
+		t.Errorf("%s holds %v, expected a b c d", path, lines)
+	}
+}
+
+func TestOutputFileExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pattern := filepath.Join(dir, "export-%d.ndjson.gz")
+
+	export := func(size string, resume bool) {
+		args := []string{"lgrep", "--file", "../../test/fixtures/journald.ndjson", "-j", "-o", pattern, "--rotate-count", "5", "-n", size}
+		if resume {
+			args = append(args, "--resume")
+		}
+		if err := App().Run(append(args, "*")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	export("8", false)
+	export("12", true)
+
+	seen := make(map[string]bool)
+	for i, expected := range []int{5, 5, 2} {
+		lines := readLines(t, filepath.Join(dir, fmt.Sprintf("export-%d.ndjson.gz", i+1)))
+		if len(lines) != expected {
+			t.Errorf("File %d holds %d results, expected %d", i+1, len(lines), expected)
+		}
+		for _, line := range lines {
+			if seen[line] {
+				t.Errorf("%s was exported twice", line)
+			}
+			seen[line] = true
+		}
+	}
+	if len(seen) != 12 {
+		t.Errorf("Exported %d results, expected 12", len(seen))
+	}
+}
+
+func TestOutputFileResumeTies(t *testing.T) {
+	for _, version := range []string{"5.6.16", "7.17.9"} {
+		server := lgreptest.NewServer()
+		defer server.Close()
+		server.Version = version
+		// Every timestamp is shared by two documents.
+		for i := 0; i < 20; i++ {
+			source := map[string]interface{}{"@timestamp": fmt.Sprintf("2016-04-29T13:%02d:00Z", i/2), "message": fmt.Sprintf("line %d", i)}
+			if err := server.Index("logs-1", "log", fmt.Sprintf("l%02d", i), source); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dir, err := ioutil.TempDir("", "lgrep")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "export.ndjson.gz")
+		// The first export stops between two documents with the same
+		// timestamp.
+		args := []string{"lgrep", "-E", server.URL, "-Qi", "logs-*", "-j", "-o", path}
+		if err = App().Run(append(args, "-n", "7", "*")); err != nil {
+			t.Fatal(err)
+		}
+		if err = App().Run(append(args, "-n", "20", "--resume", "*")); err != nil {
+			t.Fatal(err)
+		}
+
+		seen := make(map[string]bool)
+		for _, line := range readLines(t, path) {
+			seen[line] = true
+		}
+		if len(seen) != 20 {
+			t.Errorf("Exported %d distinct results from Elasticsearch %s, expected 20", len(seen), version)
+		}
+	}
+}
+
+func TestOutputCompression(t *testing.T) {
+	examples := map[string]string{"export.ndjson.gz": "gzip", "export-%03d.ndjson.zst": "zstd", "export-%03d.ndjson": ""}
+	for pattern, expected := range examples {
+		if compression, err := outputCompression(pattern); err != nil || compression != expected {
+			t.Errorf("Compression of %s: %q (%v), expected %q", pattern, compression, err, expected)
+		}
+	}
+	if _, err := outputCompression("export.ndjson.xz"); err == nil {
+		t.Error("Expected xz to be rejected")
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	examples := map[string]int64{"1024": 1024, "2K": 2048, "100M": 100 << 20, "1GiB": 1 << 30, "5mb": 5 << 20}
+	for text, expected := range examples {
+		if size, err := parseByteSize(text); err != nil || size != expected {
+			t.Errorf("Parsed '%s' as %d (%v), expected %d", text, size, err, expected)
+		}
+	}
+	for _, invalid := range []string{"", "M", "-1", "10T", "1.5G"} {
+		if _, err := parseByteSize(invalid); err == nil {
+			t.Errorf("Expected '%s' not to parse", invalid)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+const (
+	// reverseMemoryLimit is the number of results that are held in
+	// memory for reversal, beyond this they're spilled to disk.
+	reverseMemoryLimit = lgrep.MaxSearchSize
+
+	// Record kinds for the results spilled to disk, the kind is
+	// needed to restore the result's type for formatting.
+	spillSource = 's'
+	spillField  = 'f'
+	spillHit    = 'h'
+)
+
+// reverseBuffer collects results so that they may be read back in
+// reverse, as when printing the newest results oldest first. Results
+// are spilled to a temporary file once there are too many to hold in
+// memory so that scroll sized searches may be reversed.
+type reverseBuffer struct {
+	results []lgrep.Result
+
+	file    *os.File
+	writer  *bufio.Writer
+	offsets []int64
+	size    int64
+}
+
+// Add appends the result to the buffer.
+func (b *reverseBuffer) Add(r lgrep.Result) (err error) {
+	if b.file == nil && len(b.results) < reverseMemoryLimit {
+		b.results = append(b.results, r)
+		return nil
+	}
+	if b.file == nil {
+		log.Debugf("Spilling more than %d results to disk for reversal", reverseMemoryLimit)
+		b.file, err = ioutil.TempFile("", "lgrep-reverse")
+		if err != nil {
+			return errors.Annotate(err, "Could not create a file to reverse the results in")
+		}
+		b.writer = bufio.NewWriter(b.file)
+		results := b.results
+		b.results = nil
+		for _, held := range results {
+			if err = b.spill(held); err != nil {
+				return err
+			}
+		}
+	}
+	return b.spill(r)
+}
+
+// spill writes the result to the file, recording where it was
+// written.
+func (b *reverseBuffer) spill(r lgrep.Result) error {
+	kind := byte(spillSource)
+	switch r.(type) {
+	case lgrep.FieldResult:
+		kind = spillField
+	case lgrep.HitResult:
+		kind = spillHit
+	}
+	data, err := r.JSON()
+	if err != nil {
+		return err
+	}
+	b.offsets = append(b.offsets, b.size)
+	b.writer.WriteByte(kind)
+	n, err := b.writer.Write(data)
+	if err != nil {
+		return errors.Annotate(err, "Could not write the results to reverse")
+	}
+	b.size += int64(n) + 1
+	return nil
+}
+
+// Each calls fn with each of the results, last added first.
+func (b *reverseBuffer) Each(fn func(lgrep.Result) error) (err error) {
+	if b.file == nil {
+		for i := len(b.results) - 1; i >= 0; i-- {
+			if err = fn(b.results[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err = b.writer.Flush(); err != nil {
+		return errors.Annotate(err, "Could not write the results to reverse")
+	}
+	end := b.size
+	for i := len(b.offsets) - 1; i >= 0; i-- {
+		record := make([]byte, end-b.offsets[i])
+		_, err = b.file.ReadAt(record, b.offsets[i])
+		if err != nil && err != io.EOF {
+			return errors.Annotate(err, "Could not read back the results to reverse")
+		}
+		end = b.offsets[i]
+
+		r, err := unspill(record)
+		if err != nil {
+			return err
+		}
+		if err = fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unspill restores a result that was written to the file.
+func unspill(record []byte) (r lgrep.Result, err error) {
+	kind, data := record[0], record[1:]
+	switch kind {
+	case spillField:
+		var fr lgrep.FieldResult
+		err = json.Unmarshal(data, &fr)
+		return fr, err
+	case spillHit:
+		var hr lgrep.HitResult
+		err = json.Unmarshal(data, &hr)
+		return hr, err
+	default:
+		return lgrep.SourceResult(data), nil
+	}
+}
+
+// Close removes any file that the results were spilled to.
+func (b *reverseBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+)
+
+func TestReverseBuffer(t *testing.T) {
+	for _, size := range []int{0, 3, reverseMemoryLimit + 3} {
+		b := &reverseBuffer{}
+		for i := 0; i < size; i++ {
+			var r lgrep.Result = lgrep.SourceResult(fmt.Sprintf(`{"n":%d}`, i))
+			if i%2 == 0 {
+				r = lgrep.FieldResult{"n": i}
+			}
+			if err := b.Add(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if spilled := b.file != nil; spilled != (size > reverseMemoryLimit) {
+			t.Errorf("Buffer of %d results spilled to disk: %t", size, spilled)
+		}
+
+		next := size - 1
+		err := b.Each(func(r lgrep.Result) error {
+			if expected := fmt.Sprintf(`{"n":%d}`, next); r.String() != expected {
+				return fmt.Errorf("result %s read in place of %s", r, expected)
+			}
+			if _, isField := r.(lgrep.FieldResult); isField != (next%2 == 0) {
+				return fmt.Errorf("result %s was read back as a %T", r, r)
+			}
+			next--
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Reading back %d results: %s", size, err)
+		}
+		if next != -1 {
+			t.Errorf("Only %d of %d results were read back", size-next-1, size)
+		}
+		if err = b.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
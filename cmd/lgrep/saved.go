@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/codegangsta/cli"
+	"github.com/juju/errors"
+)
+
+const (
+	// savedPrefix marks a query argument as a reference to a saved
+	// search (ex: lgrep @edge-5xx).
+	savedPrefix = "@"
+	// DefaultConfigFile is the configuration file used when one isn't
+	// otherwise provided.
+	DefaultConfigFile = "~/.lgrep.json"
+)
+
+var (
+	// SavedCommand manages the saved searches in the configuration
+	// file.
+	SavedCommand = cli.Command{
+		Name:  "saved",
+		Usage: "Manage saved searches (run them with lgrep @name)",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List the saved searches",
+				Action: RunSavedList,
+			},
+			{
+				Name:      "show",
+				Usage:     "Show a saved search",
+				ArgsUsage: "NAME",
+				Action:    RunSavedShow,
+			},
+			{
+				Name:      "add",
+				Usage:     "Add or replace a saved search",
+				ArgsUsage: "NAME [QUERY]",
+				Action:    RunSavedAdd,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "format, format-template, t",
+						Usage: "Format template to use with the search",
+					},
+					cli.StringFlag{
+						Name:  "query-index, Qi",
+						Usage: "Index to search",
+					},
+					cli.IntFlag{
+						Name:  "query-size, n, Qn",
+						Usage: "Number of results to be returned",
+					},
+					cli.StringFlag{
+						Name:  "query-file, Qf",
+						Usage: "Raw elasticsearch json query to submit",
+					},
+				},
+			},
+			{
+				Name:      "rm",
+				Usage:     "Remove a saved search",
+				ArgsUsage: "NAME",
+				Action:    RunSavedRemove,
+			},
+		},
+	}
+
+	// savedNamePattern matches the names of saved searches, which
+	// can't be mistaken for a query on a field (ex: @timestamp:[...]).
+	savedNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// configFile is the user's lgrep configuration that's persisted
+// between runs.
+type configFile struct {
+	// Saved are the named searches that may be run with @name.
+	Saved map[string]savedSearch `json:"saved,omitempty"`
+
+	// path is where the configuration was loaded from.
+	path string
+}
+
+// savedSearch is a named search along with the options that it
+// should be run with.
+type savedSearch struct {
+	Query     string `json:"query,omitempty"`
+	QueryFile string `json:"query_file,omitempty"`
+	Index     string `json:"index,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Size      int    `json:"size,omitempty"`
+}
+
+// expandPath expands a leading ~ to the user's home directory.
+func expandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+// loadConfigFile reads the configuration file at path, a missing file
+// is treated as an empty configuration.
+func loadConfigFile(path string) (cfg *configFile, err error) {
+	cfg = &configFile{path: expandPath(path)}
+	data, err := ioutil.ReadFile(cfg.path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, errors.Annotate(err, "Could not read the configuration file")
+	}
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return cfg, errors.Annotatef(err, "Could not parse the configuration file '%s'", cfg.path)
+	}
+	return cfg, nil
+}
+
+// Save writes the configuration back to the file it was loaded from.
+func (cfg *configFile) Save() (err error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cfg.path, append(data, '\n'), 0600)
+}
+
+// SavedSearch looks up the saved search by name, the name may include
+// the leading @.
+func (cfg *configFile) SavedSearch(name string) (saved savedSearch, err error) {
+	name = strings.TrimPrefix(name, savedPrefix)
+	saved, ok := cfg.Saved[name]
+	if !ok {
+		return saved, errors.Errorf("No saved search named '%s' in %s", name, cfg.path)
+	}
+	return saved, nil
+}
+
+// isSavedRef determines if the query argument is referencing a saved
+// search, queries of fields starting with @ (ex: @timestamp:>now-1h)
+// are not.
+func isSavedRef(arg string) bool {
+	return strings.HasPrefix(arg, savedPrefix) && savedNamePattern.MatchString(arg[len(savedPrefix):])
+}
+
+// apply configures the run with the saved search, any options that
+// were explicitly provided on the command line take precedence and
+// extra arguments are required to match as well.
+func (s savedSearch) apply(c *cli.Context, run *Config, extra []string) (err error) {
+	if s.QueryFile != "" {
+		if len(extra) != 0 {
+			return errors.New("Saved search uses a query file, extra query arguments cannot be added")
+		}
+		run.query = ""
+		run.queryFile = expandPath(s.QueryFile)
+	} else {
+		run.query = s.Query
+		if len(extra) != 0 {
+			run.query = fmt.Sprintf("(%s) AND (%s)", s.Query, strings.Join(extra, " "))
+		}
+	}
+
+	if s.Index != "" && !c.IsSet("query-index") {
+		run.queryIndex = s.Index
+	}
+	if s.Size != 0 && !c.IsSet("query-size") {
+		run.querySize = s.Size
+	}
+	if s.Format != "" && !c.IsSet("format") && !c.Bool("format-stdline") {
+		run.formatTemplate = s.Format
+	}
+	return nil
+}
+
+// savedConfig loads the configuration file for the saved subcommands.
+func savedConfig(c *cli.Context) (cfg *configFile, err error) {
+	cfg, err = loadConfigFile(c.GlobalString("config"))
+	if err != nil {
+		return cfg, cli.NewExitError(err.Error(), 1)
+	}
+	return cfg, nil
+}
+
+// savedName retrieves the name argument for the saved subcommands.
+func savedName(c *cli.Context) (name string, err error) {
+	name = strings.TrimPrefix(c.Args().First(), savedPrefix)
+	if name == "" {
+		return name, cli.NewExitError("A saved search name must be provided", 1)
+	}
+	return name, nil
+}
+
+// RunSavedList lists the saved searches.
+func RunSavedList(c *cli.Context) (err error) {
+	cfg, err := savedConfig(c)
+	if err != nil {
+		return err
+	}
+	printSaved(os.Stdout, cfg.Saved)
+	return nil
+}
+
+// printSaved writes a row for each saved search with its index, size,
+// format and query, "-" standing in for those not saved.
+func printSaved(out io.Writer, saved map[string]savedSearch) {
+	names := make([]string, 0, len(saved))
+	for name := range saved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tabbed := tabwriter.NewWriter(out, 6, 2, 2, ' ', 0)
+	defer tabbed.Flush()
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	for _, name := range names {
+		s := saved[name]
+		query := s.Query
+		if s.QueryFile != "" {
+			query = "file:" + s.QueryFile
+		}
+		size := ""
+		if s.Size != 0 {
+			size = strconv.Itoa(s.Size)
+		}
+		fmt.Fprintf(tabbed, "%s%s\t%s\t%s\t%s\t%s\n", savedPrefix, name,
+			orDash(s.Index), orDash(size), orDash(s.Format), query)
+	}
+}
+
+// RunSavedShow prints out the saved search.
+func RunSavedShow(c *cli.Context) (err error) {
+	cfg, err := savedConfig(c)
+	if err != nil {
+		return err
+	}
+	name, err := savedName(c)
+	if err != nil {
+		return err
+	}
+	saved, err := cfg.SavedSearch(name)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", data)
+	return nil
+}
+
+// RunSavedAdd adds or replaces a saved search.
+func RunSavedAdd(c *cli.Context) (err error) {
+	cfg, err := savedConfig(c)
+	if err != nil {
+		return err
+	}
+	name, err := savedName(c)
+	if err != nil {
+		return err
+	}
+	if !savedNamePattern.MatchString(name) {
+		return cli.NewExitError(fmt.Sprintf("The saved search name '%s' may only have letters, digits, _ and -", name), 1)
+	}
+	saved := savedSearch{
+		Query:     strings.Join(c.Args().Tail(), " "),
+		QueryFile: c.String("query-file"),
+		Index:     c.String("query-index"),
+		Format:    c.String("format"),
+		Size:      c.Int("query-size"),
+	}
+	if saved.Query == "" && saved.QueryFile == "" {
+		return cli.NewExitError("No query provided", 3)
+	}
+	if saved.Query != "" && saved.QueryFile != "" {
+		return cli.NewExitError("You've provided multiple queries (file and lucene perhaps?)", 3)
+	}
+	if cfg.Saved == nil {
+		cfg.Saved = make(map[string]savedSearch)
+	}
+	cfg.Saved[name] = saved
+	return cfg.Save()
+}
+
+// RunSavedRemove removes a saved search.
+func RunSavedRemove(c *cli.Context) (err error) {
+	cfg, err := savedConfig(c)
+	if err != nil {
+		return err
+	}
+	name, err := savedName(c)
+	if err != nil {
+		return err
+	}
+	if _, err = cfg.SavedSearch(name); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	delete(cfg.Saved, name)
+	return cfg.Save()
+}
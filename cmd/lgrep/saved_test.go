@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codegangsta/cli"
+)
+
+// queryContext parses the query flags of the arguments, only the
+// flags' first names are set (as the app would normalize them).
+func queryContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("lgrep", flag.ContinueOnError)
+	for _, f := range QueryFlags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(App(), set, nil)
+}
+
+func TestIsSavedRef(t *testing.T) {
+	examples := map[string]bool{
+		"@edge-5xx":                  true,
+		"@web_1":                     true,
+		"@":                          false,
+		"edge-5xx":                   false,
+		"@timestamp:[now-1h TO now]": false,
+		"@timestamp:>now-1h":         false,
+		"@edge 5xx":                  false,
+	}
+	for arg, expected := range examples {
+		if isSavedRef(arg) != expected {
+			t.Errorf("Expected isSavedRef(%q) to be %t", arg, expected)
+		}
+	}
+}
+
+func TestSavedApply(t *testing.T) {
+	saved := savedSearch{Query: "status:>=500", Index: "edge-*", Format: "{{.status}}", Size: 10}
+
+	run := Config{}
+	if err := saved.apply(queryContext(t), &run, nil); err != nil {
+		t.Fatal(err)
+	}
+	if run.query != saved.Query || run.queryIndex != saved.Index || run.formatTemplate != saved.Format || run.querySize != saved.Size {
+		t.Errorf("The saved search wasn't applied: %+v", run)
+	}
+
+	// The options given on the command line take precedence.
+	c := queryContext(t, "--query-index", "logs-*", "--query-size", "50", "--format", "{{.host}}")
+	run = Config{queryIndex: "logs-*", querySize: 50, formatTemplate: "{{.host}}"}
+	if err := saved.apply(c, &run, []string{"host:web1", "OR", "host:web2"}); err != nil {
+		t.Fatal(err)
+	}
+	if run.queryIndex != "logs-*" || run.querySize != 50 || run.formatTemplate != "{{.host}}" {
+		t.Errorf("The saved search overrode the command line: %+v", run)
+	}
+	if expected := "(status:>=500) AND (host:web1 OR host:web2)"; run.query != expected {
+		t.Errorf("Expected the query %q, got %q", expected, run.query)
+	}
+
+	run = Config{}
+	if err := saved.apply(queryContext(t, "--format-stdline"), &run, nil); err != nil {
+		t.Fatal(err)
+	}
+	if run.formatTemplate != "" {
+		t.Errorf("The saved format overrode -tt: %q", run.formatTemplate)
+	}
+
+	fromFile := savedSearch{QueryFile: "~/queries/slow.json"}
+	run = Config{query: "ignored"}
+	if err := fromFile.apply(queryContext(t), &run, nil); err != nil {
+		t.Fatal(err)
+	}
+	if run.query != "" || run.queryFile != filepath.Join(os.Getenv("HOME"), "queries/slow.json") {
+		t.Errorf("The query file wasn't applied: %+v", run)
+	}
+	if err := fromFile.apply(queryContext(t), &Config{}, []string{"host:web1"}); err == nil {
+		t.Error("Expected extra arguments to be rejected with a query file")
+	}
+}
+
+func TestSavedAddRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lgrep.json")
+
+	args := []string{"lgrep", "--config", path, "saved", "add", "-Qi", "edge-*", "-n", "20", "@edge-5xx", "status:>=500"}
+	if err = App().Run(args); err != nil {
+		t.Fatal(err)
+	}
+	if err = App().Run([]string{"lgrep", "--config", path, "saved", "add", "slow", "took:>1000"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := savedSearch{Query: "status:>=500", Index: "edge-*", Size: 20}
+	if saved, err := cfg.SavedSearch("@edge-5xx"); err != nil || saved != expected {
+		t.Errorf("Saved %+v (%v), expected %+v", saved, err, expected)
+	}
+
+	if err = App().Run([]string{"lgrep", "--config", path, "saved", "rm", "@edge-5xx"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = loadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cfg.SavedSearch("edge-5xx"); err == nil {
+		t.Error("Expected edge-5xx to be removed")
+	}
+	if _, err = cfg.SavedSearch("slow"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrintSaved(t *testing.T) {
+	var out bytes.Buffer
+	printSaved(&out, map[string]savedSearch{
+		"slow":     {QueryFile: "~/queries/slow.json"},
+		"edge-5xx": {Query: "status:>=500", Index: "edge-*", Format: ".status .path", Size: 20},
+	})
+	expected := "@edge-5xx  edge-*  20    .status .path  status:>=500\n" +
+		"@slow      -       -     -              file:~/queries/slow.json\n"
+	if out.String() != expected {
+		t.Errorf("Listed:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
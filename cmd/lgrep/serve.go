@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+const (
+	// ndjsonContentType is used for streams of JSON documents, one per
+	// line.
+	ndjsonContentType = "application/x-ndjson"
+	// sseContentType is used for streams of Server-Sent Events.
+	sseContentType = "text/event-stream"
+)
+
+var (
+	// ServeCommand serves searches over HTTP.
+	ServeCommand = cli.Command{
+		Name:  "serve",
+		Usage: "Serve searches, counts and validations over HTTP",
+		Description: `Endpoints:
+
+   /search    Stream the results as NDJSON, or as Server-Sent Events when
+              'Accept: text/event-stream' is given
+   /count     Count the matching documents
+   /validate  Validate the query
+
+   Options are given as a JSON body (POST) or as URL parameters (GET):
+
+   query, source (a raw elasticsearch query body), format, size, index,
+   type, fields, raw_result, patterns, patterns_all, invert, match, sort,
+   sort_time (asc or desc) and search_after
+
+   ex: curl 'localhost:8080/search?query=status:500&format=.host+.message'`,
+		Action: RunServe,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "listen",
+				Usage: "Address to listen on",
+				Value: ":8080",
+			},
+		},
+	}
+)
+
+// apiRequest holds the options of a request to the API, they mirror
+// lgrep.SearchOptions.
+type apiRequest struct {
+	// Query is a lucene query.
+	Query string `json:"query"`
+	// Source is a raw elasticsearch query body, used instead of Query.
+	Source json.RawMessage `json:"source"`
+	// Format is a template that the results are formatted with,
+	// they're returned as JSON when not given.
+	Format string `json:"format"`
+
+	Size        int           `json:"size"`
+	Index       string        `json:"index"`
+	Indices     []string      `json:"indices"`
+	Type        string        `json:"type"`
+	Types       []string      `json:"types"`
+	Fields      []string      `json:"fields"`
+	RawResult   bool          `json:"raw_result"`
+	Patterns    []string      `json:"patterns"`
+	PatternsAll bool          `json:"patterns_all"`
+	Invert      bool          `json:"invert"`
+	Match       []string      `json:"match"`
+	Sort        string        `json:"sort"`
+	SortTime    string        `json:"sort_time"`
+	SearchAfter []interface{} `json:"search_after"`
+}
+
+// parseAPIRequest reads the request's options from its JSON body, or
+// from the URL parameters when there's no body.
+func parseAPIRequest(r *http.Request) (req apiRequest, err error) {
+	if r.Method == "POST" {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && err != io.EOF {
+			return req, errors.Annotate(err, "Request body must be a JSON object of options")
+		}
+		return req, nil
+	}
+
+	params := r.URL.Query()
+	list := func(name string) (values []string) {
+		for _, v := range params[name] {
+			values = append(values, strings.Split(v, ",")...)
+		}
+		return values
+	}
+	flag := func(name string) bool {
+		b, _ := strconv.ParseBool(params.Get(name))
+		return b
+	}
+	req = apiRequest{
+		Query:       params.Get("query"),
+		Format:      params.Get("format"),
+		Index:       params.Get("index"),
+		Type:        params.Get("type"),
+		Fields:      list("fields"),
+		RawResult:   flag("raw_result"),
+		Patterns:    params["patterns"],
+		PatternsAll: flag("patterns_all"),
+		Invert:      flag("invert"),
+		Match:       params["match"],
+		Sort:        params.Get("sort"),
+		SortTime:    params.Get("sort_time"),
+	}
+	if source := params.Get("source"); source != "" {
+		req.Source = json.RawMessage(source)
+	}
+	if size := params.Get("size"); size != "" {
+		if req.Size, err = strconv.Atoi(size); err != nil {
+			return req, errors.Errorf("Size '%s' must be a number", size)
+		}
+	}
+	return req, nil
+}
+
+// searchOptions creates the search specification for the request.
+func (req apiRequest) searchOptions() (spec *lgrep.SearchOptions, err error) {
+	spec = &lgrep.SearchOptions{
+		Size:        req.Size,
+		Index:       req.Index,
+		Indices:     req.Indices,
+		Type:        req.Type,
+		Types:       req.Types,
+		Fields:      req.Fields,
+		RawResult:   req.RawResult,
+		Patterns:    req.Patterns,
+		PatternsAll: req.PatternsAll,
+		Invert:      req.Invert,
+		SortTime:    lgrep.SortDesc,
+		SearchAfter: req.SearchAfter,
+	}
+	if spec.Size == 0 {
+		spec.Size = lgrep.DefaultSpec.Size
+	}
+	switch req.SortTime {
+	case "", "desc":
+	case "asc":
+		spec.SortTime = lgrep.SortAsc
+	default:
+		return spec, errors.Errorf("Sort time '%s' must be asc or desc", req.SortTime)
+	}
+	if req.Sort != "" {
+		if spec.Sort, err = lgrep.ParseSortFields(req.Sort); err != nil {
+			return spec, err
+		}
+	}
+	for _, m := range req.Match {
+		match, err := lgrep.ParseFieldMatch(m)
+		if err != nil {
+			return spec, err
+		}
+		spec.Matches = append(spec.Matches, match)
+	}
+	// Only the fields that are formatted are needed.
+	if len(spec.Fields) == 0 && req.Format != "" && !lgrep.IsRawFormat(req.Format) {
+		spec.Fields = append(lgrep.FieldTokens(req.Format), "@timestamp", "date")
+		for _, m := range spec.Matches {
+			spec.Fields = append(spec.Fields, m.Field)
+		}
+	}
+	return spec, nil
+}
+
+// apiServer handles the requests to the API.
+type apiServer struct {
+	l lgrep.LGrep
+}
+
+// routes returns the handler of each of the endpoints.
+func (a apiServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", a.search)
+	mux.HandleFunc("/count", a.count)
+	mux.HandleFunc("/validate", a.validate)
+	return mux
+}
+
+// search streams the results of the search to the client, the search
+// is stopped when the client disconnects.
+func (a apiServer) search(w http.ResponseWriter, r *http.Request) {
+	req, spec, err := a.request(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	format := func(r lgrep.Result) ([]byte, error) { return r.JSON() }
+	if req.Format != "" {
+		if format, err = lgrep.Formatter(req.Format); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	var stream *lgrep.SearchStream
+	if req.Source != nil {
+		stream, err = a.l.SearchWithSourceStream(req.Source, spec)
+	} else {
+		stream, err = a.l.SimpleSearchStream(req.Query, spec)
+	}
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+
+	out := newEventWriter(w, strings.Contains(r.Header.Get("Accept"), sseContentType), req.Format != "")
+	count := 0
+	err = stream.EachCancel(r.Context().Done(), func(result lgrep.Result) error {
+		data, err := format(result)
+		if err != nil {
+			log.Warn(errors.Annotate(err, "error formatting result"))
+			return nil
+		}
+		count++
+		return out.Result(data)
+	}, func(e error) error { return e })
+	switch {
+	case err == lgrep.ErrStreamCancelled:
+		log.Debugf("Client disconnected after %d results", count)
+	case err != nil:
+		out.Error(err)
+	default:
+		out.End(count)
+	}
+}
+
+// count responds with the number of matching documents.
+func (a apiServer) count(w http.ResponseWriter, r *http.Request) {
+	req, spec, err := a.request(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	var count int64
+	if req.Source != nil {
+		count, err = a.l.CountWithSource(req.Source, spec)
+	} else {
+		count, err = a.l.SimpleCount(req.Query, spec)
+	}
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"count": count})
+}
+
+// validate responds with the validation of the query, invalid queries
+// are a bad request.
+func (a apiServer) validate(w http.ResponseWriter, r *http.Request) {
+	req, spec, err := a.request(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	var result lgrep.ValidationResponse
+	if req.Source != nil {
+		result, err = a.l.ValidateSource(req.Source, spec)
+	} else {
+		result, err = a.l.Validate(req.Query, spec)
+	}
+	status := http.StatusOK
+	response := map[string]interface{}{
+		"valid":        err == nil,
+		"explanations": result.Explanations,
+	}
+	if err != nil {
+		status, response["error"] = apiErrorStatus(err), err.Error()
+		if serr, ok := err.(*lgrep.LuceneSyntaxError); ok {
+			response["column"] = serr.Column
+		}
+	}
+	writeJSON(w, status, response)
+}
+
+// request parses the request's options, a query (or source) is
+// required.
+func (a apiServer) request(r *http.Request) (req apiRequest, spec *lgrep.SearchOptions, err error) {
+	if req, err = parseAPIRequest(r); err != nil {
+		return req, spec, err
+	}
+	if req.Query == "" && req.Source == nil && len(req.Patterns) == 0 {
+		return req, spec, lgrep.ErrEmptySearch
+	}
+	if req.Source != nil && (req.Query != "" || len(req.Patterns) != 0) {
+		return req, spec, errors.New("Either a query or a source should be given, not both")
+	}
+	spec, err = req.searchOptions()
+	return req, spec, err
+}
+
+// apiErrorStatus determines the status that an error is reported
+// with, problems with the query are a bad request while other errors
+// from the server are a bad gateway.
+func apiErrorStatus(err error) int {
+	if _, ok := err.(*lgrep.LuceneSyntaxError); ok {
+		return http.StatusBadRequest
+	}
+	switch errors.Cause(err) {
+	case lgrep.ErrEmptySearch, lgrep.ErrZeroSize, lgrep.ErrInvalidQuery, lgrep.ErrInvalidLuceneSyntax, lgrep.ErrInvalidIndex:
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// writeAPIError responds with the error as JSON, syntax errors include
+// the column they're at.
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	response := map[string]interface{}{"error": err.Error()}
+	if serr, ok := err.(*lgrep.LuceneSyntaxError); ok {
+		response["column"] = serr.Column
+		if serr.Suggestion != "" {
+			response["suggestion"] = serr.Suggestion
+		}
+	}
+	writeJSON(w, status, response)
+}
+
+// writeJSON responds with the value as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug(errors.Annotate(err, "Could not write the response"))
+	}
+}
+
+// eventWriter streams results to the client as NDJSON or Server-Sent
+// Events, flushing each one.
+type eventWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+	// formatted results are text rather than JSON.
+	formatted bool
+}
+
+// newEventWriter starts the response to stream results with.
+func newEventWriter(w http.ResponseWriter, sse bool, formatted bool) *eventWriter {
+	e := &eventWriter{w: w, sse: sse, formatted: formatted}
+	e.flusher, _ = w.(http.Flusher)
+	if sse {
+		w.Header().Set("Content-Type", sseContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", ndjsonContentType)
+	}
+	w.WriteHeader(http.StatusOK)
+	return e
+}
+
+// Result writes a result, formatted results are written as JSON
+// strings in NDJSON.
+func (e *eventWriter) Result(data []byte) (err error) {
+	if e.sse {
+		return e.event("", data)
+	}
+	if e.formatted {
+		if data, err = json.Marshal(string(data)); err != nil {
+			return err
+		}
+	}
+	return e.line(data)
+}
+
+// Error writes an error that ended the stream.
+func (e *eventWriter) Error(err error) {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	if e.sse {
+		e.event("error", data)
+		return
+	}
+	e.line(data)
+}
+
+// End marks the end of the stream for SSE clients, who would otherwise
+// reconnect.
+func (e *eventWriter) End(count int) {
+	if e.sse {
+		e.event("end", []byte(fmt.Sprintf(`{"count":%d}`, count)))
+	}
+}
+
+// line writes the data as a line of NDJSON.
+func (e *eventWriter) line(data []byte) error {
+	if _, err := fmt.Fprintf(e.w, "%s\n", data); err != nil {
+		return err
+	}
+	e.flush()
+	return nil
+}
+
+// event writes the data as a Server-Sent Event, each line of the data
+// is given separately.
+func (e *eventWriter) event(name string, data []byte) error {
+	if name != "" {
+		if _, err := fmt.Fprintf(e.w, "event: %s\n", name); err != nil {
+			return err
+		}
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if _, err := fmt.Fprintf(e.w, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprint(e.w, "\n"); err != nil {
+		return err
+	}
+	e.flush()
+	return nil
+}
+
+func (e *eventWriter) flush() {
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+}
+
+// RunServe serves the API until the server fails.
+func RunServe(c *cli.Context) (err error) {
+	l, err := newClient(c.GlobalString("endpoint"))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	addr := c.String("listen")
+	log.Infof("Serving searches of %s on %s", l.Endpoint, addr)
+	err = http.ListenAndServe(addr, apiServer{l: l}.routes())
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+)
+
+func TestParseAPIRequest(t *testing.T) {
+	get, _ := http.NewRequest("GET", "/search?query=status:500&size=10&fields=host,message&match=host%3Dweb&invert=true", nil)
+	post, _ := http.NewRequest("POST", "/search", strings.NewReader(
+		`{"query": "status:500", "size": 10, "fields": ["host", "message"], "match": ["host=web"], "invert": true}`))
+	expected := apiRequest{
+		Query:  "status:500",
+		Size:   10,
+		Fields: []string{"host", "message"},
+		Match:  []string{"host=web"},
+		Invert: true,
+	}
+	for _, r := range []*http.Request{get, post} {
+		req, err := parseAPIRequest(r)
+		if err != nil {
+			t.Errorf("%s request: %s", r.Method, err)
+			continue
+		}
+		if !reflect.DeepEqual(req, expected) {
+			t.Errorf("%s request parsed as %+v, expected %+v", r.Method, req, expected)
+		}
+	}
+
+	spec, err := expected.searchOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Size != 10 || len(spec.Matches) != 1 || !spec.Invert || *spec.SortTime != *lgrep.SortDesc {
+		t.Errorf("Search options %+v", spec)
+	}
+	if _, err = (apiRequest{Query: "x", SortTime: "sideways"}).searchOptions(); err == nil {
+		t.Error("Expected an error for an invalid sort_time")
+	}
+}
+
+func TestAPIRequestErrors(t *testing.T) {
+	examples := []struct {
+		url    string
+		status int
+		column int
+	}{
+		{"/search", http.StatusBadRequest, 0},
+		{"/search?query=status:(500", http.StatusBadRequest, 8},
+		{"/count?query=x&match=nope", http.StatusBadRequest, 0},
+		{"/validate?query=x&size=ten", http.StatusBadRequest, 0},
+		{"/search?query=x&source={}", http.StatusBadRequest, 0},
+	}
+	routes := apiServer{}.routes()
+	for _, ex := range examples {
+		r, _ := http.NewRequest("GET", ex.url, nil)
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		if w.Code != ex.status {
+			t.Errorf("%s responded %d, expected %d", ex.url, w.Code, ex.status)
+		}
+		var response struct {
+			Error  string `json:"error"`
+			Column int    `json:"column"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Error == "" {
+			t.Errorf("%s responded without an error: %s", ex.url, w.Body)
+		}
+		if response.Column != ex.column {
+			t.Errorf("%s error at column %d, expected %d", ex.url, response.Column, ex.column)
+		}
+	}
+}
+
+func TestEventWriter(t *testing.T) {
+	examples := []struct {
+		sse, formatted bool
+		expected       string
+	}{
+		{false, false, "{\"host\":\"web1\"}\n{\"error\":\"failed\"}\n"},
+		{false, true, "\"web1\\nweb2\"\n{\"error\":\"failed\"}\n"},
+		{true, true, "data: web1\ndata: web2\n\nevent: error\ndata: {\"error\":\"failed\"}\n\n"},
+	}
+	for _, ex := range examples {
+		w := httptest.NewRecorder()
+		out := newEventWriter(w, ex.sse, ex.formatted)
+		data := []byte(`{"host":"web1"}`)
+		if ex.formatted {
+			data = []byte("web1\nweb2")
+		}
+		out.Result(data)
+		out.Error(errors.New("failed"))
+		if body := w.Body.String(); body != ex.expected {
+			t.Errorf("Wrote (sse: %t, formatted: %t) %q, expected %q", ex.sse, ex.formatted, body, ex.expected)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	newEventWriter(w, true, false).End(2)
+	if !bytes.Contains(w.Body.Bytes(), []byte("event: end\ndata: {\"count\":2}\n\n")) {
+		t.Errorf("End of stream event missing: %q", w.Body.String())
+	}
+}
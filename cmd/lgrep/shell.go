@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+const (
+	// DefaultHistoryFile is where the shell's history is kept.
+	DefaultHistoryFile = "~/.lgrep_history"
+	shellPrompt        = "lgrep> "
+	shellCommandPrefix = ":"
+)
+
+var (
+	// ShellCommand runs queries interactively.
+	ShellCommand = cli.Command{
+		Name:  "shell",
+		Usage: "Run queries interactively, keeping the settings between them",
+		Description: `Each line entered is run as a lucene query with the session's settings,
+   which are changed with :set (see :help). Ctrl-C cancels the running query.`,
+		Action: RunShell,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "format, format-template, t",
+				Usage: "Simple formatting using text/template (go stdlib)",
+				Value: DefaultFormat,
+			},
+			cli.IntFlag{
+				Name:  "query-size, n, Qn",
+				Usage: "Number of results to be returned",
+				Value: 100,
+			},
+			cli.StringFlag{
+				Name:  "query-index, Qi",
+				Usage: "Query this index in elasticsearch, if not provided - all indicies",
+			},
+			cli.StringFlag{
+				Name:   "history",
+				Usage:  "File the history of entered lines is kept in",
+				Value:  DefaultHistoryFile,
+				EnvVar: "LGREP_HISTORY",
+			},
+		},
+	}
+
+	// shellSettings are the settings that may be changed with :set, in
+	// the order they're listed.
+	shellSettings = []string{"endpoint", "index", "format", "size", "from", "to", "timefield"}
+
+	// shellCommands are the commands the shell understands.
+	shellCommands = []string{":set", ":unset", ":fields", ":help", ":quit"}
+
+	shellHelp = `Enter a lucene query to run it with the session's settings.
+
+:set                 Show the settings
+:set SETTING VALUE   Change a setting
+:unset SETTING       Clear the time range or index
+:fields              List the fields of the searched indices
+:help                Show this help
+:quit                Exit (or Ctrl-D)
+
+Settings:
+  endpoint   Elasticsearch endpoint
+  index      Index to search, all indices when unset
+  format     Format template for the results
+  size       Number of results to return
+  from, to   Time range of the results (ex: now-1h, 2016-04-29T13:00:00)
+  timefield  Field the time range applies to`
+)
+
+// shellSession is the state of an interactive shell.
+type shellSession struct {
+	// run is the configuration that each query is run with.
+	run Config
+	// from and to bound the time range of the results, in any form
+	// that elasticsearch understands in a range.
+	from, to  string
+	timeField string
+
+	out    io.Writer
+	client *lgrep.LGrep
+	// fields are the fields known from the mapping, for completion.
+	fields []string
+	// interrupts receives Ctrl-C while a query runs.
+	interrupts chan os.Signal
+}
+
+// RunShell reads and runs queries until the input is closed.
+func RunShell(c *cli.Context) (err error) {
+	s := &shellSession{
+		run: Config{
+			endpoint:       c.GlobalString("endpoint"),
+			debug:          c.GlobalBool("debug"),
+			file:           c.GlobalString("file"),
+			querySize:      c.Int("query-size"),
+			queryIndex:     c.String("query-index"),
+			formatTemplate: c.String("format"),
+		},
+		timeField:  "@timestamp",
+		out:        os.Stdout,
+		interrupts: make(chan os.Signal, 1),
+	}
+	// Ctrl-C is handled by the shell, cancelling a query rather than
+	// exiting.
+	signal.Notify(s.interrupts, os.Interrupt)
+	defer signal.Stop(s.interrupts)
+
+	editor := newLineEditor(expandPath(c.String("history")), s.complete)
+	if editor.fd != -1 {
+		fmt.Fprintln(s.out, "Enter a query to search, :help for help and Ctrl-D to exit.")
+	}
+	for {
+		line, err := editor.ReadLine(shellPrompt)
+		if err == errInterrupted {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err = editor.AddHistory(line); err != nil {
+			log.Warn(err)
+		}
+		if line == ":quit" || line == ":exit" {
+			return nil
+		}
+		if err = s.execute(line); err != nil {
+			if serr, ok := err.(*lgrep.LuceneSyntaxError); ok {
+				fmt.Fprintln(os.Stderr, serr.Explain())
+			} else {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// execute runs a line of input, either a command or a query.
+func (s *shellSession) execute(line string) error {
+	if !strings.HasPrefix(line, shellCommandPrefix) {
+		return s.query(line)
+	}
+	fields := strings.Fields(line)
+	args := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	switch fields[0] {
+	case ":set":
+		if args == "" {
+			s.printSettings()
+			return nil
+		}
+		parts := strings.SplitN(args, " ", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+		}
+		return s.set(parts[0], value)
+	case ":unset":
+		return s.set(args, "")
+	case ":fields":
+		fields, err := s.loadFields()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(s.out, strings.Join(fields, "\n"))
+		return nil
+	case ":help":
+		fmt.Fprintln(s.out, shellHelp)
+		return nil
+	}
+	return errors.Errorf("Unknown command '%s', see :help", fields[0])
+}
+
+// set changes a setting of the session, an empty value clears those
+// that may be cleared.
+func (s *shellSession) set(setting string, value string) error {
+	switch setting {
+	case "endpoint":
+		if value == "" {
+			return errors.New("The endpoint can't be cleared")
+		}
+		s.run.endpoint = value
+		s.client, s.fields = nil, nil
+	case "index":
+		s.run.queryIndex = value
+		s.fields = nil
+	case "format":
+		if value == "" {
+			value = DefaultFormat
+		}
+		if _, err := lgrep.Formatter(value); err != nil {
+			return err
+		}
+		s.run.formatTemplate = value
+	case "size":
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return errors.Errorf("Size '%s' must be a positive number", value)
+		}
+		s.run.querySize = size
+	case "from":
+		s.from = value
+	case "to":
+		s.to = value
+	case "timefield":
+		if value == "" {
+			value = "@timestamp"
+		}
+		s.timeField = value
+	default:
+		return errors.Errorf("Unknown setting '%s', one of: %s", setting, strings.Join(shellSettings, ", "))
+	}
+	return nil
+}
+
+// printSettings shows the current value of each setting.
+func (s *shellSession) printSettings() {
+	values := map[string]string{
+		"endpoint":  s.run.endpoint,
+		"index":     s.run.queryIndex,
+		"format":    s.run.formatTemplate,
+		"size":      strconv.Itoa(s.run.querySize),
+		"from":      s.from,
+		"to":        s.to,
+		"timefield": s.timeField,
+	}
+	for _, setting := range shellSettings {
+		fmt.Fprintf(s.out, "%-10s %s\n", setting, values[setting])
+	}
+}
+
+// connect creates the client for the endpoint (or file), once.
+func (s *shellSession) connect() (*lgrep.LGrep, error) {
+	if s.client != nil {
+		return s.client, nil
+	}
+	l, err := s.run.client()
+	if err != nil {
+		return nil, err
+	}
+	s.client = &l
+	return s.client, nil
+}
+
+// loadFields retrieves the fields of the searched indices, once.
+func (s *shellSession) loadFields() ([]string, error) {
+	if s.fields != nil {
+		return s.fields, nil
+	}
+	l, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := l.Fields(s.run.searchOptions())
+	if err != nil {
+		return nil, errors.Annotate(err, "Could not retrieve the fields")
+	}
+	s.fields = fields
+	return fields, nil
+}
+
+// complete completes commands, settings and the field names of the
+// searched indices.
+func (s *shellSession) complete(word string) (completions []string) {
+	var candidates []string
+	switch {
+	case strings.HasPrefix(word, shellCommandPrefix):
+		candidates = shellCommands
+	case strings.Contains(word, ":"):
+		return nil
+	default:
+		fields, err := s.loadFields()
+		if err != nil {
+			log.Debug(err)
+			return nil
+		}
+		for _, field := range fields {
+			candidates = append(candidates, field+":")
+		}
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			completions = append(completions, candidate)
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
+
+// lucene returns the query limited to the session's time range.
+func (s *shellSession) lucene(q string) string {
+	if s.from == "" && s.to == "" {
+		return q
+	}
+	from, to := s.from, s.to
+	if from == "" {
+		from = "*"
+	}
+	if to == "" {
+		to = "*"
+	}
+	return fmt.Sprintf("(%s) AND %s:[%s TO %s]", q, lgrep.EscapeLucene(s.timeField), from, to)
+}
+
+// query runs the lucene query with the session's settings, printing
+// the results as they're read until the query finishes or Ctrl-C is
+// pressed.
+func (s *shellSession) query(q string) (err error) {
+	run := s.run
+	run.query = s.lucene(q)
+	if _, err = lgrep.ParseLucene(run.query); err != nil {
+		return err
+	}
+	run.formatRaw = lgrep.IsRawFormat(run.formatTemplate)
+	if !run.formatRaw {
+		run.queryFields = append(lgrep.FieldTokens(run.formatTemplate), "@timestamp", "date")
+	}
+	formatter, flush, err := run.formatter(s.out)
+	if err != nil {
+		return err
+	}
+	defer flush()
+
+	l, err := s.connect()
+	if err != nil {
+		return err
+	}
+	// A Ctrl-C from before the query shouldn't cancel it.
+	select {
+	case <-s.interrupts:
+	default:
+	}
+	stream, err := l.SimpleSearchStream(run.query, run.searchOptions())
+	if err != nil {
+		return err
+	}
+
+	// Ctrl-C cancels reading the results.
+	cancel, done := make(chan struct{}), make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.interrupts:
+			close(cancel)
+		case <-done:
+		}
+	}()
+
+	count := 0
+	err = stream.EachCancel(cancel, func(r lgrep.Result) error {
+		count++
+		if err := formatter(r); err != nil {
+			log.Warn(errors.Annotate(err, "error formatting result"))
+		}
+		return nil
+	}, func(e error) error { return e })
+	if err == lgrep.ErrStreamCancelled {
+		fmt.Fprintf(os.Stderr, "^C cancelled after %d results\n", count)
+		return nil
+	}
+	if err == nil && count == 0 {
+		log.Warn("0 results returned")
+	}
+	return err
+}
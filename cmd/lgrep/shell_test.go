@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestShellSet(t *testing.T) {
+	s := &shellSession{run: Config{querySize: 100}, timeField: "@timestamp", out: &bytes.Buffer{}}
+	for _, line := range []string{":set index logstash-*", ":set size 10", ":set from now-1h", ":set format .host .message"} {
+		if err := s.execute(line); err != nil {
+			t.Fatalf("%s: %s", line, err)
+		}
+	}
+	if s.run.queryIndex != "logstash-*" || s.run.querySize != 10 || s.run.formatTemplate != ".host .message" {
+		t.Errorf("Settings weren't changed: %+v", s.run)
+	}
+	if q := s.lucene("status:500"); q != "(status:500) AND @timestamp:[now-1h TO *]" {
+		t.Errorf("Query with the time range: %s", q)
+	}
+	if err := s.execute(":unset from"); err != nil || s.lucene("status:500") != "status:500" {
+		t.Errorf("Time range wasn't cleared: %v", err)
+	}
+
+	for _, line := range []string{":set size none", ":set colour red", ":bogus"} {
+		if err := s.execute(line); err == nil {
+			t.Errorf("Expected an error from '%s'", line)
+		}
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	s := &shellSession{fields: []string{"host", "host.raw", "message"}}
+	examples := map[string][]string{
+		"ho":     {"host.raw:", "host:"},
+		"mess":   {"message:"},
+		":s":     {":set"},
+		"host:w": nil,
+	}
+	for word, expected := range examples {
+		if completions := s.complete(word); !reflect.DeepEqual(completions, expected) {
+			t.Errorf("Completions of '%s' %q (expected %q)", word, completions, expected)
+		}
+	}
+}
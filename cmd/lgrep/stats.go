@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+var (
+	// StatsCommand computes statistics of a numeric field.
+	StatsCommand = cli.Command{
+		Name:      "stats",
+		Usage:     "Compute count, min, max, avg, sum and percentiles of a numeric field",
+		ArgsUsage: ".FIELD QUERY",
+		Action:    RunStats,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "query-index, Qi",
+				Usage: "Query this index in elasticsearch, if not provided - all indicies",
+			},
+			cli.StringFlag{
+				Name:  "by",
+				Usage: "Compute the statistics for each of the most common values of this field",
+			},
+			cli.IntFlag{
+				Name:  "groups",
+				Usage: "Number of groups to compute statistics for with --by",
+				Value: lgrep.DefaultStatsGroups,
+			},
+			cli.StringFlag{
+				Name:  "percentiles",
+				Usage: "Percentiles to compute (ex: 50,99,99.9)",
+				Value: formatPercents(lgrep.DefaultPercentiles),
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Output the statistics as JSON (1 line per group)",
+			},
+		},
+	}
+)
+
+// RunStats computes and prints the statistics of the field.
+func RunStats(c *cli.Context) (err error) {
+	field := strings.TrimPrefix(c.Args().First(), ".")
+	query := strings.Join(c.Args().Tail(), " ")
+	if field == "" || query == "" {
+		return cli.NewExitError("A field and a query must be provided", 3)
+	}
+	opts := lgrep.StatsOptions{
+		By:     strings.TrimPrefix(c.String("by"), "."),
+		Groups: c.Int("groups"),
+	}
+	opts.Percentiles, err = parsePercents(c.String("percentiles"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 3)
+	}
+
+	if _, err = lgrep.ParseLucene(query); err != nil {
+		return queryError(err)
+	}
+	l, err := newClient(c.GlobalString("endpoint"))
+	if err != nil {
+		return queryError(err)
+	}
+	spec := &lgrep.SearchOptions{
+		Index:      c.String("query-index"),
+		QueryDebug: c.GlobalBool("debug"),
+	}
+	stats, err := l.SimpleStats(query, field, opts, spec)
+	if err != nil {
+		return queryError(err)
+	}
+
+	if c.Bool("json") {
+		for _, s := range stats {
+			data, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "%s\n", data)
+		}
+		return nil
+	}
+	return printStats(os.Stdout, stats, opts)
+}
+
+// printStats writes the statistics as a table with a row for each
+// group.
+func printStats(out io.Writer, stats []lgrep.FieldStats, opts lgrep.StatsOptions) error {
+	columns := []string{"count", "min", "max", "avg", "sum", "stddev"}
+	for _, p := range opts.Percentiles {
+		columns = append(columns, percentColumn(p))
+	}
+	if opts.By != "" {
+		columns = append([]string{opts.By}, columns...)
+	}
+
+	run := Config{
+		formatTemplate: "." + strings.Join(columns, " ."),
+		formatTabulate: true,
+	}
+	formatter, flush, err := run.markedFormatter(out)
+	if err != nil {
+		return err
+	}
+	defer flush()
+
+	for _, s := range stats {
+		row := map[string]interface{}{
+			"count":  strconv.FormatInt(s.Count, 10),
+			"min":    formatStat(s.Min),
+			"max":    formatStat(s.Max),
+			"avg":    formatStat(s.Avg),
+			"sum":    formatStat(s.Sum),
+			"stddev": formatStat(s.StdDeviation),
+		}
+		for _, p := range s.Percentiles {
+			row[percentColumn(p.Percent)] = formatStat(p.Value)
+		}
+		if opts.By != "" {
+			row[opts.By] = s.Group
+		}
+		if err = formatter("", lgrep.NestFields(row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// percentColumn names the column of a percentile (99.9 => p99_9).
+func percentColumn(percent float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(percent, 'f', -1, 64), ".", "_", -1)
+}
+
+// formatStat formats a statistic readably, rounding to three decimal
+// places while keeping the significant digits of small values.
+func formatStat(v float64) string {
+	if v != 0 && math.Abs(v) < 1 {
+		return strconv.FormatFloat(v, 'g', 4, 64)
+	}
+	str := strconv.FormatFloat(v, 'f', 3, 64)
+	return strings.TrimSuffix(strings.TrimRight(str, "0"), ".")
+}
+
+// parsePercents parses a comma separated list of percentiles.
+func parsePercents(s string) (percents []float64, err error) {
+	for _, p := range strings.Split(s, ",") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return percents, errors.Errorf("Percentile '%s' must be a number from 0 to 100", p)
+		}
+		percents = append(percents, percent)
+	}
+	return percents, nil
+}
+
+// formatPercents formats the percentiles as they're given to
+// parsePercents.
+func formatPercents(percents []float64) string {
+	parts := make([]string, len(percents))
+	for i, p := range percents {
+		parts[i] = strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+)
+
+func TestFormatStat(t *testing.T) {
+	examples := map[float64]string{
+		0:          "0",
+		12:         "12",
+		12.5:       "12.5",
+		1234.56789: "1234.568",
+		0.00123456: "0.001235",
+		-3.25:      "-3.25",
+	}
+	for v, expected := range examples {
+		if s := formatStat(v); s != expected {
+			t.Errorf("formatStat(%v) => '%s' (expected '%s')", v, s, expected)
+		}
+	}
+}
+
+func TestParsePercents(t *testing.T) {
+	percents, err := parsePercents("50, 99,99.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(percents) != 3 || percents[2] != 99.9 {
+		t.Errorf("Parsed percentiles %v", percents)
+	}
+	for _, invalid := range []string{"", "50,", "101", "p99"} {
+		if _, err := parsePercents(invalid); err == nil {
+			t.Errorf("Expected an error for '%s'", invalid)
+		}
+	}
+}
+
+func TestPrintStats(t *testing.T) {
+	opts := lgrep.StatsOptions{By: "host.raw", Percentiles: []float64{99.9}}
+	stats := []lgrep.FieldStats{
+		{Group: "web1", Count: 2, Min: 1, Max: 3, Avg: 2, Sum: 4, StdDeviation: 1,
+			Percentiles: []lgrep.Percentile{{Percent: 99.9, Value: 3}}},
+	}
+	var out bytes.Buffer
+	if err := printStats(&out, stats, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := "host.raw  count  min   max   avg   sum   stddev  p99_9\n" +
+		"web1      2      1     3     2     4     1       3\n"
+	if out.String() != expected {
+		t.Errorf("Printed stats:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !solaris
+// +build !solaris
+
+package main
+
+import "golang.org/x/crypto/ssh/terminal"
+
+// isTerminal checks that the file descriptor is a terminal.
+func isTerminal(fd int) bool {
+	return terminal.IsTerminal(fd)
+}
+
+// makeRaw puts the terminal into raw mode so that keys are read as
+// they're pressed without being echoed, the returned function
+// restores it.
+func makeRaw(fd int) (restore func() error, err error) {
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return terminal.Restore(fd, state) }, nil
+}
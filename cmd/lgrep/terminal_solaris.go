@@ -0,0 +1,14 @@
+package main
+
+import "github.com/juju/errors"
+
+// isTerminal is never true, the vendored terminal package can't make
+// terminals raw on solaris so lines are read without editing.
+func isTerminal(fd int) bool {
+	return false
+}
+
+// makeRaw isn't supported on solaris.
+func makeRaw(fd int) (restore func() error, err error) {
+	return nil, errors.New("Raw terminal mode is not supported on solaris")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+const (
+	// validateExitInvalid is the exit code used when a query was
+	// found to be invalid.
+	validateExitInvalid = 1
+	// validateExitError is the exit code used when a query could not
+	// be validated at all.
+	validateExitError = 2
+)
+
+var (
+	// ValidateCommand validates queries with the server without
+	// running them.
+	ValidateCommand = cli.Command{
+		Name:      "validate",
+		Usage:     "Validate a query and explain it for each index searched",
+		ArgsUsage: "[QUERY | @SAVED [QUERY]]",
+		Description: `Exits 0 when all queries are valid, 1 when any query is invalid and 2
+   when a query could not be validated.`,
+		Action: RunValidate,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "query-index, Qi",
+				Usage: "Validate against this index, if not provided - all indicies",
+			},
+			cli.StringFlag{
+				Name:  "query-file, Qf",
+				Usage: "Raw elasticsearch json query to validate",
+			},
+			cli.BoolFlag{
+				Name:  "all-saved",
+				Usage: "Validate each of the saved searches",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Output the validation results as JSON (1 line per query)",
+			},
+		},
+	}
+)
+
+// validation is the outcome of validating a single query.
+type validation struct {
+	Name         string                        `json:"name,omitempty"`
+	Query        string                        `json:"query,omitempty"`
+	QueryFile    string                        `json:"query_file,omitempty"`
+	Index        string                        `json:"index,omitempty"`
+	Valid        bool                          `json:"valid"`
+	Error        string                        `json:"error,omitempty"`
+	Explanations []lgrep.ValidationExplanation `json:"explanations"`
+
+	// err is the error the query was found to have.
+	err error
+	// exitCode is the exit code the outcome warrants.
+	exitCode int
+}
+
+// run validates the query, recording the outcome.
+func (v *validation) run(l lgrep.LGrep) {
+	var (
+		result lgrep.ValidationResponse
+		err    error
+	)
+	spec := &lgrep.SearchOptions{Index: v.Index}
+	v.Explanations = []lgrep.ValidationExplanation{}
+	if v.QueryFile != "" {
+		var data []byte
+		data, err = ioutil.ReadFile(v.QueryFile)
+		if err != nil {
+			v.Error = errors.Annotate(err, "Could not read the provided query file").Error()
+			v.exitCode = validateExitError
+			return
+		}
+		result, err = l.ValidateSource(json.RawMessage(data), spec)
+	} else {
+		result, err = l.Validate(v.Query, spec)
+	}
+	if result.Explanations != nil {
+		v.Explanations = result.Explanations
+	}
+	if err == nil {
+		v.Valid = true
+		return
+	}
+	v.err = err
+	v.Error = err.Error()
+	switch errors.Cause(err) {
+	case lgrep.ErrInvalidQuery, lgrep.ErrInvalidLuceneSyntax, lgrep.ErrInvalidIndex:
+		v.exitCode = validateExitInvalid
+	default:
+		// Errors parsed from the explanations are also invalid queries,
+		// anything else is a failure to validate.
+		if len(result.Explanations) != 0 {
+			v.exitCode = validateExitInvalid
+		} else {
+			v.exitCode = validateExitError
+		}
+	}
+}
+
+// print writes a human readable report of the validation.
+func (v validation) print(out io.Writer) {
+	what := v.Query
+	if v.QueryFile != "" {
+		what = "file:" + v.QueryFile
+	}
+	if v.Name != "" {
+		what = savedPrefix + v.Name + " " + what
+	}
+	if v.Valid {
+		fmt.Fprintf(out, "valid: %s\n", what)
+	} else {
+		message := v.Error
+		if serr, ok := v.err.(*lgrep.LuceneSyntaxError); ok {
+			message = strings.Replace(serr.Explain(), "\n", "\n  ", -1)
+		}
+		fmt.Fprintf(out, "INVALID: %s\n  %s\n", what, message)
+	}
+	for _, exp := range v.Explanations {
+		index := exp.Index
+		if index == "" {
+			index = "_all"
+		}
+		if exp.Valid {
+			fmt.Fprintf(out, "  [%s] %s\n", index, exp.Explanation)
+		} else {
+			fmt.Fprintf(out, "  [%s] INVALID %s\n", index, exp.Message)
+		}
+	}
+}
+
+// validationsFor collects the queries that are to be validated from
+// the arguments and flags.
+func validationsFor(c *cli.Context) (vs []*validation, err error) {
+	args := c.Args()
+	if c.Bool("all-saved") || isSavedRef(args.First()) {
+		cfg, err := loadConfigFile(c.GlobalString("config"))
+		if err != nil {
+			return vs, err
+		}
+		var names, extra []string
+		if c.Bool("all-saved") {
+			for name := range cfg.Saved {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			extra = args
+		} else {
+			names = []string{strings.TrimPrefix(args.First(), savedPrefix)}
+			extra = args.Tail()
+		}
+		for _, name := range names {
+			saved, err := cfg.SavedSearch(name)
+			if err != nil {
+				return vs, err
+			}
+			run := Config{}
+			if err = saved.apply(c, &run, extra); err != nil {
+				return vs, err
+			}
+			vs = append(vs, &validation{
+				Name:      name,
+				Query:     run.query,
+				QueryFile: run.queryFile,
+				Index:     run.queryIndex,
+			})
+		}
+		return vs, nil
+	}
+
+	v := &validation{
+		Query:     strings.Join(args, " "),
+		QueryFile: c.String("query-file"),
+		Index:     c.String("query-index"),
+	}
+	if v.Query != "" && v.QueryFile != "" {
+		return vs, errors.New("You've provided multiple queries (file and lucene perhaps?)")
+	}
+	if v.Query == "" && v.QueryFile == "" {
+		return vs, errors.New("No query provided")
+	}
+	return append(vs, v), nil
+}
+
+// RunValidate validates the queries given and reports on each.
+func RunValidate(c *cli.Context) (err error) {
+	vs, err := validationsFor(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), validateExitError)
+	}
+	run := Config{endpoint: c.GlobalString("endpoint"), file: c.GlobalString("file")}
+	l, err := run.client()
+	if err != nil {
+		return cli.NewExitError(err.Error(), validateExitError)
+	}
+	exitCode, err := reportValidations(os.Stdout, l, vs, c.Bool("json"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), validateExitError)
+	}
+	if exitCode != 0 {
+		return cli.NewExitError("", exitCode)
+	}
+	return nil
+}
+
+// reportValidations validates each of the queries, writing the
+// reports (as JSON when asked) to `out`. The exit code is that of the
+// worst outcome.
+func reportValidations(out io.Writer, l lgrep.LGrep, vs []*validation, asJSON bool) (exitCode int, err error) {
+	for _, v := range vs {
+		v.run(l)
+		if asJSON {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return validateExitError, err
+			}
+			fmt.Fprintf(out, "%s\n", data)
+		} else {
+			v.print(out)
+		}
+		if v.exitCode > exitCode {
+			exitCode = v.exitCode
+		}
+	}
+	return exitCode, nil
+}
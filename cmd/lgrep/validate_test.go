@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+)
+
+func TestReportValidations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestServer(t)
+	defer server.Close()
+	l, err := lgrep.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := filepath.Join(dir, "valid.json")
+	invalid := filepath.Join(dir, "invalid.json")
+	// The size and sort can't be validated, they're left out.
+	ioutil.WriteFile(valid, []byte(`{"size": 10, "sort": ["@timestamp"], "query": {"term": {"host": "web1"}}}`), 0644)
+	ioutil.WriteFile(invalid, []byte(`{"query": {"unknown_query": {}}}`), 0644)
+
+	examples := []struct {
+		v        validation
+		exitCode int
+		report   string
+	}{
+		{validation{Query: "host:web1", Index: "journald-*"}, 0,
+			"valid: host:web1\n  [lgreptest] {\"constant_score\":{\"filter\":{\"query_string\":{\"analyze_wildcard\":true,\"query\":\"host:web1\"}}}}\n"},
+		{validation{QueryFile: valid}, 0,
+			"valid: file:" + valid + "\n  [lgreptest] {\"term\":{\"host\":\"web1\"}}\n"},
+		{validation{Name: "web", Query: "host:(web1"}, validateExitInvalid,
+			"INVALID: @web host:(web1\n  Invalid Lucene syntax at column 6: unbalanced '(' is never closed\n    host:(web1\n         ^\n"},
+		{validation{Query: "host:web1", Index: "missing"}, validateExitInvalid,
+			"INVALID: host:web1\n  Invalid query on unknown index\n"},
+		{validation{QueryFile: invalid}, validateExitInvalid, ""},
+		{validation{QueryFile: filepath.Join(dir, "missing.json")}, validateExitError, ""},
+	}
+	for _, ex := range examples {
+		v := ex.v
+		var out bytes.Buffer
+		if exitCode, err := reportValidations(&out, l, []*validation{&v}, false); err != nil || exitCode != ex.exitCode {
+			t.Errorf("Validated %+v with exit code %d (%v), expected %d", ex.v, exitCode, err, ex.exitCode)
+		}
+		if ex.report != "" && out.String() != ex.report {
+			t.Errorf("Reported:\n%s\nexpected:\n%s", out.String(), ex.report)
+		}
+	}
+
+	// The worst outcome is the exit code, each query is a line of
+	// JSON.
+	vs := []*validation{{Query: "host:web1", Index: "journald-*"}, {Query: "host:(web1"}, {QueryFile: invalid}}
+	var out bytes.Buffer
+	if exitCode, err := reportValidations(&out, l, vs, true); err != nil || exitCode != validateExitInvalid {
+		t.Errorf("Validated with exit code %d (%v), expected %d", exitCode, err, validateExitInvalid)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(vs) {
+		t.Fatalf("Expected a line per query, got:\n%s", out.String())
+	}
+	for i, line := range lines {
+		var reported struct {
+			Valid        bool                          `json:"valid"`
+			Error        string                        `json:"error"`
+			Explanations []lgrep.ValidationExplanation `json:"explanations"`
+		}
+		if err := json.Unmarshal([]byte(line), &reported); err != nil {
+			t.Fatal(err)
+		}
+		if reported.Valid != (i == 0) || (reported.Error == "") != (i == 0) || reported.Explanations == nil {
+			t.Errorf("Reported %s", line)
+		}
+	}
+	if !strings.Contains(lines[2], `"valid":false,"error":"`) || !strings.Contains(lines[2], `"index":"lgreptest","valid":false`) {
+		t.Errorf("Expected the explanation of the invalid query file, got %s", lines[2])
+	}
+
+	// A server that can't be reached can't validate the query.
+	server.Close()
+	if exitCode, _ := reportValidations(ioutil.Discard, l, []*validation{{Query: "host:web1"}}, false); exitCode != validateExitError {
+		t.Errorf("Validated without a server with exit code %d, expected %d", exitCode, validateExitError)
+	}
+}
+
+func TestValidationsFor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lgrep.json")
+	for _, args := range [][]string{
+		{"saved", "add", "-Qi", "edge-*", "@edge-5xx", "status:>=500"},
+		{"saved", "add", "@slow", "took:>1000"},
+	} {
+		if err = App().Run(append([]string{"lgrep", "--config", path}, args...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	validateContext := func(args ...string) *cli.Context {
+		global := flag.NewFlagSet("lgrep", flag.ContinueOnError)
+		global.String("config", path, "")
+		set := flag.NewFlagSet("validate", flag.ContinueOnError)
+		for _, f := range ValidateCommand.Flags {
+			f.Apply(set)
+		}
+		if err := set.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return cli.NewContext(App(), set, cli.NewContext(App(), global, nil))
+	}
+
+	vs, err := validationsFor(validateContext("--query-index", "logs-*", "host:web1", "AND", "status:500"))
+	if err != nil || len(vs) != 1 || vs[0].Query != "host:web1 AND status:500" || vs[0].Index != "logs-*" {
+		t.Errorf("Validating %+v (%v)", vs, err)
+	}
+	vs, err = validationsFor(validateContext("@edge-5xx", "host:web1"))
+	if err != nil || len(vs) != 1 || vs[0].Name != "edge-5xx" || vs[0].Query != "(status:>=500) AND (host:web1)" || vs[0].Index != "edge-*" {
+		t.Errorf("Validating the saved search %+v (%v)", vs, err)
+	}
+	vs, err = validationsFor(validateContext("--all-saved"))
+	if err != nil || len(vs) != 2 || vs[0].Name != "edge-5xx" || vs[1].Name != "slow" {
+		t.Errorf("Validating all of the saved searches %+v (%v)", vs, err)
+	}
+	if _, err = validationsFor(validateContext()); err == nil {
+		t.Error("Expected an error without a query")
+	}
+	if _, err = validationsFor(validateContext("--query-file", "query.json", "host:web1")); err == nil {
+		t.Error("Expected an error with both a query file and a query")
+	}
+}
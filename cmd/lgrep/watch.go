@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+)
+
+const (
+	// watchAlert is the state of a rule whose threshold is exceeded.
+	watchAlert = "alert"
+	// watchRecovered is the state of a rule that was alerting and no
+	// longer exceeds its threshold.
+	watchRecovered = "recovered"
+
+	defaultWatchInterval  = time.Minute
+	defaultWatchThreshold = "> 0"
+	defaultWatchMatches   = 10
+	webhookTimeout        = 10 * time.Second
+)
+
+var (
+	// WatchCommand runs queries on a schedule, alerting when their
+	// counts cross a threshold.
+	WatchCommand = cli.Command{
+		Name:      "watch",
+		Usage:     "Run the queries of a rules file on a schedule, alerting on thresholds",
+		ArgsUsage: "RULES_FILE",
+		Description: `The rules file is JSON, ex:
+
+   {"rules": [{
+     "name": "api-errors",
+     "query": "service:api AND status:500",
+     "index": "logstash-*",
+     "interval": "1m",
+     "window": "5m",
+     "threshold": "> 10",
+     "format": ".host .message",
+     "action": {
+       "command": "mail -s 'api errors' ops@example.com",
+       "webhook": "http://alerts.example.com/hook",
+       "file": "/var/log/lgrep-alerts.ndjson"
+     }
+   }]}
+
+   A rule alerts when the number of documents matching its query in the
+   last window (ending now) satisfies the threshold (>, >=, <, <=, ==, !=)
+   and recovers when it no longer does. The actions are run only for these
+   transitions: commands are given the matches on stdin, webhooks are sent
+   the alert as JSON and files have the alert appended as a JSON line.`,
+		Action: RunWatch,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "state",
+				Usage: "File to keep the alerting state of the rules in between runs",
+			},
+			cli.BoolFlag{
+				Name:  "once",
+				Usage: "Check each rule once and exit, as when run from cron",
+			},
+		},
+	}
+)
+
+// watchDuration is a duration given as a string (ex: "5m").
+type watchDuration time.Duration
+
+// UnmarshalJSON parses the duration.
+func (d *watchDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Annotate(err, "Durations must be strings (ex: \"5m\")")
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = watchDuration(duration)
+	return nil
+}
+
+// watchRules is the rules file.
+type watchRules struct {
+	Rules []*watchRule `json:"rules"`
+}
+
+// watchRule is a query that's checked on a schedule.
+type watchRule struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	Index string `json:"index"`
+	// Interval is how often the rule is checked.
+	Interval watchDuration `json:"interval"`
+	// Window is how far back from now documents are counted,
+	// defaulting to the interval.
+	Window watchDuration `json:"window"`
+	// TimeField is the field that the window applies to.
+	TimeField string `json:"time_field"`
+	// Threshold is compared with the count (ex: "> 10").
+	Threshold string `json:"threshold"`
+	// Format is the template the matches are formatted with for the
+	// actions, they're given as JSON when not set.
+	Format string `json:"format"`
+	// Matches is the number of matches given to the actions.
+	Matches int `json:"matches"`
+	// Action is what's done when the rule alerts or recovers.
+	Action watchAction `json:"action"`
+
+	threshold watchThreshold
+}
+
+// watchAction is what's done when a rule alerts or recovers, any of
+// them may be given.
+type watchAction struct {
+	// Command is run with sh, given the matches on stdin.
+	Command string `json:"command"`
+	// Webhook is sent the event as JSON.
+	Webhook string `json:"webhook"`
+	// File has the event appended to it as a line of JSON.
+	File string `json:"file"`
+}
+
+// watchThreshold is a comparison with the count of a rule's matches.
+type watchThreshold struct {
+	op    string
+	value int64
+}
+
+// parseThreshold parses a comparison, such as "> 10" or "<=0".
+func parseThreshold(s string) (t watchThreshold, err error) {
+	s = strings.TrimSpace(s)
+	for _, op := range []string{">=", "<=", "==", "!=", ">", "<"} {
+		if strings.HasPrefix(s, op) {
+			t.op = op
+			t.value, err = strconv.ParseInt(strings.TrimSpace(s[len(op):]), 10, 64)
+			if err != nil {
+				return t, errors.Errorf("Threshold '%s' must compare with a whole number", s)
+			}
+			return t, nil
+		}
+	}
+	return t, errors.Errorf("Threshold '%s' must start with one of >, >=, <, <=, == or !=", s)
+}
+
+// Exceeded checks the count against the threshold.
+func (t watchThreshold) Exceeded(count int64) bool {
+	switch t.op {
+	case ">":
+		return count > t.value
+	case ">=":
+		return count >= t.value
+	case "<":
+		return count < t.value
+	case "<=":
+		return count <= t.value
+	case "==":
+		return count == t.value
+	case "!=":
+		return count != t.value
+	}
+	return false
+}
+
+// loadWatchRules reads and checks the rules file, filling in the
+// defaults of each rule.
+func loadWatchRules(path string) (rules []*watchRule, err error) {
+	data, err := ioutil.ReadFile(expandPath(path))
+	if err != nil {
+		return rules, errors.Annotate(err, "Could not read the rules file")
+	}
+	var file watchRules
+	if err = json.Unmarshal(data, &file); err != nil {
+		return rules, errors.Annotate(err, "Could not parse the rules file")
+	}
+	if len(file.Rules) == 0 {
+		return rules, errors.New("The rules file has no rules")
+	}
+	names := make(map[string]bool)
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			return rules, errors.Errorf("Rule %d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return rules, errors.Errorf("Rule '%s' is given more than once", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Query == "" {
+			return rules, errors.Errorf("Rule '%s' has no query", rule.Name)
+		}
+		if _, err = lgrep.ParseLucene(rule.Query); err != nil {
+			return rules, errors.Annotatef(err, "Rule '%s' has an invalid query", rule.Name)
+		}
+		if rule.Action == (watchAction{}) {
+			return rules, errors.Errorf("Rule '%s' has no action", rule.Name)
+		}
+		if rule.Interval <= 0 {
+			rule.Interval = watchDuration(defaultWatchInterval)
+		}
+		if rule.Window <= 0 {
+			rule.Window = rule.Interval
+		}
+		if rule.TimeField == "" {
+			rule.TimeField = "@timestamp"
+		}
+		if rule.Threshold == "" {
+			rule.Threshold = defaultWatchThreshold
+		}
+		if rule.Matches == 0 {
+			rule.Matches = defaultWatchMatches
+		}
+		if rule.threshold, err = parseThreshold(rule.Threshold); err != nil {
+			return rules, errors.Annotatef(err, "Rule '%s'", rule.Name)
+		}
+	}
+	return file.Rules, nil
+}
+
+// lucene is the rule's query limited to its window.
+func (r *watchRule) lucene() string {
+	return windowQuery(r.Query, r.TimeField, time.Duration(r.Window))
+}
+
+// windowQuery limits the lucene query to the documents from the last
+// window of time.
+func windowQuery(q string, timeField string, window time.Duration) string {
+	return windowQueryAgo(q, timeField, window, 0)
+}
+
+// windowQueryAgo limits the lucene query to the documents from the
+// window of time that ended the given time ago.
+func windowQueryAgo(q string, timeField string, window, ago time.Duration) string {
+	to := "now"
+	if ago > 0 {
+		to = fmt.Sprintf("now-%ds", int64(ago/time.Second))
+	}
+	from := fmt.Sprintf("now-%ds", int64((window+ago)/time.Second))
+	return fmt.Sprintf("(%s) AND %s:[%s TO %s]", q, lgrep.EscapeLucene(timeField), from, to)
+}
+
+// watchEvent is an alert or recovery of a rule, as it's given to the
+// actions.
+type watchEvent struct {
+	Rule      string    `json:"rule"`
+	State     string    `json:"state"`
+	Count     int64     `json:"count"`
+	Threshold string    `json:"threshold"`
+	Query     string    `json:"query"`
+	Index     string    `json:"index,omitempty"`
+	Time      time.Time `json:"time"`
+	// Matches are the formatted matches of an alert, or their JSON
+	// when not formatted.
+	Matches []string `json:"matches,omitempty"`
+}
+
+// watchState is the alerting state of a rule.
+type watchState struct {
+	Alerting bool      `json:"alerting"`
+	Since    time.Time `json:"since"`
+}
+
+// watchSearcher runs the rules' queries, this is satisfied by
+// lgrep.LGrep.
+type watchSearcher interface {
+	SimpleCount(q string, spec *lgrep.SearchOptions) (int64, error)
+	SimpleSearch(q string, spec *lgrep.SearchOptions) ([]lgrep.Result, error)
+}
+
+// watcher checks rules, keeping their state to act only when they
+// alert or recover.
+type watcher struct {
+	searcher  watchSearcher
+	stateFile string
+	client    *http.Client
+
+	mu    sync.Mutex
+	state map[string]*watchState
+}
+
+// newWatcher creates a watcher, loading the state from the file when
+// given.
+func newWatcher(searcher watchSearcher, stateFile string) (w *watcher, err error) {
+	w = &watcher{
+		searcher:  searcher,
+		stateFile: expandPath(stateFile),
+		client:    &http.Client{Timeout: webhookTimeout},
+		state:     make(map[string]*watchState),
+	}
+	if stateFile == "" {
+		return w, nil
+	}
+	data, err := ioutil.ReadFile(w.stateFile)
+	if os.IsNotExist(err) {
+		return w, nil
+	}
+	if err != nil {
+		return w, errors.Annotate(err, "Could not read the state file")
+	}
+	if err = json.Unmarshal(data, &w.state); err != nil {
+		return w, errors.Annotate(err, "Could not parse the state file")
+	}
+	return w, nil
+}
+
+// Check counts the rule's matches, running its actions when it alerts
+// or recovers.
+func (w *watcher) Check(rule *watchRule) (err error) {
+	spec := &lgrep.SearchOptions{Index: rule.Index, Size: rule.Matches, SortTime: lgrep.SortDesc}
+	count, err := w.searcher.SimpleCount(rule.lucene(), spec)
+	if err != nil {
+		return errors.Annotatef(err, "Could not check rule '%s'", rule.Name)
+	}
+	alerting := rule.threshold.Exceeded(count)
+	log.Debugf("Rule '%s' counted %d (alerting: %t)", rule.Name, count, alerting)
+
+	w.mu.Lock()
+	state, ok := w.state[rule.Name]
+	if !ok {
+		state = &watchState{}
+		w.state[rule.Name] = state
+	}
+	changed := state.Alerting != alerting
+	w.mu.Unlock()
+	if !changed {
+		return nil
+	}
+
+	event := watchEvent{
+		Rule:      rule.Name,
+		State:     watchRecovered,
+		Count:     count,
+		Threshold: rule.Threshold,
+		Query:     rule.Query,
+		Index:     rule.Index,
+		Time:      time.Now().UTC(),
+	}
+	if alerting {
+		event.State = watchAlert
+		if event.Matches, err = w.matches(rule, spec); err != nil {
+			log.Warn(errors.Annotatef(err, "Could not retrieve the matches of rule '%s'", rule.Name))
+		}
+	}
+	log.Infof("Rule '%s' is now %s with %d matches (%s)", rule.Name, event.State, count, rule.Threshold)
+	if err = w.act(rule.Action, event); err != nil {
+		// The transition is retried on the next check.
+		return errors.Annotatef(err, "Could not act on rule '%s'", rule.Name)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state.Alerting, state.Since = alerting, event.Time
+	return w.saveState()
+}
+
+// matches retrieves and formats the rule's latest matches.
+func (w *watcher) matches(rule *watchRule, spec *lgrep.SearchOptions) (matches []string, err error) {
+	format := lgrep.FormatRaw
+	if rule.Format != "" {
+		format = rule.Format
+		if !lgrep.IsRawFormat(format) {
+			spec.Fields = append(lgrep.FieldTokens(format), "@timestamp", "date")
+		}
+	}
+	formatter, err := lgrep.Formatter(format)
+	if err != nil {
+		return matches, err
+	}
+	results, err := w.searcher.SimpleSearch(rule.lucene(), spec)
+	if err != nil {
+		return matches, err
+	}
+	for _, r := range results {
+		m, err := formatter(r)
+		if err != nil {
+			log.Warn(errors.Annotate(err, "error formatting result"))
+			continue
+		}
+		matches = append(matches, string(m))
+	}
+	return matches, nil
+}
+
+// act runs each of the action's parts with the event, all of them are
+// run even when one fails.
+func (w *watcher) act(action watchAction, event watchEvent) (err error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	fail := func(err error) {
+		log.Error(err)
+		failed = append(failed, err.Error())
+	}
+
+	if action.Command != "" {
+		cmd := exec.Command("sh", "-c", action.Command)
+		cmd.Stdin = strings.NewReader(strings.Join(event.Matches, "\n"))
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		cmd.Env = append(os.Environ(),
+			"LGREP_RULE="+event.Rule,
+			"LGREP_STATE="+event.State,
+			"LGREP_COUNT="+strconv.FormatInt(event.Count, 10),
+		)
+		if err := cmd.Run(); err != nil {
+			fail(errors.Annotatef(err, "Command '%s' failed", action.Command))
+		}
+	}
+	if action.Webhook != "" {
+		resp, err := w.client.Post(action.Webhook, "application/json", bytes.NewReader(data))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = errors.Errorf("responded %s", resp.Status)
+			}
+		}
+		if err != nil {
+			fail(errors.Annotatef(err, "Webhook '%s' failed", action.Webhook))
+		}
+	}
+	if action.File != "" {
+		f, err := os.OpenFile(expandPath(action.File), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%s\n", data)
+			f.Close()
+		}
+		if err != nil {
+			fail(errors.Annotatef(err, "Writing to '%s' failed", action.File))
+		}
+	}
+
+	if len(failed) != 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// saveState writes the state to the state file, if there is one. The
+// lock must be held.
+func (w *watcher) saveState() error {
+	if w.stateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(w.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(w.stateFile, data, 0600); err != nil {
+		return errors.Annotate(err, "Could not save the state file")
+	}
+	return nil
+}
+
+// Watch checks each rule on its interval until quit is closed.
+func (w *watcher) Watch(rules []*watchRule, quit <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, rule := range rules {
+		wg.Add(1)
+		go func(rule *watchRule) {
+			defer wg.Done()
+			ticker := time.NewTicker(time.Duration(rule.Interval))
+			defer ticker.Stop()
+			for {
+				if err := w.Check(rule); err != nil {
+					log.Error(err)
+				}
+				select {
+				case <-quit:
+					return
+				case <-ticker.C:
+				}
+			}
+		}(rule)
+	}
+	wg.Wait()
+}
+
+// RunWatch checks the rules of the rules file until interrupted.
+func RunWatch(c *cli.Context) (err error) {
+	if c.Args().First() == "" {
+		return cli.NewExitError("A rules file must be provided", 3)
+	}
+	rules, err := loadWatchRules(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 3)
+	}
+	l, err := newClient(c.GlobalString("endpoint"))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	w, err := newWatcher(l, c.String("state"))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if c.Bool("once") {
+		failed := false
+		for _, rule := range rules {
+			if err = w.Check(rule); err != nil {
+				log.Error(err)
+				failed = true
+			}
+		}
+		if failed {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	}
+
+	quit := make(chan struct{})
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		log.Info("Stopping the watch")
+		close(quit)
+	}()
+	log.Infof("Watching %d rules", len(rules))
+	w.Watch(rules, quit)
+	return nil
+}
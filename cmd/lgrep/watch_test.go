@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cogolabs/lgrep"
+)
+
+// fakeWatchSearcher counts and finds whatever it's told to.
+type fakeWatchSearcher struct {
+	count   int64
+	results []lgrep.Result
+	queries []string
+}
+
+func (f *fakeWatchSearcher) SimpleCount(q string, spec *lgrep.SearchOptions) (int64, error) {
+	f.queries = append(f.queries, q)
+	return f.count, nil
+}
+
+func (f *fakeWatchSearcher) SimpleSearch(q string, spec *lgrep.SearchOptions) ([]lgrep.Result, error) {
+	return f.results, nil
+}
+
+func TestParseThreshold(t *testing.T) {
+	examples := map[string][]bool{
+		// Exceeded by 5, 10 and 15.
+		"> 10": {false, false, true},
+		">=10": {false, true, true},
+		"< 10": {true, false, false},
+		"<=10": {true, true, false},
+		"==10": {false, true, false},
+		"!=10": {true, false, true},
+	}
+	for s, expected := range examples {
+		threshold, err := parseThreshold(s)
+		if err != nil {
+			t.Errorf("Threshold '%s': %s", s, err)
+			continue
+		}
+		for i, count := range []int64{5, 10, 15} {
+			if threshold.Exceeded(count) != expected[i] {
+				t.Errorf("Threshold '%s' exceeded by %d: %t", s, count, !expected[i])
+			}
+		}
+	}
+	for _, invalid := range []string{"10", "> ten", "=> 10"} {
+		if _, err := parseThreshold(invalid); err == nil {
+			t.Errorf("Expected an error for threshold '%s'", invalid)
+		}
+	}
+}
+
+func TestLoadWatchRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.json")
+
+	examples := map[string]string{
+		`{"rules": [{"name": "a", "query": "x", "action": {"file": "f"}}, {"name": "a", "query": "y", "action": {"file": "f"}}]}`: "more than once",
+		`{"rules": [{"name": "a", "action": {"file": "f"}}]}`:                                                                     "no query",
+		`{"rules": [{"name": "a", "query": "x"}]}`:                                                                                "no action",
+		`{"rules": [{"name": "a", "query": "x", "interval": 60, "action": {"file": "f"}}]}`:                                       "must be strings",
+		`{"rules": [{"name": "a", "query": "x", "threshold": "lots", "action": {"file": "f"}}]}`:                                  "Threshold",
+		`{"rules": [{"name": "a", "query": "status:(500", "action": {"file": "f"}}]}`:                                             "invalid query",
+		`{"rules": []}`: "no rules",
+	}
+	for rules, expected := range examples {
+		ioutil.WriteFile(path, []byte(rules), 0600)
+		if _, err := loadWatchRules(path); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error with '%s' for %s, got %v", expected, rules, err)
+		}
+	}
+
+	ioutil.WriteFile(path, []byte(`{"rules": [{"name": "a", "query": "x", "interval": "5m", "action": {"file": "f"}}]}`), 0600)
+	rules, err := loadWatchRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := rules[0]
+	if time.Duration(rule.Window) != 5*time.Minute || rule.Threshold != "> 0" || rule.TimeField != "@timestamp" {
+		t.Errorf("Rule defaults weren't applied: %+v", rule)
+	}
+	if q := rule.lucene(); q != "(x) AND @timestamp:[now-300s TO now]" {
+		t.Errorf("Rule query '%s'", q)
+	}
+}
+
+func TestWatcherTransitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgrep-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		mu     sync.Mutex
+		hooked []watchEvent
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event watchEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		hooked = append(hooked, event)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	threshold, _ := parseThreshold("> 10")
+	stdin := filepath.Join(dir, "stdin")
+	rule := &watchRule{
+		Name:      "errors",
+		Query:     "status:500",
+		Threshold: "> 10",
+		Window:    watchDuration(time.Minute),
+		TimeField: "@timestamp",
+		Format:    ".host",
+		Matches:   2,
+		Action: watchAction{
+			Command: "cat > " + stdin,
+			Webhook: hook.URL,
+			File:    filepath.Join(dir, "alerts.ndjson"),
+		},
+		threshold: threshold,
+	}
+	searcher := &fakeWatchSearcher{results: []lgrep.Result{
+		lgrep.SourceResult(`{"host": "web1"}`),
+		lgrep.SourceResult(`{"host": "web2"}`),
+	}}
+	stateFile := filepath.Join(dir, "state.json")
+	w, err := newWatcher(searcher, stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Below the threshold, above it twice and back below.
+	for _, count := range []int64{3, 20, 25, 3, 1} {
+		searcher.count = count
+		if err = w.Check(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(hooked) != 2 || hooked[0].State != watchAlert || hooked[1].State != watchRecovered {
+		t.Fatalf("Expected an alert and a recovery, got %+v", hooked)
+	}
+	if hooked[0].Count != 20 || strings.Join(hooked[0].Matches, ",") != "web1,web2" {
+		t.Errorf("Alert %+v", hooked[0])
+	}
+	if len(hooked[1].Matches) != 0 {
+		t.Errorf("Recovery has matches %+v", hooked[1])
+	}
+	written, _ := ioutil.ReadFile(rule.Action.File)
+	if lines := strings.Split(strings.TrimSpace(string(written)), "\n"); len(lines) != 2 {
+		t.Errorf("Expected 2 events written to the file, got %q", written)
+	}
+	// The command was last run for the recovery, without matches.
+	if data, err := ioutil.ReadFile(stdin); err != nil || len(data) != 0 {
+		t.Errorf("Command was given %q (%v)", data, err)
+	}
+
+	// The state is restored, an ongoing alert isn't repeated.
+	searcher.count = 20
+	w.Check(rule)
+	w, err = newWatcher(searcher, stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Check(rule)
+	if len(hooked) != 3 {
+		t.Errorf("Expected the alert not to be repeated after a restart, got %+v", hooked)
+	}
+}
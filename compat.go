@@ -0,0 +1,323 @@
+package lgrep
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+)
+
+const (
+	// DistributionElasticsearch is the distribution of Elasticsearch
+	// servers.
+	DistributionElasticsearch = "elasticsearch"
+	// DistributionOpenSearch is the distribution of OpenSearch
+	// servers, which speak the API of Elasticsearch 7.
+	DistributionOpenSearch = "opensearch"
+
+	// openSearchAPIVersion is the Elasticsearch major version whose
+	// API OpenSearch speaks.
+	openSearchAPIVersion = 7
+	// versionTimeout is how long the server has to respond with its
+	// version.
+	versionTimeout = 10 * time.Second
+)
+
+// typedEndpoints are the endpoints whose paths may name the types of
+// the documents, which servers without types reject.
+var typedEndpoints = map[string]bool{
+	"_search":   true,
+	"_count":    true,
+	"_validate": true,
+}
+
+// ServerVersion is the version of the server that's searched.
+type ServerVersion struct {
+	// Number is the version number (ex: 7.17.9).
+	Number string
+	// Distribution is elasticsearch or opensearch.
+	Distribution string
+	// Major is the major version of the distribution.
+	Major int
+}
+
+// APIVersion returns the major version of the Elasticsearch API that
+// the server speaks.
+func (v ServerVersion) APIVersion() int {
+	if v.Distribution == DistributionOpenSearch {
+		return openSearchAPIVersion
+	}
+	return v.Major
+}
+
+// String formats the version for the user.
+func (v ServerVersion) String() string {
+	return v.Distribution + " " + v.Number
+}
+
+// DetectVersion asks the server at the endpoint for its version.
+func DetectVersion(endpoint string, client *http.Client) (version ServerVersion, err error) {
+	res, err := client.Get(strings.TrimRight(endpoint, "/") + "/")
+	if err != nil {
+		return version, errors.Annotate(err, "Could not detect the version of the server")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return version, errors.Errorf("Could not detect the version of the server, it responded with %s", res.Status)
+	}
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return version, errors.Annotate(err, "Could not parse the version of the server")
+	}
+	return ParseServerVersion(info.Version.Number, info.Version.Distribution)
+}
+
+// ParseServerVersion parses the version number of the distribution,
+// elasticsearch is assumed when the distribution isn't given.
+func ParseServerVersion(number string, distribution string) (version ServerVersion, err error) {
+	if distribution == "" {
+		distribution = DistributionElasticsearch
+	}
+	version = ServerVersion{Number: number, Distribution: distribution}
+	major := strings.SplitN(number, ".", 2)[0]
+	version.Major, err = strconv.Atoi(major)
+	if err != nil || version.Major < 1 {
+		return version, errors.Errorf("Server version '%s' isn't a version number", number)
+	}
+	return version, nil
+}
+
+// compatTransport adapts the requests made for the Elasticsearch 2.x
+// API, and the responses to them, to the API the server speaks:
+//
+//   - Request bodies are sent as JSON, ids of scrolls to clear included
+//     (5.x and later require it).
+//   - filtered queries are rewritten as bool queries (removed in 5.x).
+//   - Types are removed from the paths of searches, counts, validations
+//     and mappings (removed in 7.x).
+//   - hits.total is given as a number rather than an object (7.x).
+type compatTransport struct {
+	version ServerVersion
+	next    http.RoundTripper
+}
+
+// NewCompatTransport creates a transport that makes the requests
+// compatible with the server's version, the requests are sent with
+// next (http.DefaultTransport when nil).
+func NewCompatTransport(version ServerVersion, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &compatTransport{version: version, next: next}
+}
+
+// RoundTrip sends the request made compatible and makes the response
+// compatible.
+func (t *compatTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	api := t.version.APIVersion()
+	if api < 5 {
+		return t.next.RoundTrip(req)
+	}
+
+	// The request is copied rather than modified, as a RoundTripper
+	// mustn't change the request it's given.
+	compat := new(http.Request)
+	*compat = *req
+	u := *req.URL
+	compat.URL = &u
+	compat.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		compat.Header[k] = append([]string(nil), v...)
+	}
+
+	if api >= 7 {
+		if path := typelessPath(u.Path); path != u.Path {
+			log.Debugf("Removing the types from the path '%s'", u.Path)
+			u.Path, u.RawPath = path, ""
+		}
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if body, err = compatBody(req.Method, u.Path, body); err != nil {
+			return nil, err
+		}
+		compat.Body = ioutil.NopCloser(bytes.NewReader(body))
+		compat.ContentLength = int64(len(body))
+		if len(body) != 0 {
+			compat.Header.Set("Content-Type", "application/json")
+		}
+	}
+
+	res, err = t.next.RoundTrip(compat)
+	if err != nil || api < 7 || !isSearchPath(u.Path) || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	return compatSearchResponse(res)
+}
+
+// compatBody makes the body of a request to the path JSON, rewriting
+// any filtered queries.
+func compatBody(method string, path string, body []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return body, nil
+	}
+	// Scrolls to clear were given as a comma separated list of ids.
+	if method == "DELETE" && strings.HasPrefix(path, "/_search/scroll") && trimmed[0] != '{' {
+		ids := strings.Split(string(trimmed), ",")
+		return json.Marshal(map[string]interface{}{"scroll_id": ids})
+	}
+	if !isQueryPath(path) || !bytes.Contains(body, []byte(`"filtered"`)) {
+		return body, nil
+	}
+
+	var query interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&query); err != nil {
+		// Bodies that aren't JSON are the server's to reject.
+		return body, nil
+	}
+	query, changed := rewriteFiltered(query)
+	if !changed {
+		return body, nil
+	}
+	log.Debug("Rewriting the filtered queries as bool queries")
+	return json.Marshal(query)
+}
+
+// rewriteFiltered replaces the filtered queries within the query with
+// the equivalent bool queries, reporting whether there were any.
+func rewriteFiltered(query interface{}) (rewritten interface{}, changed bool) {
+	switch v := query.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			value, c := rewriteFiltered(value)
+			changed = changed || c
+			if filtered, ok := value.(map[string]interface{}); ok && key == "filtered" && isFilteredQuery(filtered) {
+				clauses := make(map[string]interface{}, len(filtered))
+				for k, clause := range filtered {
+					switch k {
+					case "query":
+						clauses["must"] = clause
+					default:
+						clauses[k] = clause
+					}
+				}
+				key, value, changed = "bool", clauses, true
+			}
+			out[key] = value
+		}
+		return out, changed
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			var c bool
+			out[i], c = rewriteFiltered(value)
+			changed = changed || c
+		}
+		return out, changed
+	}
+	return query, false
+}
+
+// isFilteredQuery checks that the object is the body of a filtered
+// query rather than, say, a field named filtered.
+func isFilteredQuery(body map[string]interface{}) bool {
+	clauses := 0
+	for k := range body {
+		switch k {
+		case "query", "filter":
+			clauses++
+		case "_name", "boost":
+		default:
+			return false
+		}
+	}
+	return clauses != 0
+}
+
+// typelessPath removes the types from the path of a search, count,
+// validation (/{index}/{type}/_search) or mapping
+// (/{index}/_mapping/{type}).
+func typelessPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) >= 3 && !strings.HasPrefix(parts[1], "_") && typedEndpoints[parts[2]]:
+		parts = append(parts[:1], parts[2:]...)
+	case len(parts) == 3 && parts[1] == "_mapping":
+		parts = parts[:2]
+	default:
+		return path
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// isQueryPath checks if requests to the path have query bodies.
+func isQueryPath(path string) bool {
+	for endpoint := range typedEndpoints {
+		if strings.Contains(path, "/"+endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSearchPath checks if the path is of a search or scroll.
+func isSearchPath(path string) bool {
+	return strings.HasSuffix(path, "/_search") || strings.HasPrefix(path, "/_search/scroll")
+}
+
+// compatSearchResponse replaces the hits.total object of a search
+// response with its value.
+func compatSearchResponse(res *http.Response) (*http.Response, error) {
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return res, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var result map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err = d.Decode(&result); err != nil {
+		// Responses that aren't JSON are left for the client to fail
+		// on.
+		return res, nil
+	}
+	hits, _ := result["hits"].(map[string]interface{})
+	total, ok := hits["total"].(map[string]interface{})
+	if !ok {
+		return res, nil
+	}
+	hits["total"] = total["value"]
+	if body, err = json.Marshal(result); err != nil {
+		return res, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return res, nil
+}
+
+// versionClient is the client that asks for the server's version.
+func versionClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: transport, Timeout: versionTimeout}
+}
@@ -0,0 +1,262 @@
+package lgrep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testVersionsPath = "./test/versions"
+
+// versionFixture holds the responses of a version of the server.
+type versionFixture struct {
+	Root     map[string]interface{} `json:"root"`
+	Search   map[string]interface{} `json:"search"`
+	Count    map[string]interface{} `json:"count"`
+	Validate map[string]interface{} `json:"validate"`
+	Mapping  map[string]interface{} `json:"mapping"`
+}
+
+// versionServer answers with the fixture's responses, rejecting the
+// requests that its version would.
+type versionServer struct {
+	*httptest.Server
+	fixture versionFixture
+	version ServerVersion
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newVersionServer(t *testing.T, path string) *versionServer {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &versionServer{}
+	if err = json.Unmarshal(data, &s.fixture); err != nil {
+		t.Fatalf("Could not parse %s: %s", path, err)
+	}
+	version, _ := s.fixture.Root["version"].(map[string]interface{})
+	number, _ := version["number"].(string)
+	distribution, _ := version["distribution"].(string)
+	if s.version, err = ParseServerVersion(number, distribution); err != nil {
+		t.Fatal(err)
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *versionServer) fail(w http.ResponseWriter, status int, reason string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  map[string]interface{}{"type": "illegal_argument_exception", "reason": reason},
+		"status": status,
+	})
+}
+
+func (s *versionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	api := s.version.APIVersion()
+	s.mu.Lock()
+	s.requests = append(s.requests, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, body))
+	s.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case api >= 6 && len(body) != 0 && r.Header.Get("Content-Type") != "application/json":
+		s.fail(w, http.StatusNotAcceptable, fmt.Sprintf("Content-Type header [%s] is not supported", r.Header.Get("Content-Type")))
+		return
+	case api >= 5 && strings.Contains(string(body), `"filtered"`):
+		s.fail(w, http.StatusBadRequest, "no [query] registered for [filtered]")
+		return
+	// 7.x only deprecates the types, the deprecations are treated as
+	// failures.
+	case api >= 7 && len(parts) >= 3 && !strings.HasPrefix(parts[1], "_") && strings.HasPrefix(parts[2], "_"),
+		api >= 7 && len(parts) == 3 && parts[1] == "_mapping":
+		s.fail(w, http.StatusBadRequest, "types are not supported in "+r.URL.Path)
+		return
+	case len(body) != 0 && r.Header.Get("Content-Type") == "application/json" && json.Unmarshal(body, new(interface{})) != nil:
+		s.fail(w, http.StatusBadRequest, "request body is not JSON")
+		return
+	}
+
+	var response interface{}
+	switch {
+	case r.URL.Path == "/":
+		if r.Method == "HEAD" {
+			return
+		}
+		response = s.fixture.Root
+	case r.URL.Path == "/_nodes/http":
+		if api >= 5 {
+			s.fail(w, http.StatusInternalServerError, "5.x nodes can't be sniffed by the client")
+			return
+		}
+		response = map[string]interface{}{"nodes": map[string]interface{}{
+			"node1": map[string]interface{}{"http_address": strings.TrimPrefix(s.URL, "http://")},
+		}}
+	case strings.HasPrefix(r.URL.Path, "/_search/scroll") && r.Method == "DELETE":
+		response = map[string]interface{}{"succeeded": true, "num_freed": 1}
+	case strings.HasPrefix(r.URL.Path, "/_search/scroll"):
+		// The scroll has no more pages.
+		page := s.searchResponse()
+		page["hits"].(map[string]interface{})["hits"] = []interface{}{}
+		response = page
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		page := s.searchResponse()
+		if r.URL.Query().Get("scroll") != "" {
+			page["_scroll_id"] = "DXF1ZXJ5QW5kRmV0Y2gBAAAAAAAAAD4WYm9laVYtZndUQlNsdDcwakFMNjU1QQ=="
+		}
+		response = page
+	case strings.HasSuffix(r.URL.Path, "/_count"):
+		response = s.fixture.Count
+	case strings.HasSuffix(r.URL.Path, "/_validate/query"):
+		response = s.fixture.Validate
+	case strings.Contains(r.URL.Path, "/_mapping"):
+		response = s.fixture.Mapping
+	default:
+		s.fail(w, http.StatusNotFound, "no handler for "+r.URL.Path)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// searchResponse copies the fixture's search response.
+func (s *versionServer) searchResponse() (response map[string]interface{}) {
+	data, _ := json.Marshal(s.fixture.Search)
+	json.Unmarshal(data, &response)
+	return response
+}
+
+func TestVersions(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(testVersionsPath, "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No version fixtures found: %v", err)
+	}
+	filtered := json.RawMessage(`{"query": {"filtered": {
+		"query": {"query_string": {"query": "status:500"}},
+		"filter": {"term": {"host": "web1"}}
+	}}}`)
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		server := newVersionServer(t, path)
+
+		l, err := New(server.URL)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			server.Close()
+			continue
+		}
+		if !reflect.DeepEqual(l.Version, server.version) {
+			t.Errorf("%s: detected version %s", name, l.Version)
+		}
+
+		spec := &SearchOptions{Index: "logstash-*", Type: "logs", Size: 10}
+		if docs, err := l.SimpleSearch("status:500", spec); err != nil || len(docs) != 2 {
+			t.Errorf("%s: searched %d documents: %v", name, len(docs), err)
+		}
+		scroll := &SearchOptions{Index: "logstash-*", Size: MaxSearchSize + 1}
+		if docs, err := l.SimpleSearch("status:500", scroll); err != nil || len(docs) != 2 {
+			t.Errorf("%s: scrolled %d documents: %v", name, len(docs), err)
+		}
+		if docs, err := l.SearchWithSource(filtered, spec); err != nil || len(docs) != 2 {
+			t.Errorf("%s: searched %d documents with a filtered query: %v", name, len(docs), err)
+		}
+		if count, err := l.SimpleCount("status:500", spec); err != nil || count != 2 {
+			t.Errorf("%s: counted %d documents: %v", name, count, err)
+		}
+		if count, err := l.CountWithSource(filtered, spec); err != nil || count != 2 {
+			t.Errorf("%s: counted %d documents with a filtered query: %v", name, count, err)
+		}
+		if result, err := l.Validate("status:500", spec); err != nil || !result.Valid {
+			t.Errorf("%s: validated %t: %v", name, result.Valid, err)
+		}
+		fields, err := l.Fields(spec)
+		if err != nil || !reflect.DeepEqual(fields, []string{"@timestamp", "host", "message", "status"}) &&
+			!reflect.DeepEqual(fields, []string{"@timestamp", "host", "host.keyword", "message", "message.keyword", "status"}) {
+			t.Errorf("%s: fields %v: %v", name, fields, err)
+		}
+		if t.Failed() {
+			t.Logf("%s requests:\n%s", name, strings.Join(server.requests, "\n"))
+		}
+		l.Stop()
+		server.Close()
+	}
+}
+
+func TestTypelessPath(t *testing.T) {
+	examples := map[string]string{
+		"/logs-*/logs/_search":          "/logs-*/_search",
+		"/logs-*/logs/_validate/query":  "/logs-*/_validate/query",
+		"/_all/logs,events/_count":      "/_all/_count",
+		"/logs-*/_mapping/logs":         "/logs-*/_mapping",
+		"/logs-*/_search":               "/logs-*/_search",
+		"/_search/scroll":               "/_search/scroll",
+		"/logs-*/logs/AVRiZ1xZ":         "/logs-*/logs/AVRiZ1xZ",
+		"/logs-*/_validate/query":       "/logs-*/_validate/query",
+		"/logs-*/logs/_search/template": "/logs-*/_search/template",
+	}
+	for path, expected := range examples {
+		if typeless := typelessPath(path); typeless != expected {
+			t.Errorf("Typeless path of '%s' => '%s', expected '%s'", path, typeless, expected)
+		}
+	}
+}
+
+func TestRewriteFiltered(t *testing.T) {
+	examples := map[string]string{
+		`{"query": {"filtered": {"query": {"match_all": {}}, "filter": {"term": {"a": 1}}}}}`:         `{"query": {"bool": {"must": {"match_all": {}}, "filter": {"term": {"a": 1}}}}}`,
+		`{"query": {"bool": {"must": [{"filtered": {"filter": {"term": {"a": 1}}, "_name": "f"}}]}}}`: `{"query": {"bool": {"must": [{"bool": {"filter": {"term": {"a": 1}}, "_name": "f"}}]}}}`,
+		// A field named filtered isn't a filtered query.
+		`{"query": {"term": {"filtered": {"value": true}}}}`: `{"query": {"term": {"filtered": {"value": true}}}}`,
+	}
+	for query, expected := range examples {
+		var q, e interface{}
+		json.Unmarshal([]byte(query), &q)
+		json.Unmarshal([]byte(expected), &e)
+		rewritten, changed := rewriteFiltered(q)
+		if !reflect.DeepEqual(rewritten, e) {
+			t.Errorf("Rewrote %s => %v, expected %s", query, rewritten, expected)
+		}
+		if changed == (query == expected) {
+			t.Errorf("Rewriting %s reported changed %t", query, changed)
+		}
+	}
+}
+
+func TestParseServerVersion(t *testing.T) {
+	examples := []struct {
+		number, distribution string
+		api                  int
+	}{
+		{"2.4.6", "", 2},
+		{"7.17.9", "", 7},
+		{"8.0.0-SNAPSHOT", "", 8},
+		{"1.3.14", DistributionOpenSearch, 7},
+		{"2.11.0", DistributionOpenSearch, 7},
+	}
+	for _, example := range examples {
+		version, err := ParseServerVersion(example.number, example.distribution)
+		if err != nil {
+			t.Errorf("Version %s: %s", example.number, err)
+			continue
+		}
+		if version.APIVersion() != example.api {
+			t.Errorf("Version %s speaks API %d, expected %d", version, version.APIVersion(), example.api)
+		}
+	}
+	for _, invalid := range []string{"", "x.y", "0.90"} {
+		if _, err := ParseServerVersion(invalid, ""); err == nil {
+			t.Errorf("Expected an error for version '%s'", invalid)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package lgrep
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+var (
+	// DefaultStreamFields are the fields that documents must share
+	// with a hit to be considered part of the same stream of logs.
+	DefaultStreamFields = []string{"host", "service"}
+	// ErrNoTimestamp is returned when a document has no timestamp to
+	// find its surrounding documents with.
+	ErrNoTimestamp = errors.New("Document has no timestamp field")
+)
+
+// ContextOptions configures the documents that are retrieved from
+// around a hit, as with grep -A/-B/-C.
+type ContextOptions struct {
+	// Before is the number of documents to retrieve from before the
+	// hit.
+	Before int
+	// After is the number of documents to retrieve from after the hit.
+	After int
+	// StreamFields are the fields that the surrounding documents must
+	// share with the hit, DefaultStreamFields are used if none are
+	// given.
+	StreamFields []string
+}
+
+// Context retrieves the documents from the same stream that were
+// logged immediately before and after the hit, both are returned
+// oldest first. The spec's index, fields and raw result options are
+// used to search for and return the documents.
+func (l LGrep) Context(hit Result, opts ContextOptions, spec *SearchOptions) (before []Result, after []Result, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	if opts.StreamFields == nil {
+		opts.StreamFields = DefaultStreamFields
+	}
+	data, err := resultSource(hit)
+	if err != nil {
+		return before, after, err
+	}
+
+	var tsField, ts string
+	for _, field := range tsPreference {
+		if value, ok := data[field].(string); ok {
+			tsField, ts = field, value
+			break
+		}
+	}
+	if tsField == "" {
+		return before, after, ErrNoTimestamp
+	}
+
+	stream := make(map[string]interface{}, len(opts.StreamFields))
+	query := elastic.NewBoolQuery()
+	for _, field := range opts.StreamFields {
+		value, ok := fieldValue(data, field)
+		if !ok {
+			query.MustNot(elastic.NewExistsQuery(field))
+			continue
+		}
+		stream[field] = value
+		query.Filter(elastic.NewMatchPhraseQuery(field, value))
+	}
+
+	ctxSpec := *spec
+	if len(ctxSpec.Fields) != 0 {
+		// The stream fields are needed to check the surrounding
+		// documents.
+		ctxSpec.Fields = append(append([]string{}, spec.Fields...), opts.StreamFields...)
+	}
+
+	key := ResultKey(hit)
+	if opts.Before > 0 {
+		rng := elastic.NewRangeQuery(tsField).Lte(ts)
+		before, err = l.contextSearch(query, rng, tsField, false, opts.Before, key, stream, ctxSpec)
+		if err != nil {
+			return before, after, err
+		}
+		// Retrieved newest first, reverse to be read in order.
+		for i, j := 0, len(before)-1; i < j; i, j = i+1, j-1 {
+			before[i], before[j] = before[j], before[i]
+		}
+	}
+	if opts.After > 0 {
+		rng := elastic.NewRangeQuery(tsField).Gte(ts)
+		after, err = l.contextSearch(query, rng, tsField, true, opts.After, key, stream, ctxSpec)
+	}
+	return before, after, err
+}
+
+// contextSearch retrieves up to size documents in the time range that
+// belong to the stream, excluding the hit itself. The phrase queries
+// also match other streams (ex: host web1-old for web1), more
+// documents are searched for while those crowd out the stream's.
+func (l LGrep) contextSearch(query *elastic.BoolQuery, rng *elastic.RangeQuery, tsField string, asc bool, size int, hitKey string, stream map[string]interface{}, spec SearchOptions) (results []Result, err error) {
+	spec.SortTime = nil
+	for fetch := size + 1; ; fetch *= 2 {
+		if fetch > MaxSearchSize {
+			fetch = MaxSearchSize
+		}
+		spec.Size = fetch
+		source := elastic.NewSearchSource()
+		spec.configureSource(source)
+		source.Query(elastic.NewBoolQuery().Must(query).Filter(rng))
+		source.SortBy(elastic.NewFieldSort(tsField).UnmappedType("boolean").Order(asc))
+		body, err := source.Source()
+		if err != nil {
+			return results, err
+		}
+
+		res, err := l.backend().Search(context.TODO(), spec.target(), body)
+		if err != nil {
+			return results, errors.Annotate(err, "Could not retrieve the context of the result")
+		}
+		results = results[:0]
+		for _, h := range res.Hits.Hits {
+			result, err := extractResult(h, spec)
+			if err != nil {
+				return results, err
+			}
+			if ResultKey(result) == hitKey || !sameStream(result, stream) {
+				continue
+			}
+			results = append(results, result)
+			if len(results) == size {
+				break
+			}
+		}
+		if len(results) == size || len(res.Hits.Hits) < fetch || fetch == MaxSearchSize {
+			break
+		}
+	}
+	log.Debugf("Found %d context results (asc: %t) for %v", len(results), asc, stream)
+	return results, nil
+}
+
+// sameStream checks that the result has exactly the same values for
+// the stream fields, the phrase queries used to search for them also
+// match partial values.
+func sameStream(r Result, stream map[string]interface{}) bool {
+	data, err := resultSource(r)
+	if err != nil {
+		return false
+	}
+	for field, expected := range stream {
+		value, ok := fieldValue(data, field)
+		if !ok || fmt.Sprint(value) != fmt.Sprint(expected) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package lgrep_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+// streamDocs are the documents of several streams interleaved, by id.
+var streamDocs = []struct {
+	id     string
+	source map[string]interface{}
+}{
+	{"s1", map[string]interface{}{"@timestamp": "2016-04-29T10:00:00Z", "host": "web1", "service": "sshd", "message": "one"}},
+	{"s2", map[string]interface{}{"@timestamp": "2016-04-29T10:01:00Z", "host": "web1", "service": "sshd", "message": "two"}},
+	{"k1", map[string]interface{}{"@timestamp": "2016-04-29T10:02:00Z", "host": "web1", "service": "kernel", "message": "kernel"}},
+	{"o1", map[string]interface{}{"@timestamp": "2016-04-29T10:03:00Z", "host": "web1-old", "service": "sshd", "message": "old"}},
+	{"h1", map[string]interface{}{"@timestamp": "2016-04-29T10:04:00Z", "host": "web1", "service": "sshd", "message": "hit"}},
+	{"s3", map[string]interface{}{"@timestamp": "2016-04-29T10:05:00Z", "host": "web1", "service": "sshd", "message": "three"}},
+	{"w2", map[string]interface{}{"@timestamp": "2016-04-29T10:06:00Z", "host": "web2", "service": "sshd", "message": "web2"}},
+	{"s4", map[string]interface{}{"@timestamp": "2016-04-29T10:07:00Z", "host": "web1", "service": "sshd", "message": "four"}},
+	{"s5", map[string]interface{}{"@timestamp": "2016-04-29T10:08:00Z", "host": "web1", "service": "sshd", "message": "five"}},
+	{"n1", map[string]interface{}{"@timestamp": "2016-04-29T10:09:00Z", "host": "web1", "message": "unnamed one"}},
+	{"n2", map[string]interface{}{"@timestamp": "2016-04-29T10:10:00Z", "host": "web1", "message": "unnamed two"}},
+	{"u1", map[string]interface{}{"host": "web1", "service": "sshd", "message": "untimed"}},
+}
+
+// newStreamServer starts a fake server with the streamDocs in the
+// index "streams".
+func newStreamServer(t *testing.T) *lgreptest.Server {
+	server := lgreptest.NewServer()
+	for _, doc := range streamDocs {
+		if err := server.Index("streams", "log", doc.id, doc.source); err != nil {
+			server.Close()
+			t.Fatal(err)
+		}
+	}
+	return server
+}
+
+// hitIds returns the ids of the hits.
+func hitIds(results []lgrep.Result) (ids []string) {
+	for _, r := range results {
+		ids = append(ids, r.(lgrep.HitResult).Id)
+	}
+	return ids
+}
+
+func TestContext(t *testing.T) {
+	server := newStreamServer(t)
+	defer server.Close()
+	l, err := lgrep.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &lgrep.SearchOptions{Index: "streams", Size: 1, RawResult: true}
+	find := func(message string) lgrep.Result {
+		results, err := l.SimpleSearch("message:\""+message+"\"", spec)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("Could not find %s: %v (%d results)", message, err, len(results))
+		}
+		return results[0]
+	}
+
+	examples := []struct {
+		message       string
+		opts          lgrep.ContextOptions
+		before, after string
+	}{
+		// Oldest first on both sides, other hosts and services (even
+		// when their values contain the hit's) are excluded.
+		{"hit", lgrep.ContextOptions{Before: 2, After: 2}, "s1 s2", "s3 s4"},
+		{"hit", lgrep.ContextOptions{Before: 1}, "s2", ""},
+		{"two", lgrep.ContextOptions{Before: 5, After: 1}, "s1", "h1"},
+		{"five", lgrep.ContextOptions{Before: 1, After: 3}, "s4", ""},
+		// Only the host is shared with the given stream fields.
+		{"hit", lgrep.ContextOptions{Before: 2, After: 1, StreamFields: []string{"host"}}, "s2 k1", "s3"},
+		// Documents that don't have a stream field are of the same
+		// stream as the others without it.
+		{"unnamed one", lgrep.ContextOptions{Before: 1, After: 1}, "", "n2"},
+	}
+	for _, ex := range examples {
+		before, after, err := l.Context(find(ex.message), ex.opts, spec)
+		if err != nil {
+			t.Errorf("Context of %s %+v: %s", ex.message, ex.opts, err)
+			continue
+		}
+		if ids := strings.Join(hitIds(before), " "); ids != ex.before {
+			t.Errorf("Context of %s %+v: before %q, expected %q", ex.message, ex.opts, ids, ex.before)
+		}
+		if ids := strings.Join(hitIds(after), " "); ids != ex.after {
+			t.Errorf("Context of %s %+v: after %q, expected %q", ex.message, ex.opts, ids, ex.after)
+		}
+	}
+
+	if _, _, err = l.Context(find("untimed"), lgrep.ContextOptions{Before: 1}, spec); err != lgrep.ErrNoTimestamp {
+		t.Errorf("Expected ErrNoTimestamp, got %v", err)
+	}
+}
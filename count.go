@@ -0,0 +1,46 @@
+package lgrep
+
+import (
+	"context"
+)
+
+// SimpleCount returns the number of documents that match the lucene
+// query (and any patterns) in the spec's indices.
+func (l LGrep) SimpleCount(q string, spec *SearchOptions) (count int64, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	patterns := spec.lucenePatterns(q)
+	if len(patterns) == 0 {
+		return count, ErrEmptySearch
+	}
+	for _, p := range patterns {
+		if _, err = ParseLucene(p); err != nil {
+			return count, err
+		}
+	}
+	query, err := LucenePatternsQuery(patterns, spec.PatternsAll, spec.Invert).Source()
+	if err != nil {
+		return count, err
+	}
+	body := map[string]interface{}{"query": query}
+	return l.backend().Count(context.TODO(), spec.target(), body)
+}
+
+// CountWithSource returns the number of documents that match the
+// query of a raw search body (see SearchWithSource), the rest of the
+// body is not used.
+func (l LGrep) CountWithSource(raw interface{}, spec *SearchOptions) (count int64, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	query, err := queryFromRaw(raw)
+	if err != nil {
+		return count, err
+	}
+	body := map[string]interface{}{}
+	if qm, ok := query.(QueryMap); ok && qm["query"] != nil {
+		body["query"] = qm["query"]
+	}
+	return l.backend().Count(context.TODO(), spec.target(), body)
+}
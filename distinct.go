@@ -0,0 +1,252 @@
+package lgrep
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+const (
+	// distinctAgg names the composite aggregation used to find the
+	// distinct values.
+	distinctAgg = "distinct"
+	// distinctPageSize is the number of buckets aggregated at a time.
+	distinctPageSize = 1000
+)
+
+var (
+	// ErrNoDistinctFields is returned when there are no fields to
+	// find the distinct values of.
+	ErrNoDistinctFields = errors.New("No fields given to find the distinct values of")
+	// fielddataPattern finds the text field named by the error of
+	// aggregating it, text fields don't have fielddata by default.
+	fielddataPattern = regexp.MustCompile(`(?i)fielddata(?:=true| is disabled) on \[([^\]]+)\]`)
+)
+
+// Distinct is a unique combination of field values and the number of
+// documents that had them.
+type Distinct struct {
+	// Values are the values of each of the fields by their dotted
+	// name, fields missing from the documents are nil.
+	Values map[string]interface{}
+	// Count is the number of documents with these values.
+	Count int64
+}
+
+// Result returns the values as a result so that they may be formatted
+// like any other document, dotted fields are nested as they would be
+// in the document.
+func (d Distinct) Result() Result {
+	return NestFields(d.Values)
+}
+
+// DistinctStream reads the stream entirely, counting each unique
+// combination of the fields' values. The most common combinations are
+// returned first and ties are kept in the order they were found.
+func DistinctStream(stream *SearchStream, fields []string) (distinct []Distinct, err error) {
+	if len(fields) == 0 {
+		return distinct, ErrNoDistinctFields
+	}
+	index := make(map[string]int)
+	err = stream.Each(func(r Result) error {
+		data, err := resultSource(r)
+		if err != nil {
+			return err
+		}
+		values := make([]interface{}, len(fields))
+		for i, field := range fields {
+			values[i], _ = fieldValue(data, field)
+		}
+		key, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		if i, ok := index[string(key)]; ok {
+			distinct[i].Count++
+			return nil
+		}
+		d := Distinct{Values: make(map[string]interface{}, len(fields)), Count: 1}
+		for i, field := range fields {
+			d.Values[field] = values[i]
+		}
+		index[string(key)] = len(distinct)
+		distinct = append(distinct, d)
+		return nil
+	}, func(e error) error { return e })
+	if err != nil {
+		return distinct, err
+	}
+	sort.Stable(byDistinctCount(distinct))
+	return distinct, nil
+}
+
+// compositeAggregation pages through every unique combination of the
+// fields' values, documents missing fields are counted with nil
+// values.
+type compositeAggregation struct {
+	fields []string
+	size   int
+	// after is the after_key of the previous page.
+	after map[string]interface{}
+}
+
+// Source returns the JSON of the aggregation, the fields' sources are
+// named by their position.
+func (a compositeAggregation) Source() (interface{}, error) {
+	sources := make([]interface{}, len(a.fields))
+	for i, field := range a.fields {
+		sources[i] = map[string]interface{}{
+			strconv.Itoa(i): map[string]interface{}{
+				"terms": map[string]interface{}{"field": field, "missing_bucket": true},
+			},
+		}
+	}
+	composite := map[string]interface{}{"size": a.size, "sources": sources}
+	if a.after != nil {
+		composite["after"] = a.after
+	}
+	return map[string]interface{}{"composite": composite}, nil
+}
+
+// keyword aggregates the keyword sub-field of the text field instead,
+// unless it already is.
+func (a *compositeAggregation) keyword(text string) bool {
+	for i, field := range a.fields {
+		if field == text && !strings.HasSuffix(field, ".keyword") {
+			a.fields[i] = field + ".keyword"
+			return true
+		}
+	}
+	return false
+}
+
+// compositePage is a page of the buckets of a composite aggregation.
+type compositePage struct {
+	AfterKey map[string]interface{} `json:"after_key"`
+	Buckets  []struct {
+		Key      map[string]interface{} `json:"key"`
+		DocCount int64                  `json:"doc_count"`
+	} `json:"buckets"`
+}
+
+// fielddataField returns the text field that the error is about
+// failing to aggregate, if that's what it is about.
+func fielddataField(err error) (field string, ok bool) {
+	e, ok := errors.Cause(err).(*elastic.Error)
+	if !ok || e.Details == nil {
+		return "", false
+	}
+	reasons := []string{e.Details.Reason}
+	for _, cause := range e.Details.RootCause {
+		if cause != nil {
+			reasons = append(reasons, cause.Reason)
+		}
+	}
+	for _, reason := range reasons {
+		if m := fielddataPattern.FindStringSubmatch(reason); m != nil {
+			return m[1], true
+		}
+	}
+	return "", false
+}
+
+// SimpleDistinct finds the unique combinations of the fields' values
+// for all the documents matching the lucene query with a composite
+// aggregation, paging through its buckets, the most common are
+// returned first. Text fields that can't be aggregated are retried
+// with their keyword sub-field (ex: host.keyword), the values are
+// still returned by the fields given. This requires Elasticsearch 7
+// or later (or OpenSearch) and the documents can't be matched on the
+// client.
+func (l LGrep) SimpleDistinct(q string, fields []string, spec *SearchOptions) (distinct []Distinct, err error) {
+	if len(fields) == 0 {
+		return distinct, ErrNoDistinctFields
+	}
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	if len(spec.Matches) != 0 {
+		return distinct, errors.New("Distinct values can't be aggregated for documents matched on the client")
+	}
+	if l.Version.APIVersion() < 7 {
+		return distinct, errors.Errorf("Aggregating distinct values requires Elasticsearch 7 or later, not %s", l.Version)
+	}
+
+	agg := compositeAggregation{fields: append([]string{}, fields...), size: distinctPageSize}
+	for {
+		source, err := l.NewLuceneSearch(q, spec)
+		if err != nil {
+			return distinct, err
+		}
+		source.Aggregation(distinctAgg, agg)
+		aggs, err := l.aggregate(source, spec)
+		if text, ok := fielddataField(err); ok && agg.after == nil && agg.keyword(text) {
+			log.Debugf("%s is a text field, aggregating %s.keyword instead", text, text)
+			continue
+		} else if err != nil {
+			return distinct, errors.Annotate(err, "Could not aggregate the distinct values")
+		}
+		raw, ok := aggs[distinctAgg]
+		if !ok || raw == nil {
+			return distinct, errors.New("The distinct values weren't aggregated")
+		}
+		var page compositePage
+		if err = json.Unmarshal(*raw, &page); err != nil {
+			return distinct, errors.Annotate(err, "Could not read the distinct values")
+		}
+		for _, bucket := range page.Buckets {
+			d := Distinct{Values: make(map[string]interface{}, len(fields)), Count: bucket.DocCount}
+			for i, field := range fields {
+				d.Values[field] = bucket.Key[strconv.Itoa(i)]
+			}
+			distinct = append(distinct, d)
+		}
+		if len(page.Buckets) == 0 || page.AfterKey == nil {
+			break
+		}
+		agg.after = page.AfterKey
+	}
+
+	sort.Stable(byDistinctCount(distinct))
+	return distinct, nil
+}
+
+// SimpleTerms counts the documents matching the lucene query by the
+// values of the field using a terms aggregation, up to spec.Size of
+// the most common values are returned first. Documents missing the
+// field aren't counted.
+func (l LGrep) SimpleTerms(q string, field string, spec *SearchOptions) (terms []Distinct, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	source, err := l.NewLuceneSearch(q, spec)
+	if err != nil {
+		return terms, err
+	}
+	source.Aggregation(distinctAgg, elastic.NewTermsAggregation().Field(field).Size(spec.Size))
+	aggs, err := l.aggregate(source, spec)
+	if err != nil {
+		return terms, errors.Annotatef(err, "Could not aggregate the values of %s", field)
+	}
+	buckets, ok := aggs.Terms(distinctAgg)
+	if !ok {
+		return terms, errors.Errorf("The values of %s weren't aggregated", field)
+	}
+	for _, bucket := range buckets.Buckets {
+		terms = append(terms, Distinct{Values: map[string]interface{}{field: bucket.Key}, Count: bucket.DocCount})
+	}
+	return terms, nil
+}
+
+// byDistinctCount sorts the most common values first.
+type byDistinctCount []Distinct
+
+func (d byDistinctCount) Len() int           { return len(d) }
+func (d byDistinctCount) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byDistinctCount) Less(i, j int) bool { return d[i].Count > d[j].Count }
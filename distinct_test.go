@@ -0,0 +1,188 @@
+package lgrep
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/olivere/elastic.v3"
+)
+
+func TestDistinctStream(t *testing.T) {
+	stream := newTestStream(
+		SourceResult(`{"host": "web1", "service": "nginx"}`),
+		SourceResult(`{"host": "web2", "service": "nginx"}`),
+		SourceResult(`{"host": "web2", "service": "nginx"}`),
+		SourceResult(`{"host": "web1", "service": "cron"}`),
+		SourceResult(`{"service": "cron"}`),
+		FieldResult{"host": "web1", "service": "cron"},
+	)
+	distinct, err := DistinctStream(stream, []string{"host", "service"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Distinct{
+		{Values: map[string]interface{}{"host": "web2", "service": "nginx"}, Count: 2},
+		{Values: map[string]interface{}{"host": "web1", "service": "cron"}, Count: 2},
+		{Values: map[string]interface{}{"host": "web1", "service": "nginx"}, Count: 1},
+		{Values: map[string]interface{}{"host": nil, "service": "cron"}, Count: 1},
+	}
+	if !reflect.DeepEqual(distinct, expected) {
+		t.Errorf("Distinct values %v, expected %v", distinct, expected)
+	}
+
+	if _, err = DistinctStream(newTestStream(), nil); err != ErrNoDistinctFields {
+		t.Errorf("Expected an error without fields, got %v", err)
+	}
+}
+
+func TestDistinctResult(t *testing.T) {
+	d := Distinct{Values: map[string]interface{}{
+		"beat.host":    "web1",
+		"beat.version": "5.0",
+		"service":      "nginx",
+	}}
+	expected := FieldResult{
+		"beat":    map[string]interface{}{"host": "web1", "version": "5.0"},
+		"service": "nginx",
+	}
+	if r := d.Result(); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Result %v, expected %v", r, expected)
+	}
+}
+
+// aggBackend answers with pages of the distinct aggregation, recording
+// the aggregations requested. Pages with an error are returned as the
+// error of Elasticsearch.
+type aggBackend struct {
+	*fakeBackend
+	pages    []string
+	requests []map[string]interface{}
+}
+
+func (b *aggBackend) Search(ctx context.Context, target SearchTarget, body interface{}) (*elastic.SearchResult, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var req struct {
+		Aggs map[string]map[string]interface{} `json:"aggregations"`
+	}
+	if err = json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	b.requests = append(b.requests, req.Aggs[distinctAgg])
+	page := json.RawMessage(b.pages[len(b.requests)-1])
+	if strings.HasPrefix(string(page), `{"error"`) {
+		e := new(elastic.Error)
+		if err = json.Unmarshal(page, e); err != nil {
+			return nil, err
+		}
+		return nil, e
+	}
+	return &elastic.SearchResult{Hits: &elastic.SearchHits{}, Aggregations: elastic.Aggregations{distinctAgg: &page}}, nil
+}
+
+func TestSimpleDistinct(t *testing.T) {
+	backend := &aggBackend{fakeBackend: newFakeBackend(), pages: []string{
+		`{"after_key": {"0": "web1", "1": null}, "buckets": [
+			{"key": {"0": "web1", "1": "nginx"}, "doc_count": 2},
+			{"key": {"0": "web1", "1": null}, "doc_count": 1}]}`,
+		`{"after_key": {"0": "web2", "1": "nginx"}, "buckets": [
+			{"key": {"0": "web2", "1": "nginx"}, "doc_count": 5}]}`,
+		`{"buckets": []}`,
+	}}
+	l := NewWithBackend(backend)
+	l.Version = ServerVersion{Number: "7.17.9", Distribution: DistributionElasticsearch, Major: 7}
+	spec := &SearchOptions{Index: "logs-*", Size: MaxSearchSize + 1}
+
+	distinct, err := l.SimpleDistinct("status:500", []string{"host", "service"}, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Distinct{
+		{Values: map[string]interface{}{"host": "web2", "service": "nginx"}, Count: 5},
+		{Values: map[string]interface{}{"host": "web1", "service": "nginx"}, Count: 2},
+		{Values: map[string]interface{}{"host": "web1", "service": nil}, Count: 1},
+	}
+	if !reflect.DeepEqual(distinct, expected) {
+		t.Errorf("Distinct values %v, expected %v", distinct, expected)
+	}
+
+	if len(backend.requests) != 3 {
+		t.Fatalf("Expected 3 pages aggregated, requested %d", len(backend.requests))
+	}
+	var first, second map[string]interface{}
+	json.Unmarshal([]byte(`{"composite": {"size": 1000, "sources": [
+		{"0": {"terms": {"field": "host", "missing_bucket": true}}},
+		{"1": {"terms": {"field": "service", "missing_bucket": true}}}]}}`), &first)
+	if !reflect.DeepEqual(backend.requests[0], first) {
+		t.Errorf("Aggregated %v, expected %v", backend.requests[0], first)
+	}
+	json.Unmarshal([]byte(`{"0": "web1", "1": null}`), &second)
+	if after := backend.requests[1]["composite"].(map[string]interface{})["after"]; !reflect.DeepEqual(after, second) {
+		t.Errorf("Paged after %v, expected %v", after, second)
+	}
+
+	matched := &SearchOptions{Index: "logs-*", Matches: []FieldMatch{{Field: "host", Pattern: regexp.MustCompile("^web")}}}
+	if _, err = l.SimpleDistinct("status:500", []string{"host"}, matched); err == nil {
+		t.Error("Expected client matches to be rejected")
+	}
+	l.Version = ServerVersion{Number: "5.6.16", Distribution: DistributionElasticsearch, Major: 5}
+	if _, err = l.SimpleDistinct("status:500", []string{"host"}, spec); err == nil {
+		t.Error("Expected Elasticsearch 5 to be rejected")
+	}
+}
+
+func TestSimpleDistinctKeyword(t *testing.T) {
+	backend := &aggBackend{fakeBackend: newFakeBackend(), pages: []string{
+		`{"error": {"type": "search_phase_execution_exception", "reason": "all shards failed", "root_cause": [
+			{"type": "illegal_argument_exception", "reason": "Text fields are not optimised for operations that require per-document field data like aggregations and sorting, so these operations are disabled by default. Please use a keyword field instead. Alternatively, set fielddata=true on [host] in order to load field data by uninverting the inverted index."}]},
+			"status": 400}`,
+		`{"buckets": [{"key": {"0": "web1", "1": 500}, "doc_count": 3}]}`,
+	}}
+	l := NewWithBackend(backend)
+	l.Version = ServerVersion{Number: "7.17.9", Distribution: DistributionElasticsearch, Major: 7}
+
+	distinct, err := l.SimpleDistinct("status:500", []string{"host", "status"}, &SearchOptions{Index: "logs-*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Distinct{{Values: map[string]interface{}{"host": "web1", "status": float64(500)}, Count: 3}}
+	if !reflect.DeepEqual(distinct, expected) {
+		t.Errorf("Distinct values %v, expected %v", distinct, expected)
+	}
+	var retried map[string]interface{}
+	json.Unmarshal([]byte(`{"composite": {"size": 1000, "sources": [
+		{"0": {"terms": {"field": "host.keyword", "missing_bucket": true}}},
+		{"1": {"terms": {"field": "status", "missing_bucket": true}}}]}}`), &retried)
+	if len(backend.requests) != 2 || !reflect.DeepEqual(backend.requests[1], retried) {
+		t.Errorf("Retried with %v, expected %v", backend.requests[1:], retried)
+	}
+}
+
+func TestSimpleTerms(t *testing.T) {
+	backend := &aggBackend{fakeBackend: newFakeBackend(), pages: []string{
+		`{"buckets": [{"key": "web2", "doc_count": 5}, {"key": "web1", "doc_count": 2}]}`,
+	}}
+	l := NewWithBackend(backend)
+	terms, err := l.SimpleTerms("status:500", "host", &SearchOptions{Index: "logs-*", Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Distinct{
+		{Values: map[string]interface{}{"host": "web2"}, Count: 5},
+		{Values: map[string]interface{}{"host": "web1"}, Count: 2},
+	}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("Terms %v, expected %v", terms, expected)
+	}
+	var agg map[string]interface{}
+	json.Unmarshal([]byte(`{"terms": {"field": "host", "size": 2}}`), &agg)
+	if !reflect.DeepEqual(backend.requests[0], agg) {
+		t.Errorf("Aggregated %v, expected %v", backend.requests[0], agg)
+	}
+}
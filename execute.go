@@ -3,6 +3,7 @@ package lgrep
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -16,8 +17,14 @@ const (
 	MaxSearchSize   = 10000
 	scrollChunk     = 100
 	scrollKeepalive = "30s"
+	// matchScrollChunk is the scroll size used when results are
+	// filtered on the client, as many are expected to be dropped.
+	matchScrollChunk = 1000
 )
 
+// ErrStreamCancelled is returned when reading a stream is cancelled.
+var ErrStreamCancelled = errors.New("Stream reading was cancelled")
+
 // SearchStream is a stream of results that manages the execution and
 // consumption of that stream.
 type SearchStream struct {
@@ -26,6 +33,13 @@ type SearchStream struct {
 	// Errors is a channel of errors that are encountered.
 	Errors chan error
 
+	// stats counts the documents read from the server and those that
+	// were sent on the stream.
+	stats struct {
+		scanned int64
+		emitted int64
+	}
+
 	// control holds internal variables that are used to control the
 	// stream workers.
 	control struct {
@@ -66,6 +80,18 @@ func (s *SearchStream) Quit() {
 	s.control.stopped = true
 }
 
+// Scanned returns the number of documents that have been read from
+// the server, this includes those filtered out by any FieldMatch.
+func (s *SearchStream) Scanned() int64 {
+	return atomic.LoadInt64(&s.stats.scanned)
+}
+
+// Emitted returns the number of results that have been sent on the
+// stream.
+func (s *SearchStream) Emitted() int64 {
+	return atomic.LoadInt64(&s.stats.emitted)
+}
+
 // All reads the entire stream into memory and returns the results
 // that were read, this exits immediately on any error that is
 // encountered.
@@ -119,9 +145,63 @@ stream:
 	return err
 }
 
+// EachCancel reads the stream like Each until cancel is closed, when
+// ErrStreamCancelled is returned immediately. The cancelled stream is
+// shut down (and drained) in the background so that an unresponsive
+// server doesn't hold up the caller.
+func (s *SearchStream) EachCancel(cancel <-chan struct{}, resultFn func(Result) error, errFn func(error) error) (err error) {
+	abandon := func() {
+		go func() {
+			s.Quit()
+			for range s.Results {
+			}
+		}()
+	}
+	errs := s.Errors
+	for {
+		select {
+		case <-cancel:
+			log.Debug("Stream reading cancelled, stopping any ongoing search")
+			abandon()
+			return ErrStreamCancelled
+
+		case streamErr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if err = errFn(streamErr); err != nil {
+				abandon()
+				return err
+			}
+
+		case result, ok := <-s.Results:
+			if !ok {
+				// The errors are closed before the results, any error
+				// that's left is waiting to be read.
+				for errs != nil {
+					streamErr, ok := <-errs
+					if !ok {
+						break
+					}
+					if err = errFn(streamErr); err != nil {
+						return err
+					}
+				}
+				s.Wait()
+				return nil
+			}
+			if err = resultFn(result); err != nil {
+				abandon()
+				return err
+			}
+		}
+	}
+}
+
 // execute runs the search and accommodates any necessary work to
 // ensure the search is executed properly.
-func (l LGrep) execute(search *elastic.SearchService, query elastic.Query, spec SearchOptions) (stream *SearchStream, err error) {
+func (l LGrep) execute(query elastic.Query, spec SearchOptions) (stream *SearchStream, err error) {
 	stream = &SearchStream{
 		Results: make(chan Result, scrollChunk),
 		Errors:  make(chan error, 1),
@@ -132,54 +212,86 @@ func (l LGrep) execute(search *elastic.SearchService, query elastic.Query, spec
 	stream.control.quit = make(chan struct{}, 1)
 	stream.control.WaitGroup = &sync.WaitGroup{}
 
-	if spec.Size > MaxSearchSize {
-		log.Debugf("searching with scroll for large size (%d)", spec.Size)
+	if spec.SearchAfter != nil {
+		if api := l.Version.APIVersion(); api != 0 && api < 5 {
+			return nil, errors.Errorf("Paging with search_after requires Elasticsearch 5 or later, not %s", l.Version)
+		}
+		log.Debugf("searching with search_after pages for size (%d)", spec.Size)
+
+		source, err := query.Source()
+		if err != nil {
+			return nil, err
+		}
+		queryMap, ok := source.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("cannot execute search_after with provided query, unhandled")
+		}
+		body := make(QueryMap, len(queryMap))
+		for k, v := range queryMap {
+			body[k] = v
+		}
+		spec.configureQueryMap(body)
+		go l.executeSearchAfter(body, spec, stream)
+		return stream, nil
+	}
 
-		if spec.Index == "" || (spec.Index == "" && len(spec.Indices) == 0) {
+	// Results filtered on the client may need many more documents
+	// than requested to be read, scroll through them until enough
+	// match.
+	if spec.Size > MaxSearchSize || len(spec.Matches) != 0 {
+		log.Debugf("searching with scroll for large size (%d) or client matches (%d)", spec.Size, len(spec.Matches))
+
+		// Scrolling through every index would burden the server, files
+		// are read entirely anyway.
+		if _, file := l.backend().(*FileBackend); !file && spec.Size > MaxSearchSize && spec.Index == "" && len(spec.Indices) == 0 {
 			return nil, errors.New("An index pattern must be given for large requests")
 		}
+		chunk := scrollChunk
+		if len(spec.Matches) != 0 {
+			chunk = matchScrollChunk
+		}
 
 		source, err := query.Source()
 		if err != nil {
 			return nil, err
 		}
-
-		scroll := l.Scroll()
-		scroll.KeepAlive(scrollKeepalive)
-		spec.configureScroll(scroll)
-		// reset to the chunk size, otherwise the entire result will
-		// (attempt to) be pulled in a single request
-		scroll.Size(scrollChunk)
-
-		if queryMap, ok := source.(map[string]interface{}); ok {
-			log.Debugf("QueryMap provided, merging with specifications")
-			qm := QueryMap(queryMap)
-			spec.configureQueryMap(qm)
-			qm["size"] = scrollChunk
-			log.Debugf("QueryMap result: %#v", qm)
-			scroll.Body(qm)
-		} else {
+		queryMap, ok := source.(map[string]interface{})
+		if !ok {
 			// TODO: Verify any other query type and pass it into the query for the user.
 			log.Errorf("cannot execute scroll with provided query, unhandled")
 			return nil, errors.New("cannot execute scroll with provided query, unhandled")
 		}
+		log.Debugf("QueryMap provided, merging with specifications")
+		qm := QueryMap(queryMap)
+		spec.configureQueryMap(qm)
+		// reset to the chunk size, otherwise the entire result will
+		// (attempt to) be pulled in a single request
+		qm["size"] = chunk
+		log.Debugf("QueryMap result: %#v", qm)
 
-		go l.executeScroll(scroll, query, spec, stream)
+		go l.executeScroll(qm, spec, stream)
 	} else {
 		log.Debugf("searching with regular query for small size (%d)", spec.Size)
-		go l.executeSearcher(search, query, spec, stream)
+		body, err := query.Source()
+		if err != nil {
+			return nil, err
+		}
+		go l.executeSearch(body, spec, stream)
 	}
 
 	return stream, nil
 }
 
-func (l LGrep) executeScroll(scroll *elastic.ScrollService, query elastic.Query, spec SearchOptions, stream *SearchStream) {
+// executeScroll scrolls through the results of the search body.
+func (l LGrep) executeScroll(body QueryMap, spec SearchOptions, stream *SearchStream) {
 	stream.control.Add(1)
 	defer stream.control.Done()
 
 	var (
 		resultCount  int
 		nextScrollID string
+		backend      = l.backend()
+		target       = spec.target()
 	)
 
 	defer close(stream.Results)
@@ -190,8 +302,7 @@ func (l LGrep) executeScroll(scroll *elastic.ScrollService, query elastic.Query,
 scrollLoop:
 	for {
 		if nextScrollID != "" {
-			log.Debugf("Fetching next page using scrollID %s", nextScrollID[:10])
-			scroll.ScrollId(nextScrollID)
+			log.Debugf("Fetching next page using scrollID %.10s", nextScrollID)
 			if resultCount >= spec.Size {
 				break scrollLoop
 			}
@@ -199,12 +310,10 @@ scrollLoop:
 			log.Debug("Fetching first page of scroll")
 		}
 
-		results, err := scroll.DoC(ctx)
+		results, err := backend.Scroll(ctx, target, body, scrollKeepalive, nextScrollID)
 		if err != nil {
 			log.Debugf("An error was returned during scroll after %d results.", resultCount)
-			if err != elastic.EOS {
-				stream.Errors <- errors.Annotate(err, "Server responded with error while scrolling.")
-			}
+			stream.Errors <- errors.Annotate(err, "Server responded with error while scrolling.")
 			break scrollLoop
 		}
 
@@ -214,17 +323,27 @@ scrollLoop:
 			}
 		}
 
+		if len(results.Hits.Hits) == 0 {
+			log.Debugf("Scroll ended after %d results.", resultCount)
+			break scrollLoop
+		}
+
 		for _, hit := range results.Hits.Hits {
+			atomic.AddInt64(&stream.stats.scanned, 1)
 			result, err := extractResult(hit, spec)
 			if err != nil {
 				stream.Errors <- err
 			}
+			if err == nil && !matchAll(result, spec.Matches) {
+				continue
+			}
 			select {
 			case <-stream.control.quit:
 				cancelReq()
 				log.Debug("Stream instructed to quit")
 				break scrollLoop
 			case stream.Results <- result:
+				atomic.AddInt64(&stream.stats.emitted, 1)
 				resultCount++
 			}
 			if resultCount == spec.Size {
@@ -234,10 +353,79 @@ scrollLoop:
 		}
 	}
 
-	l.ClearScroll(nextScrollID).Do()
+	cancelReq()
+	if nextScrollID != "" {
+		backend.ClearScroll(context.TODO(), nextScrollID)
+	}
 }
 
-func (l LGrep) executeSearcher(service Searcher, query elastic.Query, spec SearchOptions, stream *SearchStream) {
+// executeSearchAfter pages through the results using the sort values
+// of the last hit of each page to request the next.
+func (l LGrep) executeSearchAfter(body QueryMap, spec SearchOptions, stream *SearchStream) {
+	stream.control.Add(1)
+	defer stream.control.Done()
+
+	defer close(stream.Results)
+	defer close(stream.Errors)
+
+	ctx, cancelReq := context.WithCancel(context.TODO())
+	defer cancelReq()
+
+	chunk := scrollChunk
+	if len(spec.Matches) != 0 {
+		chunk = matchScrollChunk
+	}
+	var (
+		resultCount int
+		after       = spec.SearchAfter
+	)
+	for resultCount < spec.Size {
+		page := make(QueryMap, len(body)+2)
+		for k, v := range body {
+			page[k] = v
+		}
+		page["size"] = chunk
+		if len(after) != 0 {
+			page["search_after"] = after
+		}
+		log.Debugf("Fetching page after %v", after)
+		results, err := l.backend().Search(ctx, spec.target(), page)
+		if err != nil {
+			stream.Errors <- errors.Annotate(err, "Server responded with error while paging.")
+			return
+		}
+		if len(results.Hits.Hits) == 0 {
+			return
+		}
+
+		for _, hit := range results.Hits.Hits {
+			after = hit.Sort
+			atomic.AddInt64(&stream.stats.scanned, 1)
+			result, err := extractResult(hit, spec)
+			if err != nil {
+				stream.Errors <- err
+				continue
+			}
+			if !matchAll(result, spec.Matches) {
+				continue
+			}
+			select {
+			case <-stream.control.quit:
+				log.Debug("Stream instructed to quit")
+				return
+			case stream.Results <- result:
+				atomic.AddInt64(&stream.stats.emitted, 1)
+				resultCount++
+			}
+			if resultCount == spec.Size {
+				return
+			}
+		}
+	}
+}
+
+// executeSearch runs the search body for a single page of results.
+func (l LGrep) executeSearch(body interface{}, spec SearchOptions, stream *SearchStream) {
 	// Start worker
 	stream.control.Add(1)
 	defer stream.control.Done()
@@ -245,7 +433,7 @@ func (l LGrep) executeSearcher(service Searcher, query elastic.Query, spec Searc
 	defer close(stream.Results)
 	defer close(stream.Errors)
 
-	result, err := service.Do()
+	result, err := l.backend().Search(context.TODO(), spec.target(), body)
 
 	if err != nil {
 		stream.Errors <- err
@@ -257,12 +445,17 @@ func (l LGrep) executeSearcher(service Searcher, query elastic.Query, spec Searc
 		case <-stream.control.quit:
 			return
 		default:
+			atomic.AddInt64(&stream.stats.scanned, 1)
 			doc, err := extractResult(result.Hits.Hits[i], spec)
 			if err != nil {
 				stream.Errors <- err
 				continue
 			}
+			if !matchAll(doc, spec.Matches) {
+				continue
+			}
 			stream.Results <- doc
+			atomic.AddInt64(&stream.stats.emitted, 1)
 		}
 	}
 }
@@ -0,0 +1,51 @@
+package lgrep
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// newTestStream creates a stream that is already filled with the
+// results.
+func newTestStream(results ...Result) *SearchStream {
+	stream := &SearchStream{
+		Results: make(chan Result, len(results)),
+		Errors:  make(chan error, 1),
+	}
+	stream.control.quit = make(chan struct{}, 1)
+	stream.control.WaitGroup = &sync.WaitGroup{}
+	for _, r := range results {
+		stream.Results <- r
+	}
+	close(stream.Results)
+	close(stream.Errors)
+	return stream
+}
+
+func TestEachCancel(t *testing.T) {
+	stream := newTestStream(SourceResult(`{"n": 1}`), SourceResult(`{"n": 2}`))
+	count := 0
+	err := stream.EachCancel(nil, func(Result) error { count++; return nil }, func(e error) error { return e })
+	if err != nil || count != 2 {
+		t.Errorf("Read %d results with error %v, expected 2", count, err)
+	}
+
+	// A stream that never finishes is abandoned when cancelled.
+	stream = &SearchStream{Results: make(chan Result), Errors: make(chan error)}
+	stream.control.quit = make(chan struct{}, 1)
+	stream.control.WaitGroup = &sync.WaitGroup{}
+	cancel := make(chan struct{})
+	close(cancel)
+	err = stream.EachCancel(cancel, func(Result) error { return nil }, func(e error) error { return e })
+	if err != ErrStreamCancelled {
+		t.Errorf("Expected the stream to be cancelled, got %v", err)
+	}
+
+	failed := errors.New("failed")
+	stream = newTestStream(SourceResult(`{"n": 1}`))
+	err = stream.EachCancel(nil, func(Result) error { return failed }, func(e error) error { return e })
+	if err != failed {
+		t.Errorf("Expected the result error, got %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+package lgrep
+
+// ValidateBody validates a query body without checking it on the
+// client first, for the tests of the lgrep_test package.
+func (l LGrep) ValidateBody(query interface{}, spec SearchOptions) (ValidationResponse, error) {
+	return l.validate(query, spec)
+}
@@ -0,0 +1,228 @@
+package lgrep
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxFieldSuggestions is the number of similar fields suggested
+	// for each unknown field.
+	maxFieldSuggestions = 3
+	// existsField is the lucene pseudo-field whose value is a field.
+	existsField = "_exists_"
+)
+
+// FieldSuggestion is a field that was referenced but isn't in the
+// mapping of the searched indices, along with the most similar fields
+// that are.
+type FieldSuggestion struct {
+	Field       string
+	Suggestions []string
+}
+
+// String formats the suggestion for the user.
+func (f FieldSuggestion) String() string {
+	if len(f.Suggestions) == 0 {
+		return fmt.Sprintf("Unknown field '%s'", f.Field)
+	}
+	return fmt.Sprintf("Unknown field '%s', did you mean: %s?", f.Field, strings.Join(f.Suggestions, ", "))
+}
+
+// LuceneFields returns the fields that are explicitly referenced in
+// the query.
+func LuceneFields(q LuceneQuery) (fields []string) {
+	seen := make(map[string]bool)
+	add := func(field string) {
+		if field != "" && !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	var walk func(LuceneQuery)
+	walk = func(q LuceneQuery) {
+		switch v := q.(type) {
+		case *LuceneBool:
+			for _, clause := range v.Clauses {
+				walk(clause.Query)
+			}
+		case *LuceneTerm:
+			if v.Field == existsField {
+				add(v.Value)
+			} else {
+				add(v.Field)
+			}
+		case *LucenePhrase:
+			add(v.Field)
+		case *LuceneRange:
+			add(v.Field)
+		case *LuceneRegexp:
+			add(v.Field)
+		}
+	}
+	walk(q)
+	return fields
+}
+
+// Fields retrieves the names of all the fields in the mapping of the
+// indices that would be searched with the spec. Object fields and
+// multi-fields are included with their dotted names.
+func (l LGrep) Fields(spec *SearchOptions) (fields []string, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	mapping, err := l.backend().Mapping(context.TODO(), spec.target())
+	if err != nil {
+		return fields, err
+	}
+	return mappingFields(mapping), nil
+}
+
+// CheckFields compares the referenced fields against the mapping of
+// the indices that would be searched, returning a suggestion for each
+// of the fields that aren't found. Meta fields (_id, _type, etc.) and
+// fields with wildcards are not checked.
+func (l LGrep) CheckFields(referenced []string, spec *SearchOptions) (unknown []FieldSuggestion, err error) {
+	known, err := l.Fields(spec)
+	if err != nil {
+		return unknown, err
+	}
+	return checkFields(referenced, known), nil
+}
+
+// checkFields suggests the known fields for each of the referenced
+// fields that isn't known.
+func checkFields(referenced []string, known []string) (unknown []FieldSuggestion) {
+	knownSet := make(map[string]bool, len(known))
+	for _, field := range known {
+		knownSet[field] = true
+	}
+	for _, field := range referenced {
+		if knownSet[field] || strings.HasPrefix(field, "_") || strings.ContainsAny(field, "*?") {
+			continue
+		}
+		unknown = append(unknown, FieldSuggestion{
+			Field:       field,
+			Suggestions: SuggestFields(field, known),
+		})
+	}
+	return unknown
+}
+
+// SuggestFields returns the known fields that are the closest by edit
+// distance to the given field, closest first. Fields whose last path
+// segment matches (host => beat.host) are also considered close.
+func SuggestFields(field string, known []string) (suggestions []string) {
+	// Allow roughly one edit for every three characters.
+	threshold := len(field) / 3
+	if threshold < 1 {
+		threshold = 1
+	}
+	var candidates fieldCandidates
+	for _, k := range known {
+		distance := editDistance(field, k)
+		if i := strings.LastIndex(k, "."); i != -1 {
+			if d := editDistance(field, k[i+1:]); d < distance {
+				distance = d
+			}
+		}
+		if distance <= threshold {
+			candidates = append(candidates, fieldCandidate{k, distance})
+		}
+	}
+	sort.Sort(candidates)
+	for i := 0; i < len(candidates) && i < maxFieldSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].field)
+	}
+	return suggestions
+}
+
+// fieldCandidate is a known field and its distance from a referenced
+// field.
+type fieldCandidate struct {
+	field    string
+	distance int
+}
+
+// fieldCandidates sorts candidates by their distance and then name.
+type fieldCandidates []fieldCandidate
+
+func (c fieldCandidates) Len() int      { return len(c) }
+func (c fieldCandidates) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c fieldCandidates) Less(i, j int) bool {
+	if c[i].distance != c[j].distance {
+		return c[i].distance < c[j].distance
+	}
+	return c[i].field < c[j].field
+}
+
+// editDistance is the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// mappingFields flattens the fields of a get mapping response.
+func mappingFields(mapping map[string]interface{}) (fields []string) {
+	seen := make(map[string]bool)
+	var walk func(prefix string, properties map[string]interface{})
+	walk = func(prefix string, properties map[string]interface{}) {
+		for name, def := range properties {
+			field := prefix + name
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+			props, _ := def.(map[string]interface{})
+			if sub, ok := props["properties"].(map[string]interface{}); ok {
+				walk(field+".", sub)
+			}
+			if multi, ok := props["fields"].(map[string]interface{}); ok {
+				walk(field+".", multi)
+			}
+		}
+	}
+
+	for _, index := range mapping {
+		indexMapping, _ := index.(map[string]interface{})
+		types, _ := indexMapping["mappings"].(map[string]interface{})
+		// Servers without types (7.x and later) give the properties
+		// of the index's mapping directly.
+		if properties, ok := types["properties"].(map[string]interface{}); ok {
+			walk("", properties)
+			continue
+		}
+		for _, typ := range types {
+			typeMapping, _ := typ.(map[string]interface{})
+			if properties, ok := typeMapping["properties"].(map[string]interface{}); ok {
+				walk("", properties)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,52 @@
+package lgrep
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLuceneFields(t *testing.T) {
+	q, err := ParseLucene(`hostnmae:web01 AND (status:[500 TO *] OR _exists_:error) "no field"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"hostnmae", "status", "error"}
+	if fields := LuceneFields(q); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("LuceneFields => %v (expected %v)", fields, expected)
+	}
+}
+
+func TestMappingFields(t *testing.T) {
+	var mapping map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+	  "journald-2016.05.08": {"mappings": {"journald": {"properties": {
+	    "host": {"type": "string", "fields": {"raw": {"type": "string"}}},
+	    "route": {"properties": {"fromdomain": {"type": "string"}}}
+	  }}}},
+	  "journald-2016.05.09": {"mappings": {"journald": {"properties": {
+	    "host": {"type": "string"}, "message": {"type": "string"}
+	  }}}}
+	}`), &mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"host", "host.raw", "message", "route", "route.fromdomain"}
+	if fields := mappingFields(mapping); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("mappingFields => %v (expected %v)", fields, expected)
+	}
+}
+
+func TestCheckFields(t *testing.T) {
+	known := []string{"hostname", "host.raw", "message", "route.fromdomain", "service"}
+	referenced := []string{"hostnmae", "message", "_id", "fromdomian", "servce", "zzzzzzz", "host*"}
+	expected := []FieldSuggestion{
+		{"hostnmae", []string{"hostname"}},
+		{"fromdomian", []string{"route.fromdomain"}},
+		{"servce", []string{"service"}},
+		{"zzzzzzz", nil},
+	}
+	if unknown := checkFields(referenced, known); !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("checkFields => %v (expected %v)", unknown, expected)
+	}
+}
@@ -0,0 +1,759 @@
+package lgrep
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+const (
+	// fileDefaultSize is the number of hits returned when the search
+	// doesn't give its size, as in Elasticsearch.
+	fileDefaultSize = 10
+	// fileCancelCheck is how many documents are evaluated between
+	// checks of the search's cancellation.
+	fileCancelCheck = 1024
+)
+
+// OpenDump opens a file of newline delimited JSON documents, the file
+// is decompressed when it's gzipped (.gz) or zstd compressed (.zst).
+func OpenDump(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Could not open %s", path)
+	}
+	var r io.ReadCloser
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		r, err = gzip.NewReader(f)
+	case strings.HasSuffix(path, ".zst"):
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(f); err == nil {
+			r = zr.IOReadCloser()
+		}
+	default:
+		return f, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Annotatef(err, "Could not decompress %s", path)
+	}
+	return dumpReader{Reader: r, closers: []io.Closer{r, f}}, nil
+}
+
+// dumpReader reads a decompressed file, closing both when done.
+type dumpReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes the decompression and the file.
+func (r dumpReader) Close() (err error) {
+	for _, c := range r.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ReadHits reads the JSON documents of a dump. The documents may be
+// the sources of the results (lgrep -j) or the entire hits (lgrep
+// -J), the index, type and id of the hits are kept while they're left
+// empty for sources.
+func ReadHits(r io.Reader) (hits []*elastic.SearchHit, err error) {
+	hr := NewHitReader(r)
+	for {
+		hit, err := hr.Next()
+		if err == io.EOF {
+			return hits, nil
+		} else if err != nil {
+			return hits, err
+		}
+		hits = append(hits, hit)
+	}
+}
+
+// HitReader reads the JSON documents of a dump one at a time, as
+// ReadHits does, for dumps that are too large to be read entirely.
+type HitReader struct {
+	d *json.Decoder
+	// N is the number of documents read.
+	N int
+}
+
+// NewHitReader reads the documents from r.
+func NewHitReader(r io.Reader) *HitReader {
+	return &HitReader{d: json.NewDecoder(r)}
+}
+
+// Next reads the next document, io.EOF is returned once they've all
+// been read.
+func (r *HitReader) Next() (hit *elastic.SearchHit, err error) {
+	var raw json.RawMessage
+	if err = r.d.Decode(&raw); err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, errors.Annotatef(err, "Could not read document %d", r.N+1)
+	}
+	r.N++
+	if hit, err = readHit(raw); err != nil {
+		return nil, errors.Annotatef(err, "Could not read document %d", r.N)
+	}
+	return hit, nil
+}
+
+// readHit reads a document as a hit, documents with a _source along
+// with an _index or _id are hits themselves, as are those with both an
+// _index and _id (hits of searches that excluded the sources).
+func readHit(raw json.RawMessage) (hit *elastic.SearchHit, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	_, hasSource := fields["_source"]
+	_, hasIndex := fields["_index"]
+	_, hasID := fields["_id"]
+	if hasSource && (hasIndex || hasID) || hasIndex && hasID {
+		hit = new(elastic.SearchHit)
+		err = json.Unmarshal(raw, hit)
+		return hit, err
+	}
+	return &elastic.SearchHit{Source: &raw}, nil
+}
+
+// fileDoc is a document of a file along with its decoded source.
+type fileDoc struct {
+	hit    *elastic.SearchHit
+	source map[string]interface{}
+}
+
+// fileScroll holds the remaining hits of a scroll.
+type fileScroll struct {
+	hits []*elastic.SearchHit
+	size int
+}
+
+// FileBackend searches the documents of a newline delimited JSON file
+// (see ReadHits) that's read into memory. The searches are evaluated
+// in Go and support a subset of the search API: match_all,
+// constant_score, bool, query_string (lucene), range, match,
+// match_phrase, exists and term queries along with sort, size, from,
+// _source and search_after. Aggregations aren't supported and the indices and
+// types of the searches are ignored, the whole file is searched.
+type FileBackend struct {
+	// Name is the file (or other source) of the documents.
+	Name string
+
+	docs []fileDoc
+
+	mu         sync.Mutex
+	scrolls    map[string]*fileScroll
+	nextScroll int
+}
+
+// NewFileBackend reads the documents of the file to be searched. The
+// documents that aren't hits are given the name of the file as their
+// index and their line number as their id.
+func NewFileBackend(path string) (backend *FileBackend, err error) {
+	r, err := OpenDump(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	hits, err := ReadHits(r)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Could not read %s", path)
+	}
+	return NewHitsBackend(path, hits)
+}
+
+// NewHitsBackend searches the hits, as NewFileBackend does those of
+// the named file.
+func NewHitsBackend(name string, hits []*elastic.SearchHit) (backend *FileBackend, err error) {
+	backend = &FileBackend{
+		Name:    name,
+		docs:    make([]fileDoc, 0, len(hits)),
+		scrolls: make(map[string]*fileScroll),
+	}
+	index := fileIndexName(name)
+	for i := range hits {
+		hit := *hits[i]
+		if hit.Index == "" {
+			hit.Index = index
+		}
+		if hit.Id == "" {
+			hit.Id = strconv.Itoa(i + 1)
+		}
+		doc := fileDoc{hit: &hit}
+		if hit.Source != nil {
+			if err = json.Unmarshal(*hit.Source, &doc.source); err != nil {
+				return nil, errors.Annotatef(err, "Could not read document %d of %s", i+1, name)
+			}
+		}
+		backend.docs = append(backend.docs, doc)
+	}
+	return backend, nil
+}
+
+// fileIndexName names the index of the file's documents after the
+// file (ex: dump for dump.ndjson.gz).
+func fileIndexName(path string) string {
+	name := filepath.Base(path)
+	for _, ext := range []string{".gz", ".zst", ".ndjson", ".jsonl", ".json"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// fileRequest is the part of a search body that's supported.
+type fileRequest struct {
+	Query        map[string]interface{} `json:"query"`
+	Size         *int                   `json:"size"`
+	From         int                    `json:"from"`
+	Sort         interface{}            `json:"sort"`
+	Source       interface{}            `json:"_source"`
+	SearchAfter  []interface{}          `json:"search_after"`
+	Aggregations json.RawMessage        `json:"aggregations"`
+	Aggs         json.RawMessage        `json:"aggs"`
+}
+
+// parseFileRequest reads the search body, which may be anything that
+// marshals as JSON.
+func parseFileRequest(body interface{}) (req fileRequest, err error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return req, errors.Annotate(err, "Could not encode the search")
+	}
+	if err = json.Unmarshal(data, &req); err != nil {
+		return req, errors.Annotate(err, "Could not read the search")
+	}
+	if len(req.Aggregations) != 0 || len(req.Aggs) != 0 {
+		return req, errors.New("Aggregations can't be run against files")
+	}
+	return req, nil
+}
+
+// size returns the number of hits asked for.
+func (r fileRequest) size() int {
+	if r.Size == nil {
+		return fileDefaultSize
+	}
+	return *r.Size
+}
+
+// search returns the hits matching the request in the order they're
+// sorted, with their sort values and the sources asked for.
+func (b *FileBackend) search(ctx context.Context, req fileRequest) (hits []*elastic.SearchHit, err error) {
+	match, err := compileFileQuery(req.Query)
+	if err != nil {
+		return hits, err
+	}
+	sorts, err := parseFileSorts(req.Sort)
+	if err != nil {
+		return hits, err
+	}
+	var docs []fileDoc
+	for i, d := range b.docs {
+		if i%fileCancelCheck == 0 && ctx.Err() != nil {
+			return hits, ctx.Err()
+		}
+		if match(d) {
+			docs = append(docs, d)
+		}
+	}
+	sorted := &fileDocSorter{docs: docs, sorts: sorts, values: make([][]interface{}, len(docs))}
+	for i := range docs {
+		sorted.values[i] = sorts.values(docs[i])
+	}
+	sort.Stable(sorted)
+
+	for i, d := range sorted.docs {
+		values := sorted.values[i]
+		if len(req.SearchAfter) != 0 && sorts.compare(values, req.SearchAfter) <= 0 {
+			continue
+		}
+		hit := *d.hit
+		if len(sorts) != 0 {
+			hit.Sort = values
+		}
+		if hit.Source, err = filterSource(d, req.Source); err != nil {
+			return hits, err
+		}
+		hits = append(hits, &hit)
+	}
+	return hits, nil
+}
+
+// fileResult creates the search result for a page of the hits.
+func fileResult(hits []*elastic.SearchHit, total int) *elastic.SearchResult {
+	return &elastic.SearchResult{
+		Hits: &elastic.SearchHits{TotalHits: int64(total), Hits: hits},
+	}
+}
+
+// page returns the hits from the offset, up to size of them.
+func page(hits []*elastic.SearchHit, from int, size int) []*elastic.SearchHit {
+	if from > len(hits) {
+		from = len(hits)
+	}
+	if from+size > len(hits) || size < 0 {
+		return hits[from:]
+	}
+	return hits[from : from+size]
+}
+
+// Search runs the search against the file's documents.
+func (b *FileBackend) Search(ctx context.Context, target SearchTarget, body interface{}) (*elastic.SearchResult, error) {
+	req, err := parseFileRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	hits, err := b.search(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return fileResult(page(hits, req.From, req.size()), len(hits)), nil
+}
+
+// Scroll starts a scroll of the search, or returns its next page.
+func (b *FileBackend) Scroll(ctx context.Context, target SearchTarget, body interface{}, keepAlive string, scrollID string) (*elastic.SearchResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if scrollID != "" {
+		scroll, ok := b.scrolls[scrollID]
+		if !ok {
+			return nil, errors.Errorf("No scroll with the id '%s'", scrollID)
+		}
+		hits := page(scroll.hits, 0, scroll.size)
+		scroll.hits = scroll.hits[len(hits):]
+		result := fileResult(hits, len(hits))
+		result.ScrollId = scrollID
+		return result, nil
+	}
+
+	req, err := parseFileRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	hits, err := b.search(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	b.nextScroll++
+	scrollID = fmt.Sprintf("%s#%d", b.Name, b.nextScroll)
+	first := page(hits, 0, req.size())
+	b.scrolls[scrollID] = &fileScroll{hits: hits[len(first):], size: req.size()}
+	result := fileResult(first, len(hits))
+	result.ScrollId = scrollID
+	return result, nil
+}
+
+// ClearScroll forgets the scrolls.
+func (b *FileBackend) ClearScroll(ctx context.Context, scrollIDs ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range scrollIDs {
+		delete(b.scrolls, id)
+	}
+	return nil
+}
+
+// Validate checks that the query is supported, the explanation of a
+// valid query is the query itself.
+func (b *FileBackend) Validate(ctx context.Context, target SearchTarget, body interface{}) (result ValidationResponse, err error) {
+	req, err := parseFileRequest(body)
+	if err != nil {
+		return result, err
+	}
+	result.Shards.Total = 1
+	explanation := ValidationExplanation{Index: fileIndexName(b.Name), Valid: true}
+	if _, err = compileFileQuery(req.Query); err != nil {
+		result.Shards.Failed = 1
+		explanation.Valid, explanation.Message = false, err.Error()
+	} else {
+		result.Valid = true
+		result.Shards.Successful = 1
+		query, _ := json.Marshal(req.Query)
+		explanation.Explanation = string(query)
+	}
+	result.Explanations = []ValidationExplanation{explanation}
+	return result, nil
+}
+
+// Count counts the documents matching the query.
+func (b *FileBackend) Count(ctx context.Context, target SearchTarget, body interface{}) (count int64, err error) {
+	req, err := parseFileRequest(body)
+	if err != nil {
+		return count, err
+	}
+	match, err := compileFileQuery(req.Query)
+	if err != nil {
+		return count, err
+	}
+	for i, d := range b.docs {
+		if i%fileCancelCheck == 0 && ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		if match(d) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Mapping infers the mapping of each index from the values of its
+// documents' fields.
+func (b *FileBackend) Mapping(ctx context.Context, target SearchTarget) (mapping map[string]interface{}, err error) {
+	mapping = make(map[string]interface{})
+	for _, d := range b.docs {
+		index, ok := mapping[d.hit.Index].(map[string]interface{})
+		if !ok {
+			index = map[string]interface{}{"mappings": map[string]interface{}{}}
+			mapping[d.hit.Index] = index
+		}
+		typ := d.hit.Type
+		if typ == "" {
+			typ = "doc"
+		}
+		types := index["mappings"].(map[string]interface{})
+		typeMapping, ok := types[typ].(map[string]interface{})
+		if !ok {
+			typeMapping = map[string]interface{}{"properties": map[string]interface{}{}}
+			types[typ] = typeMapping
+		}
+		inferProperties(typeMapping["properties"].(map[string]interface{}), d.source)
+	}
+	return mapping, nil
+}
+
+// inferProperties adds the fields of the source that the properties
+// don't have yet.
+func inferProperties(properties map[string]interface{}, source map[string]interface{}) {
+	for name, value := range source {
+		if values, ok := value.([]interface{}); ok {
+			if len(values) == 0 {
+				continue
+			}
+			value = values[0]
+		}
+		if object, ok := value.(map[string]interface{}); ok {
+			prop, ok := properties[name].(map[string]interface{})
+			if !ok {
+				prop = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+				properties[name] = prop
+			}
+			if sub, ok := prop["properties"].(map[string]interface{}); ok {
+				inferProperties(sub, object)
+			}
+			continue
+		}
+		if _, ok := properties[name]; ok || value == nil {
+			continue
+		}
+		properties[name] = map[string]interface{}{"type": inferType(value)}
+	}
+}
+
+// inferType returns the type a field with the value would be mapped
+// as.
+func inferType(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "long"
+		}
+		return "double"
+	case string:
+		if _, ok := parseFileTime(v); ok {
+			return "date"
+		}
+	}
+	return "string"
+}
+
+// filterSource returns the document's source with only the fields
+// asked for by the _source of the search (true, false, a field, a list
+// of them or the includes and excludes).
+func filterSource(d fileDoc, spec interface{}) (source *json.RawMessage, err error) {
+	var includes, excludes []interface{}
+	switch v := spec.(type) {
+	case nil:
+		return d.hit.Source, nil
+	case bool:
+		if v {
+			return d.hit.Source, nil
+		}
+		return nil, nil
+	case string:
+		includes = []interface{}{v}
+	case []interface{}:
+		includes = v
+	case map[string]interface{}:
+		includes = sourcePatterns(v, "includes", "include")
+		excludes = sourcePatterns(v, "excludes", "exclude")
+	}
+	if len(includes) == 0 && len(excludes) == 0 {
+		return d.hit.Source, nil
+	}
+
+	fields := make(map[string]interface{})
+	flattenFields("", d.source, fields)
+	kept := make(map[string]interface{})
+	for field, value := range fields {
+		if len(includes) != 0 && !matchSourcePattern(field, includes) {
+			continue
+		}
+		if matchSourcePattern(field, excludes) {
+			continue
+		}
+		kept[field] = value
+	}
+	data, err := json.Marshal(NestFields(kept))
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(data)
+	return &raw, nil
+}
+
+// sourcePatterns returns the patterns given by either of the keys.
+func sourcePatterns(spec map[string]interface{}, keys ...string) []interface{} {
+	for _, key := range keys {
+		switch v := spec[key].(type) {
+		case string:
+			return []interface{}{v}
+		case []interface{}:
+			return v
+		}
+	}
+	return nil
+}
+
+// matchSourcePattern checks if the field is, or is within, a field of
+// the patterns, which may have wildcards.
+func matchSourcePattern(field string, patterns []interface{}) bool {
+	for _, p := range patterns {
+		pattern, _ := p.(string)
+		switch {
+		case pattern == "":
+		case field == pattern, strings.HasPrefix(field, pattern+"."):
+			return true
+		case strings.Contains(pattern, "*") && wildcardRegexp(pattern).MatchString(field):
+			return true
+		}
+	}
+	return false
+}
+
+// flattenFields collects the values of the source's fields by their
+// dotted names, arrays are kept as values.
+func flattenFields(prefix string, source map[string]interface{}, fields map[string]interface{}) {
+	for name, value := range source {
+		if object, ok := value.(map[string]interface{}); ok && len(object) != 0 {
+			flattenFields(prefix+name+".", object, fields)
+			continue
+		}
+		fields[prefix+name] = value
+	}
+}
+
+// fileSort is a field that the documents are sorted by.
+type fileSort struct {
+	field string
+	asc   bool
+}
+
+// fileSorts are the sorts of a search, in order of precedence.
+type fileSorts []fileSort
+
+// parseFileSorts reads the sort of the search, sorting by _score or
+// _doc keeps the documents in the order of the file.
+func parseFileSorts(spec interface{}) (sorts fileSorts, err error) {
+	var list []interface{}
+	switch v := spec.(type) {
+	case nil:
+		return sorts, nil
+	case []interface{}:
+		list = v
+	default:
+		list = []interface{}{v}
+	}
+	for _, s := range list {
+		switch v := s.(type) {
+		case string:
+			sorts = append(sorts, fileSort{field: v, asc: v != "_score"})
+		case map[string]interface{}:
+			for field, order := range v {
+				fs := fileSort{field: field, asc: field != "_score"}
+				switch o := order.(type) {
+				case string:
+					fs.asc = strings.ToLower(o) == "asc"
+				case map[string]interface{}:
+					if o, ok := o["order"].(string); ok {
+						fs.asc = strings.ToLower(o) == "asc"
+					}
+				}
+				sorts = append(sorts, fs)
+			}
+		default:
+			return sorts, errors.Errorf("Sort '%v' isn't supported for files", s)
+		}
+	}
+	// The documents are all scored alike and start in the file's order.
+	kept := sorts[:0]
+	for _, s := range sorts {
+		if s.field != "_score" && s.field != "_doc" {
+			kept = append(kept, s)
+		}
+	}
+	return kept, nil
+}
+
+// values returns the document's values of the sort fields, nil for
+// missing values. The first value of an array is sorted by.
+func (sorts fileSorts) values(d fileDoc) (values []interface{}) {
+	for _, s := range sorts {
+		value, _ := d.values(s.field)
+		if len(value) == 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, value[0])
+	}
+	return values
+}
+
+// compare orders the sort values, missing values are last whatever the
+// order.
+func (sorts fileSorts) compare(a, b []interface{}) int {
+	for i, s := range sorts {
+		if i >= len(a) || i >= len(b) {
+			break
+		}
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return 1
+		case b[i] == nil:
+			return -1
+		}
+		c := compareValues(a[i], b[i])
+		if !s.asc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues orders numbers numerically, times chronologically and
+// anything else by its text.
+func compareValues(a, b interface{}) int {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	x, y := fmt.Sprint(a), fmt.Sprint(b)
+	if tx, ok := parseFileTime(x); ok {
+		if ty, ok := parseFileTime(y); ok {
+			switch {
+			case tx.Before(ty):
+				return -1
+			case tx.After(ty):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(x, y)
+}
+
+// fileDocSorter sorts the documents by their sort values.
+type fileDocSorter struct {
+	docs   []fileDoc
+	values [][]interface{}
+	sorts  fileSorts
+}
+
+func (s *fileDocSorter) Len() int { return len(s.docs) }
+func (s *fileDocSorter) Swap(i, j int) {
+	s.docs[i], s.docs[j] = s.docs[j], s.docs[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
+func (s *fileDocSorter) Less(i, j int) bool {
+	return s.sorts.compare(s.values[i], s.values[j]) < 0
+}
+
+// values returns the document's values of the field, the values of
+// arrays are given individually. The _id, _index, _type and _uid of
+// the hit are fields too and the values of all of the source's fields are
+// given for the default field ("" or _all).
+func (d fileDoc) values(field string) (values []interface{}, ok bool) {
+	switch field {
+	case "_id":
+		return []interface{}{d.hit.Id}, true
+	case "_index":
+		return []interface{}{d.hit.Index}, true
+	case "_type":
+		return []interface{}{d.hit.Type}, true
+	case "_uid":
+		return []interface{}{d.hit.Type + "#" + d.hit.Id}, true
+	case "", "_all", "*":
+		fields := make(map[string]interface{})
+		flattenFields("", d.source, fields)
+		for _, value := range fields {
+			values = appendValues(values, value)
+		}
+		return values, len(values) != 0
+	}
+	value, ok := fieldValue(d.source, field)
+	if !ok || value == nil {
+		return nil, false
+	}
+	values = appendValues(values, value)
+	return values, len(values) != 0
+}
+
+// appendValues appends the value, or each of the values of an array.
+func appendValues(values []interface{}, value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range v {
+			values = appendValues(values, item)
+		}
+	case map[string]interface{}:
+		data, _ := json.Marshal(v)
+		values = append(values, string(bytes.TrimSpace(data)))
+	default:
+		values = append(values, v)
+	}
+	return values
+}
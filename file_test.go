@@ -0,0 +1,211 @@
+package lgrep
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/olivere/elastic.v3"
+)
+
+const testDump = `{"@timestamp": "2016-04-29T13:58:59Z", "host": "web1", "status": 500, "message": "Disk full on /var"}
+{"@timestamp": "2016-04-29T13:59:10Z", "host": "web2", "status": 200, "message": "GET /index.html", "user": {"name": "ann"}}
+{"@timestamp": "2016-04-29T14:00:00Z", "host": "db1", "status": 500, "message": "Connection refused to web-1"}
+{"_index": "logs-1", "_type": "logs", "_id": "abc", "_source": {"@timestamp": "2016-04-29T14:01:00Z", "host": "web3", "status": 404, "message": "Not found", "tags": ["slow", "retry"]}}
+`
+
+// newTestFileBackend writes the test dump, gzipped when asked, and
+// reads it.
+func newTestFileBackend(t *testing.T, gzipped bool) *FileBackend {
+	dir, err := ioutil.TempDir("", "lgrep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dump.ndjson")
+	if gzipped {
+		path += ".gz"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gzipped {
+		gz := gzip.NewWriter(f)
+		gz.Write([]byte(testDump))
+		gz.Close()
+	} else {
+		f.WriteString(testDump)
+	}
+	f.Close()
+
+	backend, err := NewFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+// hosts returns the hosts of the results.
+func hosts(t *testing.T, results []Result) (hosts []string) {
+	for _, r := range results {
+		data, err := resultSource(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		host, _ := data["host"].(string)
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+func TestFileBackendSearch(t *testing.T) {
+	l := NewWithBackend(newTestFileBackend(t, true))
+	examples := map[string][]string{
+		"status:500":                       {"db1", "web1"},
+		"status:500 AND host:web*":         {"web1"},
+		"host:web? -status:200":            {"web3", "web1"},
+		"NOT status:200":                   {"web3", "db1", "web1"},
+		`"refused to web"`:                 {"db1"},
+		"web-1":                            {"db1"},
+		"DISK":                             {"web1"},
+		"status:[400 TO *]":                {"web3", "db1", "web1"},
+		"status:{200 TO 500}":              {"web3"},
+		"status:>=404":                     {"web3", "db1", "web1"},
+		"@timestamp:[* TO 2016-04-29]":     nil,
+		"@timestamp:<2016-04-29T14:00:00Z": {"web2", "web1"},
+		"host:/web[12]/":                   {"web2", "web1"},
+		"user.name:ann":                    {"web2"},
+		"tags:retry":                       {"web3"},
+		"_exists_:tags":                    {"web3"},
+		"_id:abc OR _id:1":                 {"web3", "web1"},
+		"host:wev1~1":                      {"web1"},
+		"(status:200 OR status:404) AND NOT host:web3": {"web2"},
+	}
+	for q, expected := range examples {
+		results, err := l.SimpleSearch(q, &SearchOptions{Size: 10, SortTime: SortDesc})
+		if err != nil {
+			t.Errorf("Searching '%s': %s", q, err)
+			continue
+		}
+		if found := hosts(t, results); !reflect.DeepEqual(found, expected) {
+			t.Errorf("Searching '%s' found %v, expected %v", q, found, expected)
+		}
+	}
+}
+
+func TestFileBackendOptions(t *testing.T) {
+	l := NewWithBackend(newTestFileBackend(t, false))
+
+	results, err := l.SimpleSearch("*", &SearchOptions{Size: 2, Sort: []SortField{{Field: "host", Asc: true}}})
+	if err != nil || !reflect.DeepEqual(hosts(t, results), []string{"db1", "web1"}) {
+		t.Errorf("Sorted by host %v: %v", hosts(t, results), err)
+	}
+
+	// Scrolled for the matches on the client.
+	match, _ := ParseFieldMatch("message=^[A-Z]")
+	spec := &SearchOptions{Size: MaxSearchSize + 1, SortTime: SortAsc, Matches: []FieldMatch{match}}
+	results, err = l.SimpleSearch("*", spec)
+	if err != nil || !reflect.DeepEqual(hosts(t, results), []string{"web1", "web2", "db1", "web3"}) {
+		t.Errorf("Scrolled %v: %v", hosts(t, results), err)
+	}
+
+	spec = &SearchOptions{Size: 10, SortTime: SortAsc, SearchAfter: []interface{}{"2016-04-29T13:59:10Z"}}
+	results, err = l.SimpleSearch("*", spec)
+	if err != nil || !reflect.DeepEqual(hosts(t, results), []string{"db1", "web3"}) {
+		t.Errorf("Searched after %v: %v", hosts(t, results), err)
+	}
+
+	results, err = l.SimpleSearch("host:web2", &SearchOptions{Size: 1, Fields: []string{"user.name"}})
+	if err != nil || len(results) != 1 || results[0].String() != `{"user":{"name":"ann"}}` {
+		t.Errorf("Searched for the fields %v: %v", results, err)
+	}
+
+	if count, err := l.SimpleCount("status:500", nil); err != nil || count != 2 {
+		t.Errorf("Counted %d: %v", count, err)
+	}
+	if _, err := l.SimpleTerms("*", "host", nil); err == nil {
+		t.Error("Expected aggregating to fail")
+	}
+
+	fields, err := l.Fields(nil)
+	expected := []string{"@timestamp", "host", "message", "status", "tags", "user", "user.name"}
+	if err != nil || !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Fields %v: %v", fields, err)
+	}
+}
+
+func TestFileBackendValidate(t *testing.T) {
+	l := NewWithBackend(newTestFileBackend(t, false))
+	if result, err := l.Validate("status:500", nil); err != nil || !result.Valid {
+		t.Errorf("Validated %t: %v", result.Valid, err)
+	}
+	_, err := l.ValidateSource(json.RawMessage(`{"query": {"geo_shape": {"location": {}}}}`), nil)
+	if err == nil || !strings.Contains(err.Error(), "geo_shape queries can't be run against files") {
+		t.Errorf("Expected an unsupported query, got %v", err)
+	}
+}
+
+func TestHitReader(t *testing.T) {
+	dump := testDump + `{"_index": "logs-1", "_type": "logs", "_id": "def"}
+{"_index": "logs-1", "_id": "ghi", "_source": null}
+{"host": "web4"
+`
+	r := NewHitReader(strings.NewReader(dump))
+	var hits []*elastic.SearchHit
+	for {
+		hit, err := r.Next()
+		if err == io.EOF {
+			t.Fatal("Expected the truncated document to be an error")
+		} else if err != nil {
+			if !strings.Contains(err.Error(), "document 7") {
+				t.Errorf("Expected the error to be of document 7: %s", err)
+			}
+			break
+		}
+		hits = append(hits, hit)
+	}
+	if len(hits) != 6 || r.N != 6 {
+		t.Fatalf("Read %d documents (%d), expected 6", len(hits), r.N)
+	}
+	if hit := hits[3]; hit.Index != "logs-1" || hit.Id != "abc" || hit.Source == nil {
+		t.Errorf("Expected the hit abc with its source, read %+v", hit)
+	}
+	// Hits without their source are kept as hits.
+	for _, hit := range hits[4:] {
+		if hit.Index != "logs-1" || hit.Id == "" || hit.Source != nil {
+			t.Errorf("Expected a hit without a source, read %+v", hit)
+		}
+	}
+}
+
+func TestParseDateMath(t *testing.T) {
+	now := time.Date(2016, 4, 29, 13, 58, 59, 0, time.UTC)
+	examples := map[string]time.Time{
+		"now":                       now,
+		"now-1h":                    now.Add(-time.Hour),
+		"now+2d/d":                  time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC),
+		"now/M":                     time.Date(2016, 4, 1, 0, 0, 0, 0, time.UTC),
+		"2016-04-01||+1M-1s":        time.Date(2016, 4, 30, 23, 59, 59, 0, time.UTC),
+		"2016-04-29T13:00:00Z":      time.Date(2016, 4, 29, 13, 0, 0, 0, time.UTC),
+		"2016-04-29T13:00:00.5Z":    time.Date(2016, 4, 29, 13, 0, 0, 5e8, time.UTC),
+		"2016-04-29T13:00:00+02:00": time.Date(2016, 4, 29, 11, 0, 0, 0, time.UTC),
+	}
+	for text, expected := range examples {
+		if parsed, ok := parseDateMath(text, now); !ok || !parsed.Equal(expected) {
+			t.Errorf("Parsed '%s' as %s (%t), expected %s", text, parsed, ok, expected)
+		}
+	}
+	for _, invalid := range []string{"now-h", "now*2", "yesterday"} {
+		if _, ok := parseDateMath(invalid, now); ok {
+			t.Errorf("Expected '%s' not to parse", invalid)
+		}
+	}
+}
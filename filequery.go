@@ -0,0 +1,617 @@
+package lgrep
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/juju/errors"
+)
+
+// fileDefaultFuzziness is the edit distance of fuzzy terms (term~)
+// that don't give one.
+const fileDefaultFuzziness = 2
+
+// fileTimeLayouts are the layouts of the times that are compared as
+// times rather than text.
+var fileTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// fileMatcher checks if a document of a file matches a query.
+type fileMatcher func(d fileDoc) bool
+
+// compileFileQuery creates the matcher of a query of the search API,
+// any filtered queries are first rewritten as bool queries. All
+// documents match a missing query.
+func compileFileQuery(query map[string]interface{}) (match fileMatcher, err error) {
+	if query == nil {
+		return matchAllFile, nil
+	}
+	rewritten, _ := rewriteFiltered(query)
+	return compileFileClause(rewritten)
+}
+
+// matchAllFile matches every document.
+func matchAllFile(d fileDoc) bool { return true }
+
+// compileFileClause creates the matcher of a query clause, an object
+// with the query's type as its only key.
+func compileFileClause(clause interface{}) (match fileMatcher, err error) {
+	query, ok := clause.(map[string]interface{})
+	if !ok || len(query) != 1 {
+		return nil, errors.Errorf("Query %s should be an object with the type of query as its key", jsonString(clause))
+	}
+	for kind, body := range query {
+		params, _ := body.(map[string]interface{})
+		switch kind {
+		case "match_all":
+			return matchAllFile, nil
+		case "constant_score":
+			inner, ok := params["filter"]
+			if !ok {
+				inner = params["query"]
+			}
+			return compileFileClause(inner)
+		case "bool":
+			return compileFileBool(params)
+		case "query_string":
+			return compileFileQueryString(params)
+		case "range":
+			return compileFileRange(params)
+		case "match":
+			// Any one of the words matches unless it's a phrase.
+			return compileFileFieldQuery(params, func(field string, value interface{}) LuceneQuery {
+				if body, ok := params[field].(map[string]interface{}); ok && body["type"] == "phrase" {
+					return &LucenePhrase{Field: field, Value: valueString(value)}
+				}
+				words := &LuceneBool{}
+				for _, word := range analyze(valueString(value)) {
+					words.Clauses = append(words.Clauses, LuceneClause{Query: &LuceneTerm{Field: field, Value: word, Raw: EscapeLucene(word)}})
+				}
+				return words
+			})
+		case "match_phrase":
+			return compileFileFieldQuery(params, func(field string, value interface{}) LuceneQuery {
+				return &LucenePhrase{Field: field, Value: valueString(value)}
+			})
+		case "term":
+			return compileFileFieldQuery(params, func(field string, value interface{}) LuceneQuery {
+				text := valueString(value)
+				return &LuceneTerm{Field: field, Value: text, Raw: EscapeLucene(text)}
+			})
+		case "exists":
+			field, _ := params["field"].(string)
+			if field == "" {
+				return nil, errors.New("exists query is missing the field")
+			}
+			return newLuceneMatcher(false).compile(&LuceneTerm{Field: "_exists_", Value: field}), nil
+		}
+		return nil, errors.Errorf("%s queries can't be run against files", kind)
+	}
+	return nil, nil
+}
+
+// compileFileBool creates the matcher of a bool query. At least one
+// should clause must match when there are no must or filter clauses,
+// unless minimum_should_match is given.
+func compileFileBool(params map[string]interface{}) (match fileMatcher, err error) {
+	clauses := make(map[string][]fileMatcher)
+	for _, occur := range []string{"must", "filter", "should", "must_not"} {
+		var list []interface{}
+		switch v := params[occur].(type) {
+		case nil:
+			continue
+		case []interface{}:
+			list = v
+		default:
+			list = []interface{}{v}
+		}
+		for _, c := range list {
+			m, err := compileFileClause(c)
+			if err != nil {
+				return nil, err
+			}
+			clauses[occur] = append(clauses[occur], m)
+		}
+	}
+	required := append(clauses["must"], clauses["filter"]...)
+	minShould := 0
+	if len(required) == 0 && len(clauses["should"]) != 0 {
+		minShould = 1
+	}
+	if v, ok := params["minimum_should_match"]; ok {
+		if minShould, err = strconv.Atoi(fmt.Sprint(v)); err != nil {
+			return nil, errors.Errorf("minimum_should_match '%v' can't be used with files, only a number of clauses", v)
+		}
+	}
+	return func(d fileDoc) bool {
+		for _, m := range required {
+			if !m(d) {
+				return false
+			}
+		}
+		for _, m := range clauses["must_not"] {
+			if m(d) {
+				return false
+			}
+		}
+		matched := 0
+		for _, m := range clauses["should"] {
+			if matched >= minShould {
+				break
+			}
+			if m(d) {
+				matched++
+			}
+		}
+		return matched >= minShould
+	}, nil
+}
+
+// compileFileQueryString creates the matcher of a query_string query,
+// the lucene query is parsed with ParseLucene and errors are given as
+// Elasticsearch gives them.
+func compileFileQueryString(params map[string]interface{}) (match fileMatcher, err error) {
+	q, _ := params["query"].(string)
+	query, err := ParseLucene(q)
+	if err != nil {
+		return nil, errors.Errorf("Cannot parse '%s': %s", q, err)
+	}
+	op, _ := params["default_operator"].(string)
+	return newLuceneMatcher(strings.EqualFold(op, "and")).compile(query), nil
+}
+
+// compileFileRange creates the matcher of a range query, given with
+// gt, gte, lt and lte or from, to, include_lower and include_upper.
+func compileFileRange(params map[string]interface{}) (match fileMatcher, err error) {
+	if len(params) != 1 {
+		return nil, errors.New("range query should have a single field")
+	}
+	for field, body := range params {
+		bounds, ok := body.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("range of %s should be an object", field)
+		}
+		rng := &LuceneRange{Field: field, Lower: "*", Upper: "*", IncludeLower: true, IncludeUpper: true}
+		for key, value := range bounds {
+			if value == nil {
+				continue
+			}
+			switch key {
+			case "from", "gte":
+				rng.Lower = boundString(value)
+			case "gt":
+				rng.Lower, rng.IncludeLower = boundString(value), false
+			case "to", "lte":
+				rng.Upper = boundString(value)
+			case "lt":
+				rng.Upper, rng.IncludeUpper = boundString(value), false
+			}
+		}
+		if include, ok := bounds["include_lower"].(bool); ok {
+			rng.IncludeLower = include
+		}
+		if include, ok := bounds["include_upper"].(bool); ok {
+			rng.IncludeUpper = include
+		}
+		return newLuceneMatcher(false).compile(rng), nil
+	}
+	return nil, nil
+}
+
+// boundString formats a bound of a range as it's given in lucene.
+func boundString(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// compileFileFieldQuery creates the matcher of a query of a field's
+// value ({"field": value} or {"field": {"query": value}}) from the
+// lucene query made for it.
+func compileFileFieldQuery(params map[string]interface{}, lucene func(field string, value interface{}) LuceneQuery) (match fileMatcher, err error) {
+	if len(params) != 1 {
+		return nil, errors.New("query should have a single field")
+	}
+	for field, value := range params {
+		if body, ok := value.(map[string]interface{}); ok {
+			if value, ok = body["query"]; !ok {
+				value = body["value"]
+			}
+		}
+		if value == nil {
+			return nil, errors.Errorf("query of %s is missing its value", field)
+		}
+		return newLuceneMatcher(false).compile(lucene(field, value)), nil
+	}
+	return nil, nil
+}
+
+// jsonString formats the value as JSON for errors.
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// luceneMatcher evaluates lucene queries against the documents of a
+// file. Text is compared as Elasticsearch's standard analyzer would,
+// case insensitively and by its words, so a term matches a field with
+// the term as one of its words and a phrase matches a field with its
+// words in order.
+type luceneMatcher struct {
+	// and requires the clauses without an operator to match rather
+	// than any one of them (default_operator AND).
+	and bool
+	// now is the time that date math (now-1h) is relative to.
+	now     time.Time
+	regexps map[string]*regexp.Regexp
+}
+
+func newLuceneMatcher(and bool) *luceneMatcher {
+	return &luceneMatcher{and: and, now: time.Now(), regexps: make(map[string]*regexp.Regexp)}
+}
+
+// compile creates the matcher of the lucene query.
+func (m *luceneMatcher) compile(q LuceneQuery) fileMatcher {
+	return func(d fileDoc) bool {
+		return m.match(q, d)
+	}
+}
+
+// match evaluates the query against the document.
+func (m *luceneMatcher) match(q LuceneQuery, d fileDoc) bool {
+	switch v := q.(type) {
+	case *LuceneBool:
+		return m.matchBool(v, d)
+	case *LuceneTerm:
+		if v.Field == "_exists_" {
+			_, ok := d.values(v.Value)
+			return ok
+		}
+		return m.matchValues(d, v.Field, func(value string) bool { return m.matchTerm(v, value) })
+	case *LucenePhrase:
+		words := analyze(v.Value)
+		return m.matchValues(d, v.Field, func(value string) bool {
+			return strings.EqualFold(value, v.Value) || containsWords(analyze(value), words)
+		})
+	case *LuceneRange:
+		values, _ := d.values(v.Field)
+		for _, value := range values {
+			if m.inRange(v, value) {
+				return true
+			}
+		}
+		return false
+	case *LuceneRegexp:
+		re := m.regexp("^(?:"+v.Pattern+")$", v.Pattern)
+		return re != nil && m.matchValues(d, v.Field, func(value string) bool {
+			return re.MatchString(value) || matchWord(analyze(value), re.MatchString)
+		})
+	}
+	return false
+}
+
+// matchBool evaluates the clauses of the boolean query, a query with
+// only prohibited clauses matches the documents that don't match them.
+func (m *luceneMatcher) matchBool(q *LuceneBool, d fileDoc) bool {
+	required, should, matched := false, false, false
+	for _, c := range q.Clauses {
+		occur := c.Occur
+		if occur == OccurShould && m.and {
+			occur = OccurMust
+		}
+		switch occur {
+		case OccurMust:
+			required = true
+			if !m.match(c.Query, d) {
+				return false
+			}
+		case OccurMustNot:
+			if m.match(c.Query, d) {
+				return false
+			}
+		case OccurShould:
+			should = true
+			matched = matched || m.match(c.Query, d)
+		}
+	}
+	return required || !should || matched
+}
+
+// matchValues checks each of the document's values of the field, as
+// text, with the function.
+func (m *luceneMatcher) matchValues(d fileDoc, field string, match func(value string) bool) bool {
+	values, _ := d.values(field)
+	for _, value := range values {
+		if match(valueString(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTerm checks the term against the value, or its words.
+func (m *luceneMatcher) matchTerm(term *LuceneTerm, value string) bool {
+	switch {
+	case term.Wildcard:
+		re := m.regexp("", term.Raw)
+		return re.MatchString(value) || matchWord(analyze(value), re.MatchString)
+	case term.Fuzzy != "":
+		distance, err := strconv.Atoi(strings.TrimPrefix(term.Fuzzy, "~"))
+		if err != nil {
+			distance = fileDefaultFuzziness
+		}
+		word := strings.ToLower(term.Value)
+		return matchWord(analyze(value), func(w string) bool { return editDistance(w, word) <= distance })
+	case strings.EqualFold(value, term.Value):
+		return true
+	}
+	if f, err := strconv.ParseFloat(term.Value, 64); err == nil {
+		if v, err := strconv.ParseFloat(value, 64); err == nil && f == v {
+			return true
+		}
+	}
+	// Terms with many words (web-1) are searched for as phrases.
+	return containsWords(analyze(value), analyze(term.Value))
+}
+
+// regexp compiles the regular expression (or the wildcard pattern when
+// the expression isn't given) once, invalid expressions are nil.
+func (m *luceneMatcher) regexp(expr string, wildcard string) *regexp.Regexp {
+	key := expr + "\x00" + wildcard
+	if re, ok := m.regexps[key]; ok {
+		return re
+	}
+	var re *regexp.Regexp
+	if expr != "" {
+		re, _ = regexp.Compile(expr)
+	} else {
+		re = wildcardRegexp(wildcard)
+	}
+	m.regexps[key] = re
+	return re
+}
+
+// inRange checks that the value is within the range. Numbers are
+// compared numerically, times chronologically (date math such as
+// now-1h/d is supported) and anything else by its text.
+func (m *luceneMatcher) inRange(rng *LuceneRange, value interface{}) bool {
+	if rng.Lower != "*" {
+		c, ok := m.compareBound(value, rng.Lower)
+		if !ok || c < 0 || (c == 0 && !rng.IncludeLower) {
+			return false
+		}
+	}
+	if rng.Upper != "*" {
+		c, ok := m.compareBound(value, rng.Upper)
+		if !ok || c > 0 || (c == 0 && !rng.IncludeUpper) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareBound compares the value to the bound of a range, the values
+// that can't be compared to it aren't ok.
+func (m *luceneMatcher) compareBound(value interface{}, bound string) (c int, ok bool) {
+	if f, ok := value.(float64); ok {
+		b, err := strconv.ParseFloat(bound, 64)
+		if err != nil {
+			return 0, false
+		}
+		return compareValues(f, b), true
+	}
+	text := valueString(value)
+	if t, ok := parseFileTime(text); ok {
+		b, ok := parseDateMath(bound, m.now)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case t.Before(b):
+			return -1, true
+		case t.After(b):
+			return 1, true
+		}
+		return 0, true
+	}
+	if v, err := strconv.ParseFloat(text, 64); err == nil {
+		if b, err := strconv.ParseFloat(bound, 64); err == nil {
+			return compareValues(v, b), true
+		}
+	}
+	return strings.Compare(text, bound), true
+}
+
+// valueString formats a value of a field as text.
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// analyze splits the text into its lower case words.
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsWords checks that the words appear, in order, among the
+// text's words.
+func containsWords(text []string, words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	for i := 0; i+len(words) <= len(text); i++ {
+		found := true
+		for j := range words {
+			if text[i+j] != words[j] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWord checks if any one of the words matches.
+func matchWord(words []string, match func(string) bool) bool {
+	for _, w := range words {
+		if match(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardRegexp creates a case insensitive expression matching the
+// pattern's * (any characters) and ? (any one character) wildcards,
+// backslash escapes the character that follows it.
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	expr := "(?is)^"
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			expr += regexp.QuoteMeta(string(r))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			expr += ".*"
+		case r == '?':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(string(r))
+		}
+	}
+	return regexp.MustCompile(expr + "$")
+}
+
+// parseFileTime parses the text as a time, if it is one.
+func parseFileTime(text string) (t time.Time, ok bool) {
+	if len(text) < len("2006-01-02") || text[0] < '0' || text[0] > '9' {
+		return t, false
+	}
+	for _, layout := range fileTimeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+	}
+	return t, false
+}
+
+// parseDateMath parses a time given with Elasticsearch's date math,
+// an anchor (now or a time followed by ||) that's added to (+1h),
+// subtracted from (-1d) and rounded (/d) in order.
+func parseDateMath(text string, now time.Time) (t time.Time, ok bool) {
+	rest := ""
+	switch {
+	case strings.HasPrefix(text, "now"):
+		t, rest = now, text[len("now"):]
+	case strings.Contains(text, "||"):
+		parts := strings.SplitN(text, "||", 2)
+		if t, ok = parseFileTime(parts[0]); !ok {
+			return t, false
+		}
+		rest = parts[1]
+	default:
+		return parseFileTime(text)
+	}
+	for rest != "" {
+		op := rest[0]
+		rest = rest[1:]
+		n := 1
+		if op == '+' || op == '-' {
+			digits := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+			if digits <= 0 {
+				return t, false
+			}
+			n, _ = strconv.Atoi(rest[:digits])
+			rest = rest[digits:]
+			if op == '-' {
+				n = -n
+			}
+		} else if op != '/' {
+			return t, false
+		}
+		if rest == "" {
+			return t, false
+		}
+		unit := rest[0]
+		rest = rest[1:]
+		if op == '/' {
+			if t, ok = roundDate(t, unit); !ok {
+				return t, false
+			}
+			continue
+		}
+		if t, ok = addDate(t, unit, n); !ok {
+			return t, false
+		}
+	}
+	return t, true
+}
+
+// addDate adds n of the date math unit to the time.
+func addDate(t time.Time, unit byte, n int) (time.Time, bool) {
+	switch unit {
+	case 'y':
+		return t.AddDate(n, 0, 0), true
+	case 'M':
+		return t.AddDate(0, n, 0), true
+	case 'w':
+		return t.AddDate(0, 0, 7*n), true
+	case 'd':
+		return t.AddDate(0, 0, n), true
+	case 'h', 'H':
+		return t.Add(time.Duration(n) * time.Hour), true
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), true
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), true
+	}
+	return t, false
+}
+
+// roundDate rounds the time down to the date math unit.
+func roundDate(t time.Time, unit byte) (time.Time, bool) {
+	switch unit {
+	case 'y':
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location()), true
+	case 'M':
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()), true
+	case 'w':
+		days := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, t.Location()), true
+	case 'd':
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), true
+	case 'h', 'H':
+		return t.Truncate(time.Hour), true
+	case 'm':
+		return t.Truncate(time.Minute), true
+	case 's':
+		return t.Truncate(time.Second), true
+	}
+	return t, false
+}
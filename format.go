@@ -196,6 +196,28 @@ func FieldTokens(t string) (tokens []string) {
 	return tokens
 }
 
+// FieldPaths extracts the full dotted paths of the fields that are
+// used in the template (.t1.t2|ftime "15:04" => t1.t2), each path is
+// returned once.
+func FieldPaths(t string) (paths []string) {
+	t = CurlyFormat(t)
+	matcher := regexp.MustCompile(`{{([^{}]+)}}`)
+	seen := make(map[string]bool)
+	for _, match := range matcher.FindAllStringSubmatch(t, -1) {
+		for _, word := range strings.FieldsFunc(match[1], func(r rune) bool {
+			return unicode.IsSpace(r) || r == '|' || r == '(' || r == ')'
+		}) {
+			path := strings.Trim(word, ".")
+			if !strings.HasPrefix(word, ".") || path == "" || seen[path] {
+				continue
+			}
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
 // strftime is a template formatting function
 func strftime(format string, d interface{}) string {
 	var t time.Time
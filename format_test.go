@@ -1,6 +1,7 @@
 package lgrep
 
 import (
+	"reflect"
 	"sort"
 	"testing"
 )
@@ -48,3 +49,18 @@ func TestFieldTokens(t *testing.T) {
 		}
 	}
 }
+
+func TestFieldPaths(t *testing.T) {
+	testData := map[string][]string{
+		".one .two.three":                        {"one", "two.three"},
+		"{{.one}} {{ .one }}":                    {"one"},
+		`{{.timestamp|ftime "15:04"}} {{.host}}`: {"timestamp", "host"},
+		"{{.}} {{.beat.host.raw}}":               {"beat.host.raw"},
+	}
+	for s, expected := range testData {
+		paths := FieldPaths(s)
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("FieldPaths('%s') => %q (expected %q)", s, paths, expected)
+		}
+	}
+}
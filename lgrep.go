@@ -1,9 +1,11 @@
 package lgrep
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"time"
 
@@ -23,26 +25,83 @@ var (
 
 // LGrep holds state and configuration for running queries against the
 type LGrep struct {
-	// Client is the backing interface that searches elasticsearch
+	// Client is the backing interface that searches elasticsearch,
+	// it's nil when another backend is searched.
 	*elastic.Client
+	// Backend runs the searches, the Client when not given.
+	Backend Backend
 	// Endpoint to use when working with Elasticsearch
 	Endpoint string
+	// Version is the version of the server at the endpoint, requests
+	// are made compatible with it.
+	Version ServerVersion
 }
 
-// New creates a new lgrep client.
+// New creates a new lgrep client, the server's version is detected so
+// that it may be spoken to in its API.
 func New(endpoint string) (lg LGrep, err error) {
+	return NewWithTransport(endpoint, nil)
+}
+
+// NewWithTransport creates a new lgrep client whose requests are sent
+// with the transport (http.DefaultTransport when nil), such as a
+// Recorder or Replayer.
+func NewWithTransport(endpoint string, transport http.RoundTripper) (lg LGrep, err error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
 	lg = LGrep{Endpoint: endpoint}
-	lg.Client, err = elastic.NewClient(elastic.SetURL(endpoint))
-	return lg, err
+	lg.Version, err = DetectVersion(endpoint, versionClient(transport))
+	if err != nil {
+		return lg, err
+	}
+	log.Debugf("Connected to %s", lg.Version)
+	options := []elastic.ClientOptionFunc{
+		elastic.SetURL(endpoint),
+		elastic.SetHttpClient(&http.Client{Transport: NewCompatTransport(lg.Version, transport)}),
+	}
+	// The nodes of 5.x and later can't be sniffed by the client.
+	if lg.Version.APIVersion() >= 5 {
+		options = append(options, elastic.SetSniff(false))
+	}
+	lg.Client, err = elastic.NewClient(options...)
+	if err != nil {
+		return lg, err
+	}
+	lg.Backend = NewElasticBackend(lg.Client)
+	return lg, nil
+}
+
+// NewWithBackend creates a new lgrep client that searches the backend.
+func NewWithBackend(backend Backend) LGrep {
+	return LGrep{Backend: backend}
+}
+
+// backend returns the backend that's searched.
+func (l LGrep) backend() Backend {
+	if l.Backend != nil {
+		return l.Backend
+	}
+	return NewElasticBackend(l.Client)
+}
+
+// TieBreaker returns the field of the documents' unique ids that the
+// server can sort on (see SearchOptions.TieBreaker), _uid before
+// Elasticsearch 6 as _id can't be sorted on. Elasticsearch 8 only sorts
+// on _id when indices.id_field_data.enabled is set.
+func (l LGrep) TieBreaker() string {
+	if api := l.Version.APIVersion(); api != 0 && api < 6 {
+		return "_uid"
+	}
+	return "_id"
 }
 
 // SimpleSearchStream configures and executes a search stream using a lucene query.
 func (l LGrep) SimpleSearchStream(q string, spec *SearchOptions) (stream *SearchStream, err error) {
-	if q == "" {
+	if q == "" && (spec == nil || len(spec.Patterns) == 0) {
 		return nil, ErrEmptySearch
 	}
-	search, source := l.NewSearch()
-	search = SearchWithLucene(search, q)
+	source := elastic.NewSearchSource()
 	if spec != nil {
 		// If user wants 0 then they're really not looking to get any
 		// results, don't execute.
@@ -53,7 +112,9 @@ func (l LGrep) SimpleSearchStream(q string, spec *SearchOptions) (stream *Search
 		spec = &DefaultSpec
 	}
 
-	spec.configureSearch(search)
+	patterns := spec.lucenePatterns(q)
+	source.Query(LucenePatternsQuery(patterns, spec.PatternsAll, spec.Invert))
+	spec.configureSource(source)
 
 	// Spit out the query that will be sent.
 	if spec.QueryDebug {
@@ -66,13 +127,62 @@ func (l LGrep) SimpleSearchStream(q string, spec *SearchOptions) (stream *Search
 
 	if !spec.QuerySkipValidate {
 		log.Debug("Validating query..")
+		// Catch syntax errors locally where their position can be
+		// reported before involving the server.
+		for _, p := range patterns {
+			if _, err := ParseLucene(p); err != nil {
+				return nil, err
+			}
+		}
 		_, err := l.validate(source, *spec)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return l.execute(search, source, *spec)
+	return l.execute(source, *spec)
+}
+
+// NewLuceneSearch initializes a new search for the lucene query (and
+// any patterns) given by the spec without executing it, such as for
+// searches that aggregate over the matching documents.
+func (l LGrep) NewLuceneSearch(q string, spec *SearchOptions) (source *elastic.SearchSource, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	patterns := spec.lucenePatterns(q)
+	if len(patterns) == 0 {
+		return nil, ErrEmptySearch
+	}
+	for _, p := range patterns {
+		if _, err = ParseLucene(p); err != nil {
+			return nil, err
+		}
+	}
+	source = elastic.NewSearchSource()
+	source.Query(LucenePatternsQuery(patterns, spec.PatternsAll, spec.Invert))
+	return source, nil
+}
+
+// aggregate runs the search of the spec's indices for its aggregations
+// alone, no documents are returned.
+func (l LGrep) aggregate(source *elastic.SearchSource, spec *SearchOptions) (aggs elastic.Aggregations, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	source.Size(0)
+	body, err := source.Source()
+	if err != nil {
+		return aggs, err
+	}
+	if spec.QueryDebug {
+		printQueryDebug(os.Stderr, body)
+	}
+	res, err := l.backend().Search(context.TODO(), spec.target(), body)
+	if err != nil {
+		return aggs, err
+	}
+	return res.Aggregations, nil
 }
 
 // SimpleSearch runs a lucene search configured by the SearchOption
@@ -88,29 +198,13 @@ func (l LGrep) SimpleSearch(q string, spec *SearchOptions) (results []Result, er
 // SearchWithSourceStream configures with a raw query and executes a
 // search stream that can be read.
 func (l LGrep) SearchWithSourceStream(raw interface{}, spec *SearchOptions) (stream *SearchStream, err error) {
-	search, _ := l.NewSearch()
 	if spec == nil {
 		spec = &DefaultSpec
 	}
-
-	spec.configureSearch(search)
-	var query elastic.Query
-	switch v := raw.(type) {
-	case json.RawMessage:
-		query, err = QueryMapFromJSON(v)
-	case []byte:
-		data := json.RawMessage(v)
-		query, err = QueryMapFromJSON(data)
-	case map[string]interface{}:
-		query = QueryMap(v)
-	case QueryMap:
-		query = v
-	default:
-		log.Fatalf("SearchWithSource does not support type '%T' at this time.", v)
+	query, err := queryFromRaw(raw)
+	if err != nil {
+		return nil, err
 	}
-	// Set the search source to the provided raw one.
-	source, _ := query.Source()
-	search.Source(source)
 
 	if spec.QueryDebug {
 		printQueryDebug(os.Stderr, query)
@@ -126,7 +220,25 @@ func (l LGrep) SearchWithSourceStream(raw interface{}, spec *SearchOptions) (str
 		}
 	}
 
-	return l.execute(search, query, *spec)
+	return l.execute(query, *spec)
+}
+
+// queryFromRaw transforms the supported raw query types into a query.
+func queryFromRaw(raw interface{}) (query elastic.Query, err error) {
+	switch v := raw.(type) {
+	case json.RawMessage:
+		query, err = QueryMapFromJSON(v)
+	case []byte:
+		data := json.RawMessage(v)
+		query, err = QueryMapFromJSON(data)
+	case map[string]interface{}:
+		query = QueryMap(v)
+	case QueryMap:
+		query = v
+	default:
+		err = errors.Errorf("SearchWithSource does not support type '%T' at this time.", v)
+	}
+	return query, err
 }
 
 // SearchWithSource may be used to provide a pre-contstructed json
@@ -176,7 +288,8 @@ func (l LGrep) SearchTimerange(search string, count int, t1 time.Time, t2 time.T
 }
 
 // NewSearch initializes a new search object along with a func to
-// debug the resulting query to be sent.
+// debug the resulting query to be sent, the search is run with the
+// Elasticsearch client rather than the backend.
 func (l LGrep) NewSearch() (search *elastic.SearchService, source *elastic.SearchSource) {
 	source = elastic.NewSearchSource()
 	search = l.Client.Search().SearchSource(source)
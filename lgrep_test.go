@@ -1,12 +1,16 @@
-package lgrep
+package lgrep_test
 
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"testing"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
 )
 
 const (
@@ -26,14 +30,39 @@ func init() {
 	log.SetLevel(log.DebugLevel)
 }
 
-var TestEndpoint = "http://localhost:9200"
+// TestEndpoint is the fake server that's searched by the tests.
+var TestEndpoint string
+
+const testFixturesPath = "./test/fixtures"
+
+func TestMain(m *testing.M) {
+	server := lgreptest.NewServer()
+	for _, fixture := range []string{"journald.ndjson", "inbound.ndjson"} {
+		if err := server.Load(testFixturesPath + "/" + fixture); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Enough documents to be scrolled through.
+	for i := 0; i < lgrep.MaxSearchSize+10; i++ {
+		server.Index("journald-2016.04.30", "journald", fmt.Sprintf("bulk%d", i), map[string]interface{}{
+			"@timestamp": "2016-04-30T00:00:00Z",
+			"type":       "journald",
+			"host":       "web1",
+			"message":    fmt.Sprintf("Message %d", i),
+		})
+	}
+	TestEndpoint = server.URL
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
 
 func TestSearch(t *testing.T) {
-	l, err := New(TestEndpoint)
+	l, err := lgrep.New(TestEndpoint)
 	if err != nil {
 		t.Fatalf("Client error: %s", err)
 	}
-	opts := &SearchOptions{Size: 10}
+	opts := &lgrep.SearchOptions{Size: 10}
 	docs, err := l.SimpleSearch("*", opts)
 	if err != nil {
 		t.Fatalf("Error running search: %s", err)
@@ -44,11 +73,11 @@ func TestSearch(t *testing.T) {
 }
 
 func TestLargeSearch(t *testing.T) {
-	l, err := New(TestEndpoint)
+	l, err := lgrep.New(TestEndpoint)
 	if err != nil {
 		t.Fatalf("Client error: %s", err)
 	}
-	opts := &SearchOptions{Size: MaxSearchSize + 10, Index: "journald-*"}
+	opts := &lgrep.SearchOptions{Size: lgrep.MaxSearchSize + 10, Index: "journald-*"}
 	docs, err := l.SimpleSearch("*", opts)
 	if err != nil {
 		t.Fatalf("Error running search: %s", err)
@@ -60,11 +89,11 @@ func TestLargeSearch(t *testing.T) {
 
 func TestSearchFormat(t *testing.T) {
 	expected := "network"
-	l, err := New(TestEndpoint)
+	l, err := lgrep.New(TestEndpoint)
 	if err != nil {
 		t.Fatalf("Client error: %s", err)
 	}
-	opts := &SearchOptions{Size: 1}
+	opts := &lgrep.SearchOptions{Size: 1}
 	docs, err := l.SimpleSearch("type:"+expected, opts)
 	if err != nil {
 		t.Fatalf("Error running search: %s", err)
@@ -72,11 +101,11 @@ func TestSearchFormat(t *testing.T) {
 	if len(docs) != 1 {
 		t.Fatalf("Search should have retrieved 1 docs as specified, returned %d", len(docs))
 	}
-	msgs1, err := Format(docs, "{{.type}}")
+	msgs1, err := lgrep.Format(docs, "{{.type}}")
 	if err != nil {
 		t.Fatal(err)
 	}
-	msgs2, err := Format(docs, ".type")
+	msgs2, err := lgrep.Format(docs, ".type")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -87,11 +116,11 @@ func TestSearchFormat(t *testing.T) {
 
 func TestSearchFields(t *testing.T) {
 	docType := "journald"
-	l, err := New(TestEndpoint)
+	l, err := lgrep.New(TestEndpoint)
 	if err != nil {
 		t.Fatalf("Client error: %s", err)
 	}
-	opts := &SearchOptions{Size: 1, Fields: []string{"type"}}
+	opts := &lgrep.SearchOptions{Size: 1, Fields: []string{"type"}}
 	results, err := l.SimpleSearch("type:"+docType, opts)
 	if err != nil {
 		t.Fatalf("Error running search: %s", err)
@@ -99,7 +128,7 @@ func TestSearchFields(t *testing.T) {
 	if len(results) != 1 {
 		t.Fatalf("Search should have retrieved 1 results as specified, returned %d", len(results))
 	}
-	msgs1, err := Format(results, "{{.type}}")
+	msgs1, err := lgrep.Format(results, "{{.type}}")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -112,12 +141,12 @@ func TestSearchFields(t *testing.T) {
 }
 
 func TestRawResultQuery(t *testing.T) {
-	l, err := New(TestEndpoint)
+	l, err := lgrep.New(TestEndpoint)
 	if err != nil {
 		t.Fatalf("Client error: %s", err)
 	}
 
-	opts := &SearchOptions{Size: 1, RawResult: true}
+	opts := &lgrep.SearchOptions{Size: 1, RawResult: true}
 	results, err := l.SimpleSearch("*", opts)
 	if err != nil {
 		t.Fatalf("Error performing search: %s", err)
@@ -125,7 +154,7 @@ func TestRawResultQuery(t *testing.T) {
 	if len(results) != opts.Size {
 		t.Fatalf("Number of results %d was not the expected amount %d.", len(results), opts.Size)
 	}
-	if hit, ok := results[0].(HitResult); ok {
+	if hit, ok := results[0].(lgrep.HitResult); ok {
 		if hit.Id == "" {
 			t.Fatal("Raw result should have had an ID")
 		}
@@ -142,49 +171,49 @@ func TestValidateQuery(t *testing.T) {
 	AnyError = errors.New("any")
 
 	expectations := []struct {
-		spec    SearchOptions
+		spec    lgrep.SearchOptions
 		search  string      // lucene search
 		query   interface{} // json search
 		invalid error       // expect to be invalid
 		desc    string
 	}{
 		// Valid searches
-		{SearchOptions{Index: "*-*", Type: "journald"}, "*", nil, Valid,
+		{lgrep.SearchOptions{Index: "*-*", Type: "journald"}, "*", nil, Valid,
 			"*-* pattern and * search with type"},
-		{SearchOptions{Index: "*-*"}, "*", nil, Valid,
+		{lgrep.SearchOptions{Index: "*-*"}, "*", nil, Valid,
 			"*-* pattern and * search with no type"},
-		{SearchOptions{}, "*", nil, Valid,
+		{lgrep.SearchOptions{}, "*", nil, Valid,
 			"loose * query"},
 
 		// TODO: Fix validation for raw json files
-		// {SearchOptions{Type: "journald"}, "", testJSONQuery,
+		// {lgrep.SearchOptions{Type: "journald"}, "", testJSONQuery,
 		// 	Valid,
 		// 	"using valid json"},
 
 		// Strange but true cases
-		{SearchOptions{Type: "nonexistent"}, "*", nil, Valid,
+		{lgrep.SearchOptions{Type: "nonexistent"}, "*", nil, Valid,
 			"querying a nonexistent type"},
 
 		// Invalid searches
-		{SearchOptions{Index: "nonexistent"}, "*", nil, ErrInvalidIndex,
+		{lgrep.SearchOptions{Index: "nonexistent"}, "*", nil, lgrep.ErrInvalidIndex,
 			"querying nonexistent index"},
-		{SearchOptions{}, "", []byte(`{]`), AnyError,
+		{lgrep.SearchOptions{}, "", []byte(`{]`), AnyError,
 			"using bad json"},
-		{SearchOptions{}, "", []byte(`{"key": "value"}`), AnyError,
+		{lgrep.SearchOptions{}, "", []byte(`{"key": "value"}`), AnyError,
 			"using incorrect query properties"},
-		{SearchOptions{}, "", []byte(`{}`), AnyError,
+		{lgrep.SearchOptions{}, "", []byte(`{}`), AnyError,
 			"using empty json"},
-		{SearchOptions{}, `NOT`, nil, ErrInvalidLuceneSyntax,
+		{lgrep.SearchOptions{}, `NOT`, nil, lgrep.ErrInvalidLuceneSyntax,
 			"just a NOT, invalid lucene syntax"},
 	}
 
-	l, err := New(TestEndpoint)
+	l, err := lgrep.New(TestEndpoint)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	for _, testcase := range expectations {
-		var result ValidationResponse
+		var result lgrep.ValidationResponse
 		explain := func() {
 			repeat := 0
 			for i, ex := range result.Explanations {
@@ -207,10 +236,10 @@ func TestValidateQuery(t *testing.T) {
 		search, source := l.NewSearch()
 		// Lucene search specified for case
 		if testcase.search != "" {
-			SearchWithLucene(search, testcase.search)
-			result, err = l.validate(source, testcase.spec)
+			lgrep.SearchWithLucene(search, testcase.search)
+			result, err = l.ValidateBody(source, testcase.spec)
 		} else if testcase.query != nil {
-			result, err = l.validate(testcase.query, testcase.spec)
+			result, err = l.ValidateBody(testcase.query, testcase.spec)
 		}
 
 		if err != nil {
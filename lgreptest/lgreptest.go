@@ -0,0 +1,495 @@
+// Package lgreptest provides a fake Elasticsearch server for testing
+// code that searches with lgrep, without a cluster to search.
+//
+// The server holds the documents it's given in memory and answers
+// enough of the Elasticsearch 2.x API for lgrep: searches, scrolls,
+// validations, counts and mappings of the indices and types given in
+// the paths, and bulk indexing into them. The queries are evaluated as lgrep's FileBackend
+// evaluates them, aggregations aren't supported.
+//
+//	server := lgreptest.NewServer()
+//	defer server.Close()
+//	server.Index("logs-2016.04.29", "logs", "1", map[string]interface{}{"message": "Disk full"})
+//	l, err := lgrep.New(server.URL)
+package lgreptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/cogolabs/lgrep"
+	"github.com/juju/errors"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+// DefaultVersion is the version of Elasticsearch that the server
+// reports being unless it's given another.
+const DefaultVersion = "2.4.6"
+
+// Server is a fake Elasticsearch server.
+type Server struct {
+	*httptest.Server
+	// Version is the version number that the server reports (see
+	// DefaultVersion).
+	Version string
+
+	mu      sync.Mutex
+	hits    []*elastic.SearchHit
+	scrolls map[string]*lgrep.FileBackend
+	// ids numbers the documents indexed without an id.
+	ids int
+	// created are the indices created, with the mappings they were
+	// given.
+	created map[string]map[string]interface{}
+}
+
+// NewServer starts a server without any documents.
+func NewServer() *Server {
+	s := &Server{
+		Version: DefaultVersion,
+		scrolls: make(map[string]*lgrep.FileBackend),
+		created: make(map[string]map[string]interface{}),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Index adds the source as the document with the id in the index, the
+// source is anything that marshals as a JSON object.
+func (s *Server) Index(index string, typ string, id string, source interface{}) error {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return errors.Annotatef(err, "Could not encode document %s", id)
+	}
+	raw := json.RawMessage(data)
+	return s.Add(&elastic.SearchHit{Index: index, Type: typ, Id: id, Source: &raw})
+}
+
+// Add adds the hits as the documents of their indices.
+func (s *Server) Add(hits ...*elastic.SearchHit) error {
+	for i, hit := range hits {
+		if hit.Index == "" || hit.Source == nil {
+			return errors.Errorf("Document %d doesn't have an _index and _source", i+1)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits = append(s.hits, hits...)
+	return nil
+}
+
+// Load adds the hits of a dump (see lgrep.ReadHits), the hits must
+// have their _index, as given by lgrep -J.
+func (s *Server) Load(path string) error {
+	r, err := lgrep.OpenDump(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hits, err := lgrep.ReadHits(r)
+	if err != nil {
+		return errors.Annotatef(err, "Could not read %s", path)
+	}
+	return errors.Annotatef(s.Add(hits...), "Could not load %s", path)
+}
+
+// serverError is an error as Elasticsearch responds with it.
+type serverError struct {
+	status int
+	kind   string
+	reason string
+}
+
+func (e serverError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.reason)
+}
+
+// fail responds with the error.
+func fail(w http.ResponseWriter, err error) {
+	e, ok := err.(serverError)
+	if !ok {
+		e = serverError{http.StatusBadRequest, "search_phase_execution_exception", err.Error()}
+	}
+	details := map[string]interface{}{"type": e.kind, "reason": e.reason}
+	respond(w, e.status, map[string]interface{}{
+		"error":  map[string]interface{}{"root_cause": []interface{}{details}, "type": e.kind, "reason": e.reason},
+		"status": e.status,
+	})
+}
+
+// respond writes the response as JSON.
+func respond(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ServeHTTP answers the request as Elasticsearch would.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		body = nil
+	}
+	response, err := s.handle(r, body)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if r.Method == "HEAD" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	respond(w, http.StatusOK, response)
+}
+
+// handle routes the request to its API.
+func (s *Server) handle(r *http.Request, body []byte) (response interface{}, err error) {
+	p := strings.Trim(r.URL.Path, "/")
+	switch {
+	case p == "":
+		return map[string]interface{}{
+			"name":         "lgreptest",
+			"cluster_name": "lgreptest",
+			"version":      map[string]interface{}{"number": s.Version},
+			"tagline":      "You Know, for Search",
+		}, nil
+	case p == "_nodes/http":
+		return map[string]interface{}{
+			"cluster_name": "lgreptest",
+			"nodes": map[string]interface{}{
+				"lgreptest": map[string]interface{}{
+					"name":         "lgreptest",
+					"http_address": strings.TrimPrefix(s.URL, "http://"),
+				},
+			},
+		}, nil
+	case strings.HasPrefix(p, "_search/scroll") && r.Method == "DELETE":
+		return s.clearScroll(r.Context(), scrollIDs(r, body))
+	case strings.HasPrefix(p, "_search/scroll"):
+		return s.scroll(r.Context(), r, body)
+	}
+
+	target, endpoint := splitPath(p)
+	switch {
+	case endpoint == "_bulk":
+		return s.bulk(target, body)
+	case endpoint == "" && r.Method == "PUT" && len(target.Indices) == 1:
+		return s.createIndex(target.Indices[0], body)
+	}
+	backend, err := s.backend(target)
+	if err != nil {
+		return nil, err
+	}
+	switch endpoint {
+	case "_search":
+		if keepAlive := r.URL.Query().Get("scroll"); keepAlive != "" {
+			result, err := backend.Scroll(r.Context(), target, rawBody(body), keepAlive, "")
+			if err == nil {
+				s.mu.Lock()
+				s.scrolls[result.ScrollId] = backend
+				s.mu.Unlock()
+			}
+			return result, err
+		}
+		return backend.Search(r.Context(), target, rawBody(body))
+	case "_count":
+		count, err := backend.Count(r.Context(), target, rawBody(body))
+		return map[string]interface{}{"count": count, "_shards": shards()}, err
+	case "_validate/query":
+		return s.validate(r.Context(), backend, target, body)
+	case "_mapping":
+		return s.mapping(r.Context(), backend, target)
+	case "":
+		if r.Method == "HEAD" {
+			return nil, nil
+		}
+	}
+	return nil, serverError{http.StatusBadRequest, "illegal_argument_exception", "no handler found for uri [" + r.URL.Path + "]"}
+}
+
+// splitPath splits the path into the target of the request
+// (/{index}/{type}/) and its endpoint, the types of mappings follow
+// the endpoint (/{index}/_mapping/{type}).
+func splitPath(p string) (target lgrep.SearchTarget, endpoint string) {
+	parts := strings.Split(p, "/")
+	var names []string
+	for i, part := range parts {
+		if strings.HasPrefix(part, "_") && part != "_all" {
+			endpoint = strings.Join(parts[i:], "/")
+			if part == "_mapping" && len(parts) > i+1 {
+				endpoint = part
+				names = append(names, parts[i+1])
+			}
+			break
+		}
+		names = append(names, part)
+	}
+	if len(names) > 0 && names[0] != "_all" {
+		target.Indices = strings.Split(names[0], ",")
+	}
+	if len(names) > 1 && names[1] != "_all" {
+		target.Types = strings.Split(names[1], ",")
+	}
+	return target, endpoint
+}
+
+// backend creates the backend searching the documents of the target.
+// Indices named without wildcards must exist.
+func (s *Server) backend(target lgrep.SearchTarget) (backend *lgrep.FileBackend, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, index := range target.Indices {
+		if strings.Contains(index, "*") {
+			continue
+		}
+		_, found := s.created[index]
+		for _, hit := range s.hits {
+			found = found || hit.Index == index
+		}
+		if !found {
+			return nil, serverError{http.StatusNotFound, "index_not_found_exception", "no such index [" + index + "]"}
+		}
+	}
+	var hits []*elastic.SearchHit
+	for _, hit := range s.hits {
+		if matchNames(hit.Index, target.Indices) && matchNames(hit.Type, target.Types) {
+			hits = append(hits, hit)
+		}
+	}
+	return lgrep.NewHitsBackend("lgreptest", hits)
+}
+
+// createIndex creates the index with the mappings of the body.
+func (s *Server) createIndex(index string, body []byte) (response interface{}, err error) {
+	var request struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if body != nil {
+		if err = json.Unmarshal(body, &request); err != nil {
+			return nil, serverError{http.StatusBadRequest, "parse_exception", "Failed to parse the index's settings"}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.created[index]
+	for _, hit := range s.hits {
+		exists = exists || hit.Index == index
+	}
+	if exists {
+		return nil, serverError{http.StatusBadRequest, "index_already_exists_exception", "already exists [" + index + "]"}
+	}
+	s.created[index] = request.Mappings
+	return map[string]interface{}{"acknowledged": true}, nil
+}
+
+// mapping returns the mappings of the target, those of the created
+// indices as they were given rather than inferred from the documents.
+func (s *Server) mapping(ctx context.Context, backend *lgrep.FileBackend, target lgrep.SearchTarget) (response interface{}, err error) {
+	mapping, err := backend.Mapping(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for index, mappings := range s.created {
+		if mappings != nil && matchNames(index, target.Indices) {
+			mapping[index] = map[string]interface{}{"mappings": mappings}
+		}
+	}
+	return mapping, nil
+}
+
+// matchNames checks the name against the patterns, any name matches
+// when there aren't any.
+func matchNames(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok || pattern == "_all" {
+			return true
+		}
+	}
+	return len(patterns) == 0
+}
+
+// scroll returns the next page of a scroll.
+func (s *Server) scroll(ctx context.Context, r *http.Request, body []byte) (response interface{}, err error) {
+	ids := scrollIDs(r, body)
+	if len(ids) != 1 {
+		return nil, serverError{http.StatusBadRequest, "action_request_validation_exception", "scrollId is missing"}
+	}
+	s.mu.Lock()
+	backend, ok := s.scrolls[ids[0]]
+	s.mu.Unlock()
+	if !ok {
+		return nil, serverError{http.StatusNotFound, "search_context_missing_exception", "No search context found for id [" + ids[0] + "]"}
+	}
+	return backend.Scroll(ctx, lgrep.SearchTarget{}, nil, r.URL.Query().Get("scroll"), ids[0])
+}
+
+// clearScroll forgets the scrolls.
+func (s *Server) clearScroll(ctx context.Context, ids []string) (response interface{}, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	freed := 0
+	for _, id := range ids {
+		if backend, ok := s.scrolls[id]; ok {
+			backend.ClearScroll(ctx, id)
+			delete(s.scrolls, id)
+			freed++
+		}
+	}
+	return map[string]interface{}{"succeeded": true, "num_freed": freed}, nil
+}
+
+// scrollIDs returns the ids of the scrolls given by the request, as
+// its parameter, a JSON body or the ids alone.
+func scrollIDs(r *http.Request, body []byte) (ids []string) {
+	if id := r.URL.Query().Get("scroll_id"); id != "" {
+		return strings.Split(id, ",")
+	}
+	var request struct {
+		ScrollID interface{} `json:"scroll_id"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		for _, id := range strings.Split(string(body), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	switch v := request.ScrollID.(type) {
+	case string:
+		ids = append(ids, v)
+	case []interface{}:
+		for _, id := range v {
+			ids = append(ids, fmt.Sprint(id))
+		}
+	}
+	return ids
+}
+
+// validate validates the query of the body, which mustn't have
+// anything but the query.
+func (s *Server) validate(ctx context.Context, backend *lgrep.FileBackend, target lgrep.SearchTarget, body []byte) (response interface{}, err error) {
+	var request map[string]json.RawMessage
+	if body != nil {
+		if err = json.Unmarshal(body, &request); err != nil {
+			return nil, serverError{http.StatusBadRequest, "parse_exception", "Failed to derive xcontent"}
+		}
+	}
+	for key := range request {
+		if key != "query" {
+			result := lgrep.ValidationResponse{}
+			result.Shards.Total, result.Shards.Failed = 1, 1
+			result.Explanations = []lgrep.ValidationExplanation{{
+				Index:   "lgreptest",
+				Message: "request does not support [" + key + "]",
+			}}
+			return result, nil
+		}
+	}
+	return backend.Validate(ctx, target, rawBody(body))
+}
+
+// bulk indexes the documents of the body, given by index and create
+// actions, into the index and type of the target unless the actions
+// name their own.
+func (s *Server) bulk(target lgrep.SearchTarget, body []byte) (response interface{}, err error) {
+	var defaults struct{ index, typ string }
+	if len(target.Indices) != 0 {
+		defaults.index = target.Indices[0]
+	}
+	if len(target.Types) != 0 {
+		defaults.typ = target.Types[0]
+	}
+
+	var (
+		items  []interface{}
+		failed bool
+		lines  = strings.Split(strings.TrimSpace(string(body)), "\n")
+	)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < len(lines); i += 2 {
+		var action map[string]*elastic.SearchHit
+		if err = json.Unmarshal([]byte(lines[i]), &action); err != nil || len(action) != 1 || i+1 == len(lines) {
+			return nil, serverError{http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("Malformed action/metadata line [%d]", i+1)}
+		}
+		for op, meta := range action {
+			if op != "index" && op != "create" {
+				return nil, serverError{http.StatusBadRequest, "illegal_argument_exception", "Action/metadata line [" + fmt.Sprint(i+1) + "] contains an unknown parameter [" + op + "]"}
+			}
+			if meta == nil {
+				meta = &elastic.SearchHit{}
+			}
+			if meta.Index == "" {
+				meta.Index = defaults.index
+			}
+			if meta.Type == "" {
+				meta.Type = defaults.typ
+			}
+			status, cause := s.indexLine(op, meta, []byte(lines[i+1]))
+			item := map[string]interface{}{"_index": meta.Index, "_type": meta.Type, "_id": meta.Id, "_version": 1, "status": status}
+			if cause != nil {
+				item["error"] = map[string]interface{}{"type": cause.kind, "reason": cause.reason}
+				failed = true
+			}
+			items = append(items, map[string]interface{}{op: item})
+		}
+	}
+	return map[string]interface{}{"took": 1, "errors": failed, "items": items}, nil
+}
+
+// indexLine indexes the source as the document, numbering it when it
+// doesn't have an id. The lock must be held.
+func (s *Server) indexLine(op string, meta *elastic.SearchHit, source []byte) (status int, cause *serverError) {
+	var doc map[string]interface{}
+	if meta.Index == "" {
+		return http.StatusBadRequest, &serverError{http.StatusBadRequest, "action_request_validation_exception", "index is missing"}
+	}
+	if err := json.Unmarshal(source, &doc); err != nil || doc == nil {
+		return http.StatusBadRequest, &serverError{http.StatusBadRequest, "mapper_parsing_exception", "failed to parse"}
+	}
+	if meta.Id == "" {
+		s.ids++
+		meta.Id = fmt.Sprintf("lgreptest%d", s.ids)
+	}
+	raw := json.RawMessage(source)
+	hit := &elastic.SearchHit{Index: meta.Index, Type: meta.Type, Id: meta.Id, Source: &raw}
+	for i, existing := range s.hits {
+		if existing.Index != hit.Index || existing.Type != hit.Type || existing.Id != hit.Id {
+			continue
+		}
+		if op == "create" {
+			return http.StatusConflict, &serverError{http.StatusConflict, "version_conflict_engine_exception", "[" + hit.Type + "][" + hit.Id + "]: document already exists"}
+		}
+		s.hits[i] = hit
+		return http.StatusOK, nil
+	}
+	s.hits = append(s.hits, hit)
+	return http.StatusCreated, nil
+}
+
+// rawBody gives the body to the backend, nil when it's empty.
+func rawBody(body []byte) interface{} {
+	if body == nil {
+		return nil
+	}
+	return json.RawMessage(body)
+}
+
+// shards are the shards that every request succeeds on.
+func shards() map[string]interface{} {
+	return map[string]interface{}{"total": 1, "successful": 1, "failed": 0}
+}
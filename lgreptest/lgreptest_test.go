@@ -0,0 +1,133 @@
+package lgreptest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cogolabs/lgrep"
+)
+
+func TestSplitPath(t *testing.T) {
+	examples := map[string]struct {
+		target   lgrep.SearchTarget
+		endpoint string
+	}{
+		"_search":                   {lgrep.SearchTarget{}, "_search"},
+		"logs-*/_search":            {lgrep.SearchTarget{Indices: []string{"logs-*"}}, "_search"},
+		"logs-1,logs-2/logs/_count": {lgrep.SearchTarget{Indices: []string{"logs-1", "logs-2"}, Types: []string{"logs"}}, "_count"},
+		"_all/logs/_validate/query": {lgrep.SearchTarget{Types: []string{"logs"}}, "_validate/query"},
+		"logs-*/_mapping/logs":      {lgrep.SearchTarget{Indices: []string{"logs-*"}, Types: []string{"logs"}}, "_mapping"},
+		"_mapping":                  {lgrep.SearchTarget{}, "_mapping"},
+	}
+	for path, expected := range examples {
+		target, endpoint := splitPath(path)
+		if !reflect.DeepEqual(target, expected.target) || endpoint != expected.endpoint {
+			t.Errorf("Split %s into %v %s, expected %v %s", path, target, endpoint, expected.target, expected.endpoint)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	for i, host := range []string{"web1", "web2", "db1"} {
+		server.Index("logs-1", "logs", host, map[string]interface{}{"host": host, "status": 500 - i*100})
+	}
+
+	l, err := lgrep.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Version.Number != DefaultVersion {
+		t.Errorf("Detected version %s", l.Version)
+	}
+	docs, err := l.SimpleSearch("host:web*", &lgrep.SearchOptions{Index: "logs-*", Size: 10})
+	if err != nil || len(docs) != 2 {
+		t.Errorf("Searched %d documents: %v", len(docs), err)
+	}
+	docs, err = l.SimpleSearch("*", &lgrep.SearchOptions{Index: "logs-*", Size: lgrep.MaxSearchSize + 1})
+	if err != nil || len(docs) != 3 {
+		t.Errorf("Scrolled %d documents: %v", len(docs), err)
+	}
+	if count, err := l.SimpleCount("status:>=400", &lgrep.SearchOptions{Type: "logs"}); err != nil || count != 2 {
+		t.Errorf("Counted %d documents: %v", count, err)
+	}
+	if fields, err := l.Fields(nil); err != nil || !reflect.DeepEqual(fields, []string{"host", "status"}) {
+		t.Errorf("Fields %v: %v", fields, err)
+	}
+	if _, err := l.Validate("*", &lgrep.SearchOptions{Index: "logs-2"}); err != lgrep.ErrInvalidIndex {
+		t.Errorf("Expected an unknown index, got %v", err)
+	}
+
+	// The scrolls are cleared.
+	server.mu.Lock()
+	scrolls := len(server.scrolls)
+	server.mu.Unlock()
+	if scrolls != 0 {
+		t.Errorf("%d scrolls weren't cleared", scrolls)
+	}
+
+	res, err := http.Post(server.URL+"/logs-1/_search", "application/json", strings.NewReader(`{"aggs": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var failure struct {
+		Error struct {
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	json.NewDecoder(res.Body).Decode(&failure)
+	if res.StatusCode != http.StatusBadRequest || failure.Error.Reason == "" {
+		t.Errorf("Expected aggregations to fail, responded %s: %v", res.Status, failure)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Index("logs-1", "logs", "1", map[string]interface{}{"host": "web1"})
+
+	body := `{"index": {"_id": "1"}}
+{"host": "web2"}
+{"create": {"_id": "1"}}
+{"host": "web3"}
+{"index": {"_index": "logs-2"}}
+{"host": "db1"}
+{"index": {}}
+"not a document"
+`
+	res, err := http.Post(server.URL+"/logs-1/logs/_bulk", "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var response struct {
+		Errors bool                                `json:"errors"`
+		Items  []map[string]map[string]interface{} `json:"items"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	var statuses []float64
+	for _, item := range response.Items {
+		for _, result := range item {
+			statuses = append(statuses, result["status"].(float64))
+		}
+	}
+	if !response.Errors || !reflect.DeepEqual(statuses, []float64{200, 409, 201, 400}) {
+		t.Errorf("Responded %d with %v", res.StatusCode, statuses)
+	}
+
+	l, err := lgrep.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := l.SimpleSearch("*", &lgrep.SearchOptions{Index: "logs-*", Size: 10, Fields: []string{"host"}})
+	if err != nil || len(docs) != 2 {
+		t.Errorf("Indexed %v: %v", docs, err)
+	}
+}
@@ -0,0 +1,757 @@
+package lgrep
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// LuceneOccur is how a clause of a boolean query must occur in a
+// matching document.
+type LuceneOccur int
+
+const (
+	// OccurShould clauses may match, at least one should clause must
+	// match when the query has no required clauses.
+	OccurShould LuceneOccur = iota
+	// OccurMust clauses are required to match (+clause, AND).
+	OccurMust
+	// OccurMustNot clauses are required not to match (-clause, NOT).
+	OccurMustNot
+)
+
+const (
+	// luceneSpecial are the characters that have a special meaning in
+	// the query_string syntax and must be escaped to be matched.
+	luceneSpecial = `+-=&|><!(){}[]^"~*?:\/`
+	// luceneTermEnd are the characters that end a bare term.
+	luceneTermEnd = `()[]{}:^~"/!`
+)
+
+// LuceneQuery is a node of a parsed lucene query.
+type LuceneQuery interface {
+	// Pos is the column (1 based) in the query where the node begins.
+	Pos() int
+}
+
+// LuceneBool is a boolean combination of clauses, such as grouped or
+// operator joined queries.
+type LuceneBool struct {
+	Position int
+	Clauses  []LuceneClause
+}
+
+// LuceneClause is a single clause of a boolean query.
+type LuceneClause struct {
+	Occur LuceneOccur
+	Query LuceneQuery
+}
+
+// LuceneTerm is a single term that may contain wildcards.
+type LuceneTerm struct {
+	Position int
+	// Field is the field to match, empty for the default field.
+	Field string
+	// Value is the unescaped term.
+	Value string
+	// Raw is the term as it was given, including any escapes.
+	Raw string
+	// Wildcard is set when the term contains unescaped * or ?.
+	Wildcard bool
+	// Fuzzy is the fuzziness given with ~, "~" alone is recorded as
+	// the default fuzziness.
+	Fuzzy string
+	Boost string
+}
+
+// LucenePhrase is a quoted phrase.
+type LucenePhrase struct {
+	Position int
+	Field    string
+	Value    string
+	Slop     string
+	Boost    string
+}
+
+// LuceneRange is a range of values, an unbounded side is "*".
+type LuceneRange struct {
+	Position     int
+	Field        string
+	Lower        string
+	Upper        string
+	IncludeLower bool
+	IncludeUpper bool
+}
+
+// LuceneRegexp is a /regular expression/ query.
+type LuceneRegexp struct {
+	Position int
+	Field    string
+	Pattern  string
+}
+
+// Pos returns the column where the node begins.
+func (q *LuceneBool) Pos() int { return q.Position }
+
+// Pos returns the column where the node begins.
+func (q *LuceneTerm) Pos() int { return q.Position }
+
+// Pos returns the column where the node begins.
+func (q *LucenePhrase) Pos() int { return q.Position }
+
+// Pos returns the column where the node begins.
+func (q *LuceneRange) Pos() int { return q.Position }
+
+// Pos returns the column where the node begins.
+func (q *LuceneRegexp) Pos() int { return q.Position }
+
+// LuceneSyntaxError describes where and why a lucene query could not
+// be parsed.
+type LuceneSyntaxError struct {
+	// Query is the query that was being parsed.
+	Query string
+	// Column is the column (1 based) of the first error.
+	Column int
+	// Message describes the error.
+	Message string
+	// Suggestion is a corrected query that does parse, if one could
+	// be found by escaping the offending characters.
+	Suggestion string
+}
+
+// Error returns the error message and its position.
+func (e *LuceneSyntaxError) Error() string {
+	return fmt.Sprintf("Invalid Lucene syntax at column %d: %s", e.Column, e.Message)
+}
+
+// Cause allows the error to be compared to ErrInvalidLuceneSyntax
+// with errors.Cause.
+func (e *LuceneSyntaxError) Cause() error {
+	return ErrInvalidLuceneSyntax
+}
+
+// Explain formats the error for display, with a caret under the
+// offending position of the query and the suggested query if there
+// is one.
+func (e *LuceneSyntaxError) Explain() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n  %s\n  %s^", e.Error(), e.Query, strings.Repeat(" ", e.Column-1))
+	if e.Suggestion != "" {
+		fmt.Fprintf(&buf, "\nDid you mean: %s", e.Suggestion)
+	}
+	return buf.String()
+}
+
+// EscapeLucene escapes all of the characters in the value that are
+// special to the lucene syntax so that the value is matched as is.
+func EscapeLucene(value string) string {
+	return escapeRunes(value, luceneSpecial)
+}
+
+// escapeRunes escapes any of the special runes found in the value.
+func escapeRunes(value string, special string) string {
+	var buf bytes.Buffer
+	for _, r := range value {
+		if strings.ContainsRune(special, r) {
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// ParseLucene parses the query_string (lucene) syntax query. Errors
+// are returned as a *LuceneSyntaxError.
+func ParseLucene(q string) (query LuceneQuery, err error) {
+	if strings.TrimSpace(q) == "" {
+		return nil, ErrEmptySearch
+	}
+	p := &luceneParser{query: []rune(q)}
+	query, err = p.parseQuery()
+	if err != nil {
+		if serr, ok := err.(*LuceneSyntaxError); ok {
+			serr.Suggestion = suggestEscape(p.query, serr.Column-1)
+		}
+		return nil, err
+	}
+	return query, nil
+}
+
+// luceneParser is a recursive descent parser of the lucene syntax.
+type luceneParser struct {
+	query []rune
+	pos   int
+}
+
+// errorf creates a syntax error at the (0 based) position.
+func (p *luceneParser) errorf(pos int, format string, args ...interface{}) error {
+	return &LuceneSyntaxError{
+		Query:   string(p.query),
+		Column:  pos + 1,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *luceneParser) eof() bool {
+	return p.pos >= len(p.query)
+}
+
+func (p *luceneParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.query[p.pos]
+}
+
+// peekAt returns the rune at an offset from the current position.
+func (p *luceneParser) peekAt(offset int) rune {
+	if p.pos+offset >= len(p.query) {
+		return 0
+	}
+	return p.query[p.pos+offset]
+}
+
+func (p *luceneParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+// keyword reports if the keyword is at the current position, it must
+// stand alone as a word.
+func (p *luceneParser) keyword(word string) bool {
+	w := []rune(word)
+	if p.pos+len(w) > len(p.query) {
+		return false
+	}
+	if string(p.query[p.pos:p.pos+len(w)]) != word {
+		return false
+	}
+	next := p.peekAt(len(w))
+	return next == 0 || unicode.IsSpace(next) || next == '(' || next == ')' || next == '"'
+}
+
+// operator returns the length of the boolean operator at the current
+// position, if there is one.
+func (p *luceneParser) operator(word, symbol string) int {
+	if p.keyword(word) {
+		return len(word)
+	}
+	if p.peek() == rune(symbol[0]) && p.peekAt(1) == rune(symbol[1]) {
+		return len(symbol)
+	}
+	return 0
+}
+
+// endOfGroup reports if there's nothing left to parse at this depth.
+func (p *luceneParser) endOfGroup() bool {
+	return p.eof() || p.peek() == ')'
+}
+
+func (p *luceneParser) parseQuery() (query LuceneQuery, err error) {
+	query, err = p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf(p.pos, "unbalanced ')' without a matching '('")
+	}
+	if query == nil {
+		return nil, ErrEmptySearch
+	}
+	return query, nil
+}
+
+// parseOr parses a sequence of clauses that are joined by OR or the
+// implicit (default) operator.
+func (p *luceneParser) parseOr(field string) (query LuceneQuery, err error) {
+	var clauses []LuceneClause
+	start := p.pos
+	for {
+		p.skipSpace()
+		if p.endOfGroup() {
+			break
+		}
+		if len(clauses) != 0 {
+			if n := p.operator("OR", "||"); n != 0 {
+				opPos := p.pos
+				p.pos += n
+				p.skipSpace()
+				if p.endOfGroup() {
+					return nil, p.errorf(opPos, "expected a query after '%s'", string(p.query[opPos:opPos+n]))
+				}
+			}
+		}
+		clause, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+	if len(clauses) == 0 {
+		return nil, nil
+	}
+	if len(clauses) == 1 && clauses[0].Occur == OccurShould {
+		return clauses[0].Query, nil
+	}
+	return &LuceneBool{Position: start + 1, Clauses: clauses}, nil
+}
+
+// parseAnd parses clauses joined by AND.
+func (p *luceneParser) parseAnd(field string) (clause LuceneClause, err error) {
+	start := p.pos
+	first, err := p.parseUnary(field)
+	if err != nil {
+		return clause, err
+	}
+	clauses := []LuceneClause{first}
+	for {
+		save := p.pos
+		p.skipSpace()
+		n := p.operator("AND", "&&")
+		if n == 0 {
+			p.pos = save
+			break
+		}
+		opPos := p.pos
+		p.pos += n
+		p.skipSpace()
+		if p.endOfGroup() || p.operator("AND", "&&") != 0 || p.operator("OR", "||") != 0 {
+			return clause, p.errorf(opPos, "expected a query after '%s'", string(p.query[opPos:opPos+n]))
+		}
+		next, err := p.parseUnary(field)
+		if err != nil {
+			return clause, err
+		}
+		clauses = append(clauses, next)
+	}
+	if len(clauses) == 1 {
+		return first, nil
+	}
+	for i := range clauses {
+		if clauses[i].Occur == OccurShould {
+			clauses[i].Occur = OccurMust
+		}
+	}
+	return LuceneClause{Query: &LuceneBool{Position: start + 1, Clauses: clauses}}, nil
+}
+
+// parseUnary parses a query that may be prefixed with a modifier.
+func (p *luceneParser) parseUnary(field string) (clause LuceneClause, err error) {
+	p.skipSpace()
+	start := p.pos
+	if n := p.operator("AND", "&&"); n != 0 {
+		return clause, p.errorf(start, "unexpected '%s' without a query before it", string(p.query[start:start+n]))
+	}
+	if n := p.operator("OR", "||"); n != 0 {
+		return clause, p.errorf(start, "unexpected '%s' without a query before it", string(p.query[start:start+n]))
+	}
+
+	modifier := ""
+	switch {
+	case p.keyword("NOT"):
+		modifier = "NOT"
+		clause.Occur = OccurMustNot
+	case p.peek() == '!':
+		modifier = "!"
+		clause.Occur = OccurMustNot
+	case p.peek() == '-':
+		modifier = "-"
+		clause.Occur = OccurMustNot
+	case p.peek() == '+':
+		modifier = "+"
+		clause.Occur = OccurMust
+	}
+	if modifier != "" {
+		p.pos += len(modifier)
+		p.skipSpace()
+		if p.endOfGroup() || p.operator("AND", "&&") != 0 || p.operator("OR", "||") != 0 {
+			return clause, p.errorf(start, "expected a query after '%s'", modifier)
+		}
+	}
+	clause.Query, err = p.parsePrimary(field)
+	return clause, err
+}
+
+// parsePrimary parses a group, phrase, range, regexp or term along
+// with any field given for it.
+func (p *luceneParser) parsePrimary(field string) (query LuceneQuery, err error) {
+	p.skipSpace()
+	start := p.pos
+	switch c := p.peek(); c {
+	case '(':
+		return p.parseGroup(field)
+	case '"':
+		return p.parsePhrase(field)
+	case '/':
+		return p.parseRegexp(field)
+	case '[', '{':
+		return p.parseRange(field)
+	case ')', ']', '}', ':', '^', '~':
+		return nil, p.errorf(start, "unexpected '%c'", c)
+	}
+
+	raw, value, wildcard, err := p.readTerm()
+	if err != nil {
+		return nil, err
+	}
+	if raw == "" {
+		return nil, p.errorf(start, "unexpected '%c'", p.peek())
+	}
+	if p.peek() == ':' {
+		p.pos++
+		return p.parseFieldValue(value, start)
+	}
+	return p.finishTerm(&LuceneTerm{
+		Position: start + 1,
+		Field:    field,
+		Value:    value,
+		Raw:      raw,
+		Wildcard: wildcard,
+	})
+}
+
+// parseFieldValue parses the value given to a field.
+func (p *luceneParser) parseFieldValue(field string, start int) (query LuceneQuery, err error) {
+	if p.eof() || unicode.IsSpace(p.peek()) || p.peek() == ')' {
+		return nil, p.errorf(p.pos, "expected a value for the field '%s'", field)
+	}
+	switch c := p.peek(); c {
+	case '(':
+		return p.parseGroup(field)
+	case '"':
+		return p.parsePhrase(field)
+	case '/':
+		return p.parseRegexp(field)
+	case '[', '{':
+		return p.parseRange(field)
+	case '>', '<':
+		return p.parseComparison(field, start)
+	case ':', ']', '}', '^', '~':
+		return nil, p.errorf(p.pos, "unexpected '%c' in the value for the field '%s'", c, field)
+	}
+
+	valueStart := p.pos
+	raw, value, wildcard, err := p.readTerm()
+	if err != nil {
+		return nil, err
+	}
+	if raw == "" {
+		return nil, p.errorf(p.pos, "unexpected '%c' in the value for the field '%s'", p.peek(), field)
+	}
+	if p.peek() == ':' {
+		return nil, p.errorf(p.pos, "unexpected ':' in the value for the field '%s', escape it as '\\:'", field)
+	}
+	return p.finishTerm(&LuceneTerm{
+		Position: valueStart + 1,
+		Field:    field,
+		Value:    value,
+		Raw:      raw,
+		Wildcard: wildcard,
+	})
+}
+
+// parseGroup parses a parenthesized group of clauses.
+func (p *luceneParser) parseGroup(field string) (query LuceneQuery, err error) {
+	start := p.pos
+	p.pos++
+	query, err = p.parseOr(field)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf(start, "unbalanced '(' is never closed")
+	}
+	p.pos++
+	if query == nil {
+		return nil, p.errorf(start, "empty group '()'")
+	}
+	if _, err = p.readBoost(); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// parsePhrase parses a quoted phrase.
+func (p *luceneParser) parsePhrase(field string) (query LuceneQuery, err error) {
+	start := p.pos
+	value, ok := p.readDelimited('"')
+	if !ok {
+		return nil, p.errorf(start, "unterminated phrase, missing the closing '\"'")
+	}
+	phrase := &LucenePhrase{Position: start + 1, Field: field, Value: value}
+	if p.peek() == '~' {
+		p.pos++
+		phrase.Slop = p.readNumber()
+	}
+	phrase.Boost, err = p.readBoost()
+	if err != nil {
+		return nil, err
+	}
+	return phrase, nil
+}
+
+// parseRegexp parses a /regular expression/.
+func (p *luceneParser) parseRegexp(field string) (query LuceneQuery, err error) {
+	start := p.pos
+	pattern, ok := p.readDelimited('/')
+	if !ok {
+		return nil, p.errorf(start, "unterminated regular expression, missing the closing '/' (escape a literal '/' as '\\/')")
+	}
+	return &LuceneRegexp{Position: start + 1, Field: field, Pattern: pattern}, nil
+}
+
+// parseRange parses a [lower TO upper] range, {} braces exclude the
+// bound.
+func (p *luceneParser) parseRange(field string) (query LuceneQuery, err error) {
+	start := p.pos
+	rng := &LuceneRange{
+		Position:     start + 1,
+		Field:        field,
+		IncludeLower: p.peek() == '[',
+	}
+	p.pos++
+	p.skipSpace()
+	if rng.Lower, err = p.readRangeValue(); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.keyword("TO") {
+		if p.eof() {
+			return nil, p.errorf(start, "unterminated range, missing 'TO'")
+		}
+		return nil, p.errorf(p.pos, "expected 'TO' in the range")
+	}
+	p.pos += 2
+	p.skipSpace()
+	if rng.Upper, err = p.readRangeValue(); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	switch p.peek() {
+	case ']':
+		rng.IncludeUpper = true
+	case '}':
+	default:
+		if p.eof() {
+			return nil, p.errorf(start, "unterminated range, missing the closing ']' or '}'")
+		}
+		return nil, p.errorf(p.pos, "expected ']' or '}' to close the range")
+	}
+	p.pos++
+	if _, err = p.readBoost(); err != nil {
+		return nil, err
+	}
+	return rng, nil
+}
+
+// parseComparison parses the >, >=, < and <= range shorthands.
+func (p *luceneParser) parseComparison(field string, start int) (query LuceneQuery, err error) {
+	rng := &LuceneRange{Position: p.pos + 1, Field: field, Lower: "*", Upper: "*"}
+	op := string(p.peek())
+	p.pos++
+	if p.peek() == '=' {
+		op += "="
+		p.pos++
+	}
+	if p.eof() || unicode.IsSpace(p.peek()) || p.peek() == ')' {
+		return nil, p.errorf(p.pos, "expected a value after '%s'", op)
+	}
+	value, err := p.readRangeValue()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case ">":
+		rng.Lower = value
+	case ">=":
+		rng.Lower, rng.IncludeLower = value, true
+	case "<":
+		rng.Upper = value
+	case "<=":
+		rng.Upper, rng.IncludeUpper = value, true
+	}
+	return rng, nil
+}
+
+// finishTerm reads the fuzziness and boost given to a term.
+func (p *luceneParser) finishTerm(term *LuceneTerm) (query LuceneQuery, err error) {
+	if p.peek() == '~' {
+		p.pos++
+		term.Fuzzy = "~" + p.readNumber()
+	}
+	term.Boost, err = p.readBoost()
+	if err != nil {
+		return nil, err
+	}
+	return term, nil
+}
+
+// readTerm reads a bare term, returning it both as given and
+// unescaped.
+func (p *luceneParser) readTerm() (raw string, value string, wildcard bool, err error) {
+	var rawBuf, valueBuf bytes.Buffer
+	for !p.eof() {
+		c := p.peek()
+		if c == '\\' {
+			if p.pos+1 >= len(p.query) {
+				return raw, value, wildcard, p.errorf(p.pos, "trailing escape character '\\'")
+			}
+			rawBuf.WriteRune(c)
+			rawBuf.WriteRune(p.query[p.pos+1])
+			valueBuf.WriteRune(p.query[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		if unicode.IsSpace(c) || strings.ContainsRune(luceneTermEnd, c) {
+			break
+		}
+		if (c == '&' && p.peekAt(1) == '&') || (c == '|' && p.peekAt(1) == '|') {
+			break
+		}
+		if c == '*' || c == '?' {
+			wildcard = true
+		}
+		rawBuf.WriteRune(c)
+		valueBuf.WriteRune(c)
+		p.pos++
+	}
+	return rawBuf.String(), valueBuf.String(), wildcard, nil
+}
+
+// readDelimited reads the unescaped contents between the delimiter
+// at the current position and its closing counterpart.
+func (p *luceneParser) readDelimited(delim rune) (value string, ok bool) {
+	var buf bytes.Buffer
+	p.pos++
+	for !p.eof() {
+		c := p.peek()
+		if c == '\\' && p.pos+1 < len(p.query) {
+			// Keep escapes in regular expressions as they're
+			// meaningful there.
+			if delim == '/' && p.query[p.pos+1] != '/' {
+				buf.WriteRune(c)
+			}
+			buf.WriteRune(p.query[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		p.pos++
+		if c == delim {
+			return buf.String(), true
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String(), false
+}
+
+// readRangeValue reads a quoted or bare value of a range, bare values
+// may contain any character other than whitespace and the closing
+// brackets.
+func (p *luceneParser) readRangeValue() (value string, err error) {
+	start := p.pos
+	if p.peek() == '"' {
+		value, ok := p.readDelimited('"')
+		if !ok {
+			return value, p.errorf(start, "unterminated phrase, missing the closing '\"'")
+		}
+		return value, nil
+	}
+	for !p.eof() {
+		c := p.peek()
+		if unicode.IsSpace(c) || c == ']' || c == '}' || c == ')' {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return value, p.errorf(start, "expected a value in the range")
+	}
+	return string(p.query[start:p.pos]), nil
+}
+
+// readNumber reads an optional number, as used in boosts and
+// fuzziness.
+func (p *luceneParser) readNumber() string {
+	start := p.pos
+	for !p.eof() && (unicode.IsDigit(p.peek()) || p.peek() == '.') {
+		p.pos++
+	}
+	return string(p.query[start:p.pos])
+}
+
+// readBoost reads the ^N boost if one is given.
+func (p *luceneParser) readBoost() (boost string, err error) {
+	if p.peek() != '^' {
+		return "", nil
+	}
+	start := p.pos
+	p.pos++
+	boost = p.readNumber()
+	if boost == "" {
+		return boost, p.errorf(start, "expected a number after '^'")
+	}
+	return boost, nil
+}
+
+// suggestEscape attempts to correct a query that failed to parse at
+// pos due to an unescaped ':' or '/' in a field's value by escaping
+// them, the corrected query is only returned if it parses.
+func suggestEscape(query []rune, pos int) string {
+	if pos < 0 || pos >= len(query) || (query[pos] != ':' && query[pos] != '/') {
+		return ""
+	}
+	// Find the whitespace delimited token that the error is in.
+	start, end := pos, pos
+	for start > 0 && !unicode.IsSpace(query[start-1]) {
+		start--
+	}
+	for end < len(query) && !unicode.IsSpace(query[end]) {
+		end++
+	}
+	// Groups and modifiers leading the token are kept as they are.
+	for start < pos && strings.ContainsRune("(+-!", query[start]) {
+		start++
+	}
+	token := query[start:end]
+
+	// Keep the field prefix of the token as it is.
+	var prefix []rune
+	for i := 0; i < len(token); i++ {
+		if token[i] == '\\' {
+			i++
+			continue
+		}
+		if token[i] == '"' {
+			return ""
+		}
+		if token[i] == ':' && start+i != pos {
+			prefix, token = token[:i+1], token[i+1:]
+			break
+		}
+	}
+
+	var value bytes.Buffer
+	for i := 0; i < len(token); i++ {
+		if token[i] == '\\' && i+1 < len(token) {
+			value.WriteRune(token[i])
+			value.WriteRune(token[i+1])
+			i++
+			continue
+		}
+		if token[i] == ':' || token[i] == '/' {
+			value.WriteRune('\\')
+		}
+		value.WriteRune(token[i])
+	}
+
+	suggestion := string(query[:start]) + string(prefix) + value.String() + string(query[end:])
+	p := &luceneParser{query: []rune(suggestion)}
+	if _, err := p.parseQuery(); err != nil {
+		return ""
+	}
+	return suggestion
+}
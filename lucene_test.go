@@ -0,0 +1,104 @@
+package lgrep
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestParseLucene(t *testing.T) {
+	valid := []string{
+		"*",
+		"error",
+		"host:web01",
+		"host:web-01.example.com",
+		`message:"connection refused"~2^3`,
+		"status:[500 TO 599]",
+		"status:{500 TO *]",
+		"@timestamp:[2016-01-01T00:00:00 TO now]",
+		"status:>=500",
+		"host:web* AND NOT service:cron",
+		"(a OR b) && c",
+		"+required -prohibited optional",
+		"status:(500 OR 502 OR 503)",
+		`path:\/var\/log`,
+		"path:/var/log/.*/",
+		"_exists_:host",
+		"roam~ quikc~1 boosted^2",
+		"a || b",
+		"!a",
+	}
+	for _, q := range valid {
+		if _, err := ParseLucene(q); err != nil {
+			t.Errorf("ParseLucene(%q) returned unexpected err: %s", q, err)
+		}
+	}
+}
+
+func TestParseLuceneErrors(t *testing.T) {
+	examples := []struct {
+		query      string
+		column     int
+		suggestion string
+	}{
+		{"NOT", 1, ""},
+		{"error AND", 7, ""},
+		{"OR error", 1, ""},
+		{"(error", 1, ""},
+		{"error)", 6, ""},
+		{`message:"unterminated`, 9, ""},
+		{"host:", 6, ""},
+		{"status:[500 599]", 13, ""},
+		{"status:[500 TO 599", 8, ""},
+		{"error^", 6, ""},
+		{"trailing\\", 9, ""},
+		{"url:http://example.com", 9, `url:http\:\/\/example.com`},
+		{"host:web01 path:/var/log/x", 25, `host:web01 path:\/var\/log\/x`},
+		{"a:b:c", 4, `a:b\:c`},
+	}
+	for _, ex := range examples {
+		_, err := ParseLucene(ex.query)
+		serr, ok := err.(*LuceneSyntaxError)
+		if !ok {
+			t.Errorf("ParseLucene(%q) should have returned a syntax error, returned: %v", ex.query, err)
+			continue
+		}
+		if errors.Cause(err) != ErrInvalidLuceneSyntax {
+			t.Errorf("ParseLucene(%q) error should be caused by ErrInvalidLuceneSyntax", ex.query)
+		}
+		if serr.Column != ex.column {
+			t.Errorf("ParseLucene(%q) error at column %d (expected %d):\n%s", ex.query, serr.Column, ex.column, serr.Explain())
+		}
+		if serr.Suggestion != ex.suggestion {
+			t.Errorf("ParseLucene(%q) suggested '%s' (expected '%s')", ex.query, serr.Suggestion, ex.suggestion)
+		}
+	}
+}
+
+func TestParseLuceneStructure(t *testing.T) {
+	q, err := ParseLucene("host:web* AND NOT status:[500 TO 599}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok := q.(*LuceneBool)
+	if !ok || len(b.Clauses) != 2 {
+		t.Fatalf("Expected a boolean query of 2 clauses, got: %#v", q)
+	}
+	term, ok := b.Clauses[0].Query.(*LuceneTerm)
+	if !ok || b.Clauses[0].Occur != OccurMust || term.Field != "host" || term.Value != "web*" || !term.Wildcard {
+		t.Errorf("First clause should be a required wildcard term on host, got: %#v", b.Clauses[0])
+	}
+	rng, ok := b.Clauses[1].Query.(*LuceneRange)
+	if !ok || b.Clauses[1].Occur != OccurMustNot || rng.Lower != "500" || rng.Upper != "599" || !rng.IncludeLower || rng.IncludeUpper {
+		t.Errorf("Second clause should be a prohibited range on status, got: %#v", b.Clauses[1])
+	}
+	if rng.Pos() != 26 {
+		t.Errorf("Range should begin at column 26, began at %d", rng.Pos())
+	}
+}
+
+func TestEscapeLucene(t *testing.T) {
+	if escaped := EscapeLucene("http://a.b/c?d"); escaped != `http\:\/\/a.b\/c\?d` {
+		t.Errorf("EscapeLucene escaped to '%s'", escaped)
+	}
+}
@@ -0,0 +1,162 @@
+package lgrep
+
+import (
+	"context"
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// ErrIndexExists is returned when an index to create already exists.
+var ErrIndexExists = errors.New("The index already exists")
+
+// Mapping retrieves the mapping of the indices that would be searched
+// with the spec, by index name as the server gives it.
+func (l LGrep) Mapping(spec *SearchOptions) (mapping map[string]interface{}, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	return l.backend().Mapping(context.TODO(), spec.target())
+}
+
+// IndexMappings creates the mappings to create the index with on the
+// server from a mapping captured from another (see Mapping), which may
+// be of another version. The captured mappings of the index with the
+// same name are used, those of all of the captured indices are merged
+// when there isn't one. Servers older than Elasticsearch 7 are given a
+// mapping for each of the types, the types of the captured mapping
+// when none are given.
+func IndexMappings(captured map[string]interface{}, index string, types []string, version ServerVersion) (mappings map[string]interface{}, err error) {
+	indices := []string{index}
+	if _, ok := captured[index]; !ok {
+		indices = indices[:0]
+		for name := range captured {
+			indices = append(indices, name)
+		}
+		// The first captured mapping of a field is kept.
+		sort.Strings(indices)
+	}
+
+	// The properties are collected by type, "" for servers without
+	// types.
+	byType := make(map[string]map[string]interface{})
+	var capturedTypes []string
+	for _, name := range indices {
+		indexMapping, _ := captured[name].(map[string]interface{})
+		typeMappings, ok := indexMapping["mappings"].(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("The mapping of %s doesn't have mappings", name)
+		}
+		if properties, ok := typeMappings["properties"].(map[string]interface{}); ok {
+			typeMappings = map[string]interface{}{"": map[string]interface{}{"properties": properties}}
+		}
+		names := make([]string, 0, len(typeMappings))
+		for typ := range typeMappings {
+			names = append(names, typ)
+		}
+		sort.Strings(names)
+		for _, typ := range names {
+			typeMapping, _ := typeMappings[typ].(map[string]interface{})
+			properties, _ := typeMapping["properties"].(map[string]interface{})
+			if _, ok := byType[typ]; !ok {
+				byType[typ] = make(map[string]interface{})
+				capturedTypes = append(capturedTypes, typ)
+			}
+			mergeProperties(byType[typ], properties)
+		}
+	}
+	all := make(map[string]interface{})
+	for _, typ := range capturedTypes {
+		mergeProperties(all, byType[typ])
+	}
+
+	api := version.APIVersion()
+	if api >= 7 {
+		return map[string]interface{}{"properties": convertProperties(all, api)}, nil
+	}
+	if len(types) == 0 {
+		types = capturedTypes
+	}
+	mappings = make(map[string]interface{})
+	for _, typ := range types {
+		if typ == "" {
+			typ = "doc"
+		}
+		properties, ok := byType[typ]
+		if !ok {
+			properties = all
+		}
+		mappings[typ] = map[string]interface{}{"properties": convertProperties(properties, api)}
+	}
+	return mappings, nil
+}
+
+// mergeProperties adds the properties that aren't mapped yet.
+func mergeProperties(into map[string]interface{}, properties map[string]interface{}) {
+	for name, def := range properties {
+		if _, ok := into[name]; !ok {
+			into[name] = def
+		}
+	}
+}
+
+// convertProperties converts the string fields of Elasticsearch 2.x
+// to the text and keyword fields of the API, unless it's older.
+func convertProperties(properties map[string]interface{}, api int) map[string]interface{} {
+	if api < 5 {
+		return properties
+	}
+	converted := make(map[string]interface{}, len(properties))
+	for name, def := range properties {
+		prop, ok := def.(map[string]interface{})
+		if !ok {
+			converted[name] = def
+			continue
+		}
+		out := make(map[string]interface{}, len(prop))
+		for k, v := range prop {
+			out[k] = v
+		}
+		if out["type"] == "string" {
+			switch out["index"] {
+			case "not_analyzed":
+				out["type"] = "keyword"
+				delete(out, "index")
+			case "no":
+				out["type"] = "keyword"
+				out["index"] = false
+			default:
+				out["type"] = "text"
+				delete(out, "index")
+			}
+		}
+		for _, nested := range []string{"properties", "fields"} {
+			if sub, ok := out[nested].(map[string]interface{}); ok {
+				out[nested] = convertProperties(sub, api)
+			}
+		}
+		converted[name] = out
+	}
+	return converted
+}
+
+// CreateIndex creates the index with the mappings (see IndexMappings),
+// ErrIndexExists is returned when it already exists.
+func (l LGrep) CreateIndex(index string, mappings map[string]interface{}) error {
+	if l.Client == nil {
+		return errors.New("Indices can only be created in Elasticsearch")
+	}
+	exists, err := l.Client.IndexExists(index).Do()
+	if err != nil {
+		return errors.Annotatef(err, "Could not check whether %s exists", index)
+	}
+	if exists {
+		return ErrIndexExists
+	}
+	body := map[string]interface{}{}
+	if mappings != nil {
+		body["mappings"] = mappings
+	}
+	_, err = l.Client.CreateIndex(index).BodyJson(body).Do()
+	return errors.Annotatef(err, "Could not create %s", index)
+}
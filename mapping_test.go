@@ -0,0 +1,61 @@
+package lgrep
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testCapturedMapping = `{
+  "logs-1": {"mappings": {"logs": {"properties": {
+    "message": {"type": "string"},
+    "host": {"type": "string", "index": "not_analyzed"},
+    "user": {"properties": {"name": {"type": "string", "fields": {"raw": {"type": "string", "index": "not_analyzed"}}}}}
+  }}}},
+  "logs-2": {"mappings": {"logs": {"properties": {
+    "message": {"type": "long"},
+    "status": {"type": "long"}
+  }}}}
+}`
+
+func TestIndexMappings(t *testing.T) {
+	var captured map[string]interface{}
+	if err := json.Unmarshal([]byte(testCapturedMapping), &captured); err != nil {
+		t.Fatal(err)
+	}
+	examples := []struct {
+		index    string
+		types    []string
+		version  string
+		expected string
+	}{
+		{"logs-2", nil, "2.4.6", `{"logs": {"properties": {"message": {"type": "long"}, "status": {"type": "long"}}}}`},
+		{"repro", []string{""}, "5.6.0", `{"doc": {"properties": {
+			"message": {"type": "text"},
+			"host": {"type": "keyword"},
+			"status": {"type": "long"},
+			"user": {"properties": {"name": {"type": "text", "fields": {"raw": {"type": "keyword"}}}}}
+		}}}`},
+		{"logs-1", nil, "8.11.0", `{"properties": {
+			"message": {"type": "text"},
+			"host": {"type": "keyword"},
+			"user": {"properties": {"name": {"type": "text", "fields": {"raw": {"type": "keyword"}}}}}
+		}}`},
+	}
+	for _, ex := range examples {
+		version, _ := ParseServerVersion(ex.version, "")
+		mappings, err := IndexMappings(captured, ex.index, ex.types, version)
+		if err != nil {
+			t.Errorf("Mapping %s for %s: %s", ex.index, ex.version, err)
+			continue
+		}
+		var expected map[string]interface{}
+		if err = json.Unmarshal([]byte(ex.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(mappings, expected) {
+			data, _ := json.Marshal(mappings)
+			t.Errorf("Mapped %s for %s as %s", ex.index, ex.version, data)
+		}
+	}
+}
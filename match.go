@@ -0,0 +1,105 @@
+package lgrep
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// FieldMatch is a regular expression that is matched against a field
+// of each result on the client, this filters results on fields that
+// aren't indexed or where lucene's regular expressions don't apply.
+type FieldMatch struct {
+	// Field is the dotted name of the field to match.
+	Field string
+	// Pattern is matched against the field's value.
+	Pattern *regexp.Regexp
+	// Negate requires the value not to match the pattern.
+	Negate bool
+}
+
+// ParseFieldMatch parses a FIELD=REGEX (or FIELD=~REGEX) match, or a
+// FIELD!~REGEX negated match.
+func ParseFieldMatch(s string) (m FieldMatch, err error) {
+	eq := strings.Index(s, "=")
+	neg := strings.Index(s, "!~")
+	var pattern string
+	switch {
+	case neg != -1 && (eq == -1 || neg < eq):
+		m.Field, pattern, m.Negate = s[:neg], s[neg+2:], true
+	case eq != -1:
+		m.Field, pattern = s[:eq], strings.TrimPrefix(s[eq+1:], "~")
+	default:
+		return m, errors.Errorf("Match '%s' should be given as FIELD=REGEX or FIELD!~REGEX", s)
+	}
+	if m.Field == "" {
+		return m, errors.Errorf("Match '%s' is missing the field name", s)
+	}
+	m.Pattern, err = regexp.Compile(pattern)
+	if err != nil {
+		return m, errors.Annotatef(err, "Match '%s' has an invalid regular expression", s)
+	}
+	return m, nil
+}
+
+// String returns the match as it would be given to ParseFieldMatch.
+func (m FieldMatch) String() string {
+	op := "="
+	if m.Negate {
+		op = "!~"
+	}
+	return m.Field + op + m.Pattern.String()
+}
+
+// Match checks the result's field against the pattern. Any one value
+// of an array matching is a match and a missing field never matches.
+func (m FieldMatch) Match(r Result) bool {
+	data, err := resultSource(r)
+	if err != nil {
+		return m.Negate
+	}
+	value, ok := fieldValue(data, m.Field)
+	if !ok {
+		return m.Negate
+	}
+	return m.matchValue(value) != m.Negate
+}
+
+// matchValue matches the pattern against the value as it would be
+// printed.
+func (m FieldMatch) matchValue(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return m.Pattern.MatchString(v)
+	case []interface{}:
+		for i := range v {
+			if m.matchValue(v[i]) {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		return m.Pattern.Match(data)
+	case nil:
+		return false
+	default:
+		return m.Pattern.MatchString(fmt.Sprint(v))
+	}
+}
+
+// matchAll checks that the result satisfies all of the matches.
+func matchAll(r Result, matches []FieldMatch) bool {
+	for _, m := range matches {
+		if !m.Match(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package lgrep
+
+import (
+	"testing"
+)
+
+func TestParseFieldMatch(t *testing.T) {
+	examples := []struct {
+		match   string
+		field   string
+		pattern string
+		negate  bool
+	}{
+		{"host=^web\\d+$", "host", "^web\\d+$", false},
+		{"host=~web", "host", "web", false},
+		{"route.fromdomain!~example\\.com", "route.fromdomain", "example\\.com", true},
+		{"message=a=b", "message", "a=b", false},
+		{"message!~a=b", "message", "a=b", true},
+	}
+	for _, ex := range examples {
+		m, err := ParseFieldMatch(ex.match)
+		if err != nil {
+			t.Errorf("ParseFieldMatch(%q) returned unexpected err: %s", ex.match, err)
+			continue
+		}
+		if m.Field != ex.field || m.Pattern.String() != ex.pattern || m.Negate != ex.negate {
+			t.Errorf("ParseFieldMatch(%q) => %s %s %t", ex.match, m.Field, m.Pattern, m.Negate)
+		}
+	}
+
+	for _, bad := range []string{"host", "=web", "host=(", "host!~["} {
+		if _, err := ParseFieldMatch(bad); err == nil {
+			t.Errorf("ParseFieldMatch(%q) should have returned an error", bad)
+		}
+	}
+}
+
+func TestFieldMatch(t *testing.T) {
+	doc := SourceResult(`{"host": "web01", "status": 502, "tags": ["a", "edge"], "route": {"fromdomain": "example.com"}}`)
+	examples := []struct {
+		match    string
+		expected bool
+	}{
+		{"host=^web\\d+$", true},
+		{"host!~^web", false},
+		{"status=^5\\d\\d$", true},
+		{"tags=^edge$", true},
+		{"route.fromdomain=example", true},
+		{"missing=.*", false},
+		{"missing!~.*", true},
+	}
+	for _, ex := range examples {
+		m, err := ParseFieldMatch(ex.match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Match(doc) != ex.expected {
+			t.Errorf("Match(%s) should have been %t", ex.match, ex.expected)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package lgrep
 
 import (
 	"encoding/json"
+	"strings"
+	"time"
 
 	"gopkg.in/olivere/elastic.v3"
 )
@@ -93,3 +95,77 @@ func (hr HitResult) String() string {
 	}
 	return string(b)
 }
+
+// ResultKey identifies a result so that duplicates may be detected,
+// hits are identified by their index, type and id while other results
+// are identified by their content.
+func ResultKey(r Result) string {
+	if hr, ok := r.(HitResult); ok {
+		return hr.Index + "/" + hr.Type + "/" + hr.Id
+	}
+	return r.String()
+}
+
+// ResultTime returns the timestamp of the result, zero when it has
+// none.
+func ResultTime(r Result) time.Time {
+	data, err := resultSource(r)
+	if err != nil {
+		return time.Time{}
+	}
+	return resultTime(data)
+}
+
+// resultSource returns the document's source fields as a map, for
+// hits this is the _source rather than the entire hit.
+func resultSource(r Result) (data map[string]interface{}, err error) {
+	if hr, ok := r.(HitResult); ok {
+		if hr.Source == nil {
+			return hr.Fields, nil
+		}
+		err = json.Unmarshal(*hr.Source, &data)
+		return data, err
+	}
+	return r.Map()
+}
+
+// fieldValue looks up a field by its dotted name in the document,
+// both nested objects and keys containing dots are searched.
+func fieldValue(data map[string]interface{}, field string) (value interface{}, ok bool) {
+	if value, ok = data[field]; ok {
+		return value, ok
+	}
+	for i := strings.Index(field, "."); i != -1; {
+		if nested, isMap := data[field[:i]].(map[string]interface{}); isMap {
+			if value, ok = fieldValue(nested, field[i+1:]); ok {
+				return value, ok
+			}
+		}
+		next := strings.Index(field[i+1:], ".")
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return nil, false
+}
+
+// NestFields creates a result from values given by their dotted field
+// names, nesting them as they would be in a document.
+func NestFields(values map[string]interface{}) FieldResult {
+	fr := FieldResult{}
+	for field, value := range values {
+		parts := strings.Split(field, ".")
+		obj := map[string]interface{}(fr)
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := obj[part].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				obj[part] = next
+			}
+			obj = next
+		}
+		obj[parts[len(parts)-1]] = value
+	}
+	return fr
+}
@@ -6,8 +6,8 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/juju/errors"
 	"gopkg.in/olivere/elastic.v3"
-	"gopkg.in/olivere/elastic.v3/uritemplates"
 )
 
 var (
@@ -20,6 +20,8 @@ var (
 )
 
 // Searcher is any service that provides a means to execute a query.
+//
+// Deprecated: searches are run by the Backend of the LGrep.
 type Searcher interface {
 	Do() (*elastic.SearchResult, error)
 }
@@ -45,10 +47,66 @@ func QueryMapFromJSON(data []byte) (qm QueryMap, err error) {
 	return qm, err
 }
 
+// SortField is a field that search results are sorted by.
+type SortField struct {
+	// Field is the name of the field to sort by.
+	Field string
+	// Asc sorts the field ascending rather than descending.
+	Asc bool
+	// UnmappedType is the type the field is sorted as in indices
+	// where it isn't mapped, "boolean" is used when not given.
+	UnmappedType string
+}
+
+// ParseSortFields parses a list of fields to sort by given as
+// "field:asc,other:desc", fields are sorted descending when the
+// order isn't given. The unmapped type may be given after the order
+// (field:asc:long).
+func ParseSortFields(str string) (fields []SortField, err error) {
+	for _, spec := range strings.Split(str, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.Split(spec, ":")
+		if parts[0] == "" || len(parts) > 3 {
+			return fields, errors.Errorf("Sort '%s' should be given as field:asc or field:desc", spec)
+		}
+		field := SortField{Field: parts[0]}
+		if len(parts) > 1 {
+			switch strings.ToLower(parts[1]) {
+			case "asc":
+				field.Asc = true
+			case "desc", "":
+			default:
+				return fields, errors.Errorf("Sort '%s' has an unknown order '%s'", spec, parts[1])
+			}
+		}
+		if len(parts) > 2 {
+			field.UnmappedType = parts[2]
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Sorter creates the sort for the field.
+func (f SortField) Sorter() elastic.Sorter {
+	unmapped := f.UnmappedType
+	if unmapped == "" {
+		unmapped = "boolean"
+	}
+	return elastic.NewFieldSort(f.Field).UnmappedType(unmapped).Order(f.Asc)
+}
+
+// timestampFields are the conventional timestamp fields that results
+// are sorted by.
+var timestampFields = []string{"@timestamp", "date"}
+
 // SortByTimestamp adds the conventional timestamped fields to the
 // search query.
 func SortByTimestamp(s *elastic.SearchService, asc bool) *elastic.SearchService {
-	for _, f := range []string{"@timestamp", "date"} {
+	for _, f := range timestampFields {
 		sort := elastic.NewFieldSort(f)
 		sort = sort.UnmappedType("boolean")
 		if asc {
@@ -64,8 +122,38 @@ func SortByTimestamp(s *elastic.SearchService, asc bool) *elastic.SearchService
 // SearchWithLucene transforms the textual query into the necessary
 // structure to search logstash data.
 func SearchWithLucene(s *elastic.SearchService, q string) *elastic.SearchService {
-	lucene := elastic.NewQueryStringQuery(q).AnalyzeWildcard(true)
-	return s.Query(elastic.NewConstantScoreQuery(lucene))
+	return s.Query(LucenePatternsQuery([]string{q}, false, false))
+}
+
+// LucenePatternsQuery combines several lucene queries into one that
+// matches documents matching any of them, or all of them when all is
+// set. The query is inverted to match the documents that don't match
+// when invert is set, as with grep -v.
+func LucenePatternsQuery(patterns []string, all bool, invert bool) elastic.Query {
+	var query elastic.Query
+	if len(patterns) == 1 {
+		query = elastic.NewQueryStringQuery(patterns[0]).AnalyzeWildcard(true)
+	} else {
+		combined := elastic.NewBoolQuery()
+		for _, p := range patterns {
+			lucene := elastic.NewQueryStringQuery(p).AnalyzeWildcard(true)
+			if all {
+				combined.Must(lucene)
+			} else {
+				combined.Should(lucene)
+			}
+		}
+		if !all {
+			combined.MinimumShouldMatch("1")
+		}
+		query = combined
+	}
+	if invert {
+		query = elastic.NewBoolQuery().
+			Must(elastic.NewMatchAllQuery()).
+			MustNot(query)
+	}
+	return elastic.NewConstantScoreQuery(query)
 }
 
 // SearchOptions is used to apply provided options to a search that is
@@ -94,80 +182,103 @@ type SearchOptions struct {
 	QuerySkipValidate bool
 	// RawResult will cause results to contain the entire returned hit.
 	RawResult bool
+	// Patterns are additional lucene queries that are searched for
+	// along with the query given to a lucene search, documents
+	// matching any one of them are returned.
+	Patterns []string
+	// PatternsAll requires documents to match all of the lucene
+	// queries rather than any one of them.
+	PatternsAll bool
+	// Invert returns the documents that do not match the lucene
+	// queries.
+	Invert bool
+	// Matches are matched against each result on the client, only
+	// the results that satisfy all of them are returned. The search
+	// reads as many documents as needed to return Size results.
+	Matches []FieldMatch
+	// Sort are the fields to sort the results by, the timestamp is
+	// used to break ties (descending unless SortTime is given).
+	Sort []SortField
+	// SearchAfter continues a search after the hit with these sort
+	// values (see elastic.SearchHit.Sort). When set, even if empty,
+	// results are paged with search_after instead of a scroll, this
+	// requires Elasticsearch 5 or later (or OpenSearch).
+	SearchAfter []interface{}
+	// TieBreaker is a field whose values are unique to each document
+	// (see LGrep.TieBreaker), sorted on last so that the sort values
+	// of every hit are unique and a search can be continued after any
+	// of them exactly.
+	TieBreaker string
 }
 
-// buildURL generates the url parts that are appropriate to the
-// endpoint and specifciation. Adapted from
-// elastic.SearchService.buildURL which is private - we require this
-// to submit a query for the _validate endpoint.
-func (s SearchOptions) buildURL(endpoint string) (path string, params url.Values, err error) {
-	var indices []string
-	var types []string
+// sorters returns the sorts that are applied to the search, the
+// timestamp sort breaks any ties of the given fields.
+func (s SearchOptions) sorters() (sorters []elastic.Sorter) {
+	timestamped := false
+	for _, f := range s.Sort {
+		sorters = append(sorters, f.Sorter())
+		for _, ts := range timestampFields {
+			timestamped = timestamped || f.Field == ts
+		}
+	}
+	if !timestamped && (s.SortTime != nil || len(s.Sort) != 0) {
+		asc := false
+		if s.SortTime != nil {
+			asc = *s.SortTime
+		}
+		for _, ts := range timestampFields {
+			sorters = append(sorters, SortField{Field: ts, Asc: asc}.Sorter())
+		}
+	}
+	if s.TieBreaker != "" {
+		sorters = append(sorters, elastic.NewFieldSort(s.TieBreaker).Asc())
+	}
+	return sorters
+}
+
+// lucenePatterns collects the lucene queries that are to be searched
+// for.
+func (s SearchOptions) lucenePatterns(q string) (patterns []string) {
+	if q != "" {
+		patterns = append(patterns, q)
+	}
+	return append(patterns, s.Patterns...)
+}
+
+// target returns the indices and types that are searched.
+func (s SearchOptions) target() (target SearchTarget) {
 	if s.Index != "" {
-		indices = append(indices, s.Index)
+		target.Indices = append(target.Indices, s.Index)
 	}
-	indices = append(indices, s.Indices...)
+	target.Indices = append(target.Indices, s.Indices...)
 	if s.Type != "" {
-		types = append(types, s.Type)
-	}
-	types = append(types, s.Types...)
-
-	if len(indices) > 0 && len(types) > 0 {
-		path, err = uritemplates.Expand("/{index}/{type}/", map[string]string{
-			"index": strings.Join(indices, ","),
-			"type":  strings.Join(types, ","),
-		})
-	} else if len(indices) > 0 {
-		path, err = uritemplates.Expand("/{index}/", map[string]string{
-			"index": strings.Join(indices, ","),
-		})
-	} else if len(types) > 0 {
-		path, err = uritemplates.Expand("/_all/{type}/", map[string]string{
-			"type": strings.Join(types, ","),
-		})
-	} else {
-		path = "/"
+		target.Types = append(target.Types, s.Type)
 	}
-	path += endpoint
+	target.Types = append(target.Types, s.Types...)
+	return target
+}
+
+// buildURL generates the url parts that are appropriate to the
+// endpoint and specifciation.
+func (s SearchOptions) buildURL(endpoint string) (path string, params url.Values, err error) {
+	path, err = s.target().path(endpoint)
 	if err != nil {
 		return "", params, err
 	}
 	return path, url.Values{}, err
 }
 
-// configureSearch applies the options given in the search
+// configureSource applies the options given in the search
 // specification to an already instaniated search.
-func (s SearchOptions) configureSearch(search *elastic.SearchService) {
+func (s SearchOptions) configureSource(source *elastic.SearchSource) {
 	if s.Size != 0 {
-		search.Size(s.Size)
-	}
-	if s.Index != "" {
-		search.Index(s.Index)
-	}
-	if len(s.Indices) != 0 {
-		search.Index(s.Indices...)
-	}
-	if s.SortTime != nil {
-		SortByTimestamp(search, *s.SortTime)
+		source.Size(s.Size)
 	}
+	source.SortBy(s.sorters()...)
 	if len(s.Fields) != 0 {
 		fsc := elastic.NewFetchSourceContext(true)
 		fsc.Include(s.Fields...)
-		search.FetchSourceContext(fsc)
-	}
-}
-
-// configureScroll applies the options given in the search
-// specification to an already instaniated scroll.
-func (s SearchOptions) configureScroll(scroll *elastic.ScrollService) {
-	if s.Size != 0 {
-		scroll.Size(s.Size)
-	}
-	if s.Index != "" {
-		scroll.Index(s.Index)
-	}
-	if len(s.Indices) != 0 {
-		scroll.Index(s.Indices...)
+		source.FetchSourceContext(fsc)
 	}
 }
 
@@ -184,4 +295,16 @@ func (s SearchOptions) configureQueryMap(m map[string]interface{}) {
 		source, _ := fsc.Include(s.Fields...).Source()
 		m["_source"] = source
 	}
+	if _, sorted := m["sort"]; !sorted && len(s.Sort) != 0 {
+		var sorts []interface{}
+		for _, sorter := range s.sorters() {
+			if source, err := sorter.Source(); err == nil {
+				sorts = append(sorts, source)
+			}
+		}
+		m["sort"] = sorts
+	}
+	if len(s.SearchAfter) != 0 {
+		m["search_after"] = s.SearchAfter
+	}
 }
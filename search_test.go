@@ -1,6 +1,8 @@
 package lgrep
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -21,3 +23,87 @@ func TestBuildURL(t *testing.T) {
 		}
 	}
 }
+
+func TestLucenePatternsQuery(t *testing.T) {
+	expectations := []struct {
+		patterns []string
+		all      bool
+		invert   bool
+		expected string
+	}{
+		{[]string{"a"}, false, false,
+			`{"constant_score":{"filter":{"query_string":{"analyze_wildcard":true,"query":"a"}}}}`},
+		{[]string{"a"}, false, true,
+			`{"constant_score":{"filter":{"bool":{"must":{"match_all":{}},"must_not":{"query_string":{"analyze_wildcard":true,"query":"a"}}}}}}`},
+		{[]string{"a", "b"}, false, false,
+			`{"constant_score":{"filter":{"bool":{"minimum_should_match":"1","should":[{"query_string":{"analyze_wildcard":true,"query":"a"}},{"query_string":{"analyze_wildcard":true,"query":"b"}}]}}}}`},
+		{[]string{"a", "b"}, true, false,
+			`{"constant_score":{"filter":{"bool":{"must":[{"query_string":{"analyze_wildcard":true,"query":"a"}},{"query_string":{"analyze_wildcard":true,"query":"b"}}]}}}}`},
+	}
+
+	for _, ex := range expectations {
+		source, err := LucenePatternsQuery(ex.patterns, ex.all, ex.invert).Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != ex.expected {
+			t.Errorf("LucenePatternsQuery(%v, all=%t, invert=%t) =>\n%s\n(expected)\n%s", ex.patterns, ex.all, ex.invert, data, ex.expected)
+		}
+	}
+}
+
+func TestParseSortFields(t *testing.T) {
+	fields, err := ParseSortFields("status:asc, host,bytes:DESC:long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SortField{
+		{Field: "status", Asc: true},
+		{Field: "host"},
+		{Field: "bytes", UnmappedType: "long"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("ParseSortFields => %#v (expected %#v)", fields, expected)
+	}
+
+	for _, bad := range []string{":asc", "host:up", "a:b:c:d"} {
+		if _, err := ParseSortFields(bad); err == nil {
+			t.Errorf("ParseSortFields(%q) should have returned an error", bad)
+		}
+	}
+}
+
+func TestSorters(t *testing.T) {
+	expectations := []struct {
+		spec     SearchOptions
+		expected string
+	}{
+		{SearchOptions{}, `null`},
+		{SearchOptions{SortTime: SortAsc},
+			`[{"@timestamp":{"order":"asc","unmapped_type":"boolean"}},{"date":{"order":"asc","unmapped_type":"boolean"}}]`},
+		{SearchOptions{Sort: []SortField{{Field: "status", Asc: true}}},
+			`[{"status":{"order":"asc","unmapped_type":"boolean"}},{"@timestamp":{"order":"desc","unmapped_type":"boolean"}},{"date":{"order":"desc","unmapped_type":"boolean"}}]`},
+		{SearchOptions{Sort: []SortField{{Field: "@timestamp", Asc: true}}, SortTime: SortDesc},
+			`[{"@timestamp":{"order":"asc","unmapped_type":"boolean"}}]`},
+		{SearchOptions{SortTime: SortDesc, TieBreaker: "_id"},
+			`[{"@timestamp":{"order":"desc","unmapped_type":"boolean"}},{"date":{"order":"desc","unmapped_type":"boolean"}},{"_id":{"order":"asc"}}]`},
+	}
+	for _, ex := range expectations {
+		var sources []interface{}
+		for _, sorter := range ex.spec.sorters() {
+			source, err := sorter.Source()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources = append(sources, source)
+		}
+		data, _ := json.Marshal(sources)
+		if string(data) != ex.expected {
+			t.Errorf("sorters() for %#v =>\n%s\n(expected)\n%s", ex.spec.Sort, data, ex.expected)
+		}
+	}
+}
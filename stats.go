@@ -0,0 +1,159 @@
+package lgrep
+
+import (
+	"strconv"
+
+	"github.com/juju/errors"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+const (
+	// statsAgg names the extended_stats aggregation of the field.
+	statsAgg = "stats"
+	// percentilesAgg names the percentiles aggregation of the field.
+	percentilesAgg = "percentiles"
+	// byAgg names the terms aggregation that groups the statistics.
+	byAgg = "by"
+	// allAgg names the filter aggregation that counts all the
+	// documents matching when they're not grouped, the total hits of
+	// 7.x servers stop at 10000.
+	allAgg = "all"
+	// DefaultStatsGroups is the number of groups the statistics are
+	// computed for when grouping by a field.
+	DefaultStatsGroups = 10
+)
+
+// DefaultPercentiles are the percentiles computed when none are given.
+var DefaultPercentiles = []float64{50, 90, 95, 99}
+
+// StatsOptions configures the statistics computed for a numeric field.
+type StatsOptions struct {
+	// Percentiles to compute, DefaultPercentiles are used if nil.
+	Percentiles []float64
+	// By is a field whose values the documents are grouped by, the
+	// statistics are computed for each group.
+	By string
+	// Groups is the number of groups (the most common values of By)
+	// that statistics are computed for, DefaultStatsGroups is used if
+	// not set.
+	Groups int
+}
+
+// Percentile is the value below which the percent of values fall.
+type Percentile struct {
+	Percent float64 `json:"percent"`
+	Value   float64 `json:"value"`
+}
+
+// FieldStats are the statistics of a numeric field's values.
+type FieldStats struct {
+	// Group is the value of the field that the documents were grouped
+	// by, nil when they're not grouped.
+	Group interface{} `json:"group,omitempty"`
+	// Documents is the number of documents in the group.
+	Documents int64 `json:"documents"`
+
+	// Count is the number of values of the field.
+	Count        int64        `json:"count"`
+	Min          float64      `json:"min"`
+	Max          float64      `json:"max"`
+	Avg          float64      `json:"avg"`
+	Sum          float64      `json:"sum"`
+	StdDeviation float64      `json:"std_deviation"`
+	Percentiles  []Percentile `json:"percentiles"`
+}
+
+// SimpleStats computes the statistics of the numeric field's values in
+// the documents matching the lucene query using the extended_stats and
+// percentiles aggregations. When grouped by a field, the statistics of
+// the most common groups are returned in the order of their document
+// counts.
+func (l LGrep) SimpleStats(q string, field string, opts StatsOptions, spec *SearchOptions) (stats []FieldStats, err error) {
+	if field == "" {
+		return stats, errors.New("No field given to compute the statistics of")
+	}
+	if opts.Percentiles == nil {
+		opts.Percentiles = DefaultPercentiles
+	}
+	if opts.Groups == 0 {
+		opts.Groups = DefaultStatsGroups
+	}
+	source, err := l.NewLuceneSearch(q, spec)
+	if err != nil {
+		return stats, err
+	}
+
+	extended := elastic.NewExtendedStatsAggregation().Field(field)
+	percentiles := elastic.NewPercentilesAggregation().Field(field).Percentiles(opts.Percentiles...)
+	if opts.By != "" {
+		source.Aggregation(byAgg, elastic.NewTermsAggregation().Field(opts.By).Size(opts.Groups).
+			SubAggregation(statsAgg, extended).
+			SubAggregation(percentilesAgg, percentiles))
+	} else {
+		source.Aggregation(allAgg, elastic.NewFilterAggregation().Filter(elastic.NewMatchAllQuery()).
+			SubAggregation(statsAgg, extended).
+			SubAggregation(percentilesAgg, percentiles))
+	}
+
+	aggs, err := l.aggregate(source, spec)
+	if err != nil {
+		return stats, errors.Annotate(err, "Could not compute the statistics")
+	}
+
+	if opts.By == "" {
+		all, ok := aggs.Filter(allAgg)
+		if !ok {
+			return stats, nil
+		}
+		s := fieldStats(all.Aggregations, opts.Percentiles)
+		s.Documents = all.DocCount
+		return []FieldStats{s}, nil
+	}
+	groups, ok := aggs.Terms(byAgg)
+	if !ok {
+		return stats, nil
+	}
+	for _, bucket := range groups.Buckets {
+		s := fieldStats(bucket.Aggregations, opts.Percentiles)
+		s.Group = bucket.Key
+		s.Documents = bucket.DocCount
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// fieldStats reads the statistics from the aggregations, those that
+// aren't available (as when there are no values) are left zero.
+func fieldStats(aggs elastic.Aggregations, percents []float64) (s FieldStats) {
+	if extended, ok := aggs.ExtendedStats(statsAgg); ok {
+		s.Count = extended.Count
+		for _, v := range []struct {
+			dst *float64
+			src *float64
+		}{
+			{&s.Min, extended.Min},
+			{&s.Max, extended.Max},
+			{&s.Avg, extended.Avg},
+			{&s.Sum, extended.Sum},
+			{&s.StdDeviation, extended.StdDeviation},
+		} {
+			if v.src != nil {
+				*v.dst = *v.src
+			}
+		}
+	}
+
+	values := make(map[float64]float64)
+	if percentiles, ok := aggs.Percentiles(percentilesAgg); ok {
+		// The percents are given as keys like "99.0".
+		for key, value := range percentiles.Values {
+			if percent, err := strconv.ParseFloat(key, 64); err == nil {
+				values[percent] = value
+			}
+		}
+	}
+	for _, percent := range percents {
+		s.Percentiles = append(s.Percentiles, Percentile{percent, values[percent]})
+	}
+	return s
+}
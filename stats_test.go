@@ -0,0 +1,64 @@
+package lgrep
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/olivere/elastic.v3"
+)
+
+// statsBackend answers searches with the aggregations, recording the
+// aggregations requested.
+type statsBackend struct {
+	*fakeBackend
+	aggregations string
+	requested    map[string]interface{}
+}
+
+func (b *statsBackend) Search(ctx context.Context, target SearchTarget, body interface{}) (*elastic.SearchResult, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var req struct {
+		Aggs map[string]interface{} `json:"aggregations"`
+	}
+	if err = json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	b.requested = req.Aggs
+	var aggs elastic.Aggregations
+	if err = json.Unmarshal([]byte(b.aggregations), &aggs); err != nil {
+		return nil, err
+	}
+	// The total hits are those counted by a 7.x server by default.
+	return &elastic.SearchResult{Hits: &elastic.SearchHits{TotalHits: 10000}, Aggregations: aggs}, nil
+}
+
+func TestSimpleStats(t *testing.T) {
+	backend := &statsBackend{fakeBackend: newFakeBackend(), aggregations: `{"all": {"doc_count": 12000,
+		"stats": {"count": 9000, "min": 1, "max": 3, "avg": 2, "sum": 18000, "std_deviation": 0.5},
+		"percentiles": {"values": {"50.0": 2, "99.0": 3}}}}`}
+	l := NewWithBackend(backend)
+
+	stats, err := l.SimpleStats("status:500", "took", StatsOptions{Percentiles: []float64{50, 99}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("Computed %d statistics, expected 1", len(stats))
+	}
+	s := stats[0]
+	// Documents without the field are counted, unlike its values.
+	if s.Documents != 12000 || s.Count != 9000 || s.Avg != 2 {
+		t.Errorf("Computed %+v", s)
+	}
+	if len(s.Percentiles) != 2 || s.Percentiles[1] != (Percentile{99, 3}) {
+		t.Errorf("Computed the percentiles %v", s.Percentiles)
+	}
+	all, _ := backend.requested[allAgg].(map[string]interface{})
+	if _, ok := all["filter"]; !ok {
+		t.Errorf("Expected the documents to be counted by a filter aggregation, requested %v", backend.requested)
+	}
+}
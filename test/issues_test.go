@@ -72,8 +72,9 @@ func TestIssue11(t *testing.T) {
 	}
 	results, err := l.SimpleSearch("*", &lgrep.SearchOptions{Size: tooLargeSize, Index: "journald-*", Fields: []string{"host"}})
 	if err != nil {
-		eserr, _ := errors.Cause(err).(*elastic.Error)
-		t.Fatalf("%q\n", eserr.Details)
+		if eserr, ok := errors.Cause(err).(*elastic.Error); ok {
+			t.Fatalf("%+v\n", eserr.Details)
+		}
 		t.Fatalf("Error retrieving %d results: %s: %#v", tooLargeSize, err, err)
 	}
 	if len(results) != tooLargeSize {
@@ -0,0 +1,40 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cogolabs/lgrep"
+	"github.com/cogolabs/lgrep/lgreptest"
+)
+
+// TestEndpoint is the fake server that's searched by the tests.
+var TestEndpoint string
+
+func TestMain(m *testing.M) {
+	server := lgreptest.NewServer()
+	fixtures, err := filepath.Glob("fixtures/*.ndjson")
+	if err != nil || len(fixtures) == 0 {
+		log.Fatalf("No fixtures found: %v", err)
+	}
+	for _, fixture := range fixtures {
+		if err = server.Load(fixture); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Enough documents to be scrolled through.
+	for i := 0; i < lgrep.MaxSearchSize+10; i++ {
+		server.Index("journald-2016.04.30", "journald", fmt.Sprintf("bulk%d", i), map[string]interface{}{
+			"@timestamp": "2016-04-30T00:00:00Z",
+			"host":       fmt.Sprintf("web%d", i%3),
+			"message":    fmt.Sprintf("Message %d", i),
+		})
+	}
+	TestEndpoint = server.URL
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
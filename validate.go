@@ -1,6 +1,7 @@
 package lgrep
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 
@@ -17,7 +18,7 @@ var (
 	ErrInvalidQuery = errors.New("Invalid search query")
 	// ErrInvalidLuceneSyntax indicates that the provided lucene query
 	// could not be parsed by Elasticsearch.
-	ErrInvalidLuceneSyntax = errors.New("Invalid Lucene syntax - see http://localhost/goto/syntax")
+	ErrInvalidLuceneSyntax = errors.New("Invalid Lucene syntax - see https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-query-string-query.html#query-string-syntax")
 	// ErrInvalidIndex indicates that a query was attempted on a non-existent index or index pattern.
 	ErrInvalidIndex = errors.New("Invalid query on unknown index")
 )
@@ -39,12 +40,50 @@ type ValidationResponse struct {
 type ValidationExplanation struct {
 	Index   string `json:"index"`
 	Valid   bool   `json:"valid"`
-	Message string `json:"error"`
-	Error   error  `json:"-"`
+	Message string `json:"error,omitempty"`
+	// Explanation is the query as rewritten by the index when the
+	// query is valid.
+	Explanation string `json:"explanation,omitempty"`
+	Error       error  `json:"-"`
+}
+
+// Validate checks the lucene query with the server without running
+// it. The response holds the explanation given for each index,
+// including the rewritten query when it is valid, and is returned
+// along with any error the query was found to have.
+func (l LGrep) Validate(q string, spec *SearchOptions) (result ValidationResponse, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	patterns := spec.lucenePatterns(q)
+	if len(patterns) == 0 {
+		return result, ErrEmptySearch
+	}
+	for _, p := range patterns {
+		if _, err = ParseLucene(p); err != nil {
+			return result, err
+		}
+	}
+	source := elastic.NewSearchSource()
+	source.Query(LucenePatternsQuery(patterns, spec.PatternsAll, spec.Invert))
+	return l.validate(source, *spec)
+}
+
+// ValidateSource checks a raw query (see SearchWithSource) with the
+// server in the same way as Validate.
+func (l LGrep) ValidateSource(raw interface{}, spec *SearchOptions) (result ValidationResponse, err error) {
+	if spec == nil {
+		spec = &DefaultSpec
+	}
+	query, err := queryFromRaw(raw)
+	if err != nil {
+		return result, err
+	}
+	return l.validate(query, *spec)
 }
 
 func (l LGrep) validate(query interface{}, spec SearchOptions) (result ValidationResponse, err error) {
-	resp, err := l.validateBody(query, spec)
+	result, err = l.validateBody(query, spec)
 	if err != nil {
 		message := err.Error()
 		if strings.Contains(message, "index_not_found_exception") {
@@ -52,12 +91,6 @@ func (l LGrep) validate(query interface{}, spec SearchOptions) (result Validatio
 		}
 		return result, err
 	}
-
-	result.Explanations = make([]ValidationExplanation, 0)
-	err = json.Unmarshal(resp.Body, &result)
-	if err != nil {
-		return result, err
-	}
 	if result.Valid && result.Shards.Successful != 0 {
 		return result, nil
 	}
@@ -65,9 +98,11 @@ func (l LGrep) validate(query interface{}, spec SearchOptions) (result Validatio
 	errs := make(map[string]error)
 
 	for i := range result.Explanations {
-		exp := result.Explanations[i]
+		exp := &result.Explanations[i]
 		exp.Error = parseValidationError(exp.Message, exp.Index)
-		errs[exp.Error.Error()] = exp.Error
+		if exp.Error != nil {
+			errs[exp.Error.Error()] = exp.Error
+		}
 	}
 
 	if len(errs) == 1 {
@@ -80,11 +115,7 @@ func (l LGrep) validate(query interface{}, spec SearchOptions) (result Validatio
 	return result, ErrInvalidQuery
 }
 
-func (l LGrep) validateBody(query interface{}, spec SearchOptions) (response *elastic.Response, err error) {
-	path, params, err := spec.buildURL("_validate/query")
-	if err != nil {
-		return response, err
-	}
+func (l LGrep) validateBody(query interface{}, spec SearchOptions) (response ValidationResponse, err error) {
 	switch v := query.(type) {
 	case elastic.SearchSource:
 		query, _ = v.Source()
@@ -110,10 +141,9 @@ func (l LGrep) validateBody(query interface{}, spec SearchOptions) (response *el
 		delete(queryMap, key)
 	}
 
-	params.Set("explain", "true")
-	log.Debugf("Validating query at '%s?%s'", path, params.Encode())
+	log.Debugf("Validating query of %v", spec.target())
 
-	return l.Client.PerformRequest("GET", path, params, queryMap)
+	return l.backend().Validate(context.TODO(), spec.target(), queryMap)
 }
 
 func parseValidationError(msg string, index string) (err error) {
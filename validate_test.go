@@ -0,0 +1,102 @@
+package lgrep
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+// validateBackend answers validations with the response, recording the
+// body validated.
+type validateBackend struct {
+	*fakeBackend
+	response ValidationResponse
+	err      error
+	body     interface{}
+}
+
+func (b *validateBackend) Validate(ctx context.Context, target SearchTarget, body interface{}) (ValidationResponse, error) {
+	b.body = body
+	return b.response, b.err
+}
+
+// explained returns the response of a validation with the explanations
+// of each index.
+func explained(valid bool, explanations ...ValidationExplanation) (response ValidationResponse) {
+	response.Valid = valid
+	response.Shards.Total = len(explanations)
+	if valid {
+		response.Shards.Successful = len(explanations)
+	} else {
+		response.Shards.Failed = len(explanations)
+	}
+	response.Explanations = explanations
+	return response
+}
+
+func TestValidate(t *testing.T) {
+	backend := &validateBackend{fakeBackend: newFakeBackend()}
+	l := NewWithBackend(backend)
+	spec := &SearchOptions{Index: "logs-*"}
+
+	// The rewritten query of each index is explained.
+	backend.response = explained(true,
+		ValidationExplanation{Index: "logs-1", Valid: true, Explanation: "+status:[500 TO 500]"},
+		ValidationExplanation{Index: "logs-2", Valid: true, Explanation: "+status:[500 TO 500]"})
+	result, err := l.Validate("status:500", spec)
+	if err != nil || !reflect.DeepEqual(result, backend.response) {
+		t.Errorf("Validated %+v (%v), expected %+v", result, err, backend.response)
+	}
+
+	examples := []struct {
+		response ValidationResponse
+		err      error
+		expected string
+	}{
+		{explained(false, ValidationExplanation{Index: "logs-1", Message: "[logs-1] QueryShardException[Failed to parse query [status:(]]; nested: ParseException[Cannot parse 'status:(': Encountered \"<EOF>\"]"}),
+			nil, ErrInvalidLuceneSyntax.Error()},
+		// The index is removed from the message of a single error.
+		{explained(false, ValidationExplanation{Index: "logs-1", Message: "[logs-1] No mapping found for [status] in order to sort on"}),
+			nil, " No mapping found for [status] in order to sort on"},
+		// Different errors for each index aren't told apart.
+		{explained(false,
+			ValidationExplanation{Index: "logs-1", Message: "[logs-1] No mapping found for [status]"},
+			ValidationExplanation{Index: "logs-2", Message: "[logs-2] failed to create query"}),
+			nil, ErrInvalidQuery.Error()},
+		{ValidationResponse{}, errors.New("elastic: Error 404 (Not Found): no such index [type=index_not_found_exception]"), ErrInvalidIndex.Error()},
+	}
+	for _, ex := range examples {
+		backend.response, backend.err = ex.response, ex.err
+		result, err := l.Validate("status:500", spec)
+		if err == nil || err.Error() != ex.expected {
+			t.Errorf("Validated with the error %v, expected %q", err, ex.expected)
+		}
+		for _, exp := range result.Explanations {
+			if exp.Error == nil {
+				t.Errorf("Expected the error of the explanation %+v", exp)
+			}
+		}
+	}
+
+	// Queries that don't parse aren't sent to the server.
+	backend.body = nil
+	if _, err = l.Validate("status:(500", spec); errors.Cause(err) != ErrInvalidLuceneSyntax || backend.body != nil {
+		t.Errorf("Expected the invalid query to be rejected before validating, got %v", err)
+	}
+}
+
+func TestValidateSource(t *testing.T) {
+	backend := &validateBackend{fakeBackend: newFakeBackend(), response: explained(true, ValidationExplanation{Index: "logs-1", Valid: true})}
+	l := NewWithBackend(backend)
+	raw := json.RawMessage(`{"size": 10, "sort": ["@timestamp"], "_source": ["message"], "query": {"term": {"status": 500}}}`)
+	if _, err := l.ValidateSource(raw, &SearchOptions{Index: "logs-*"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"query": map[string]interface{}{"term": map[string]interface{}{"status": float64(500)}}}
+	if !reflect.DeepEqual(backend.body, expected) {
+		t.Errorf("Validated %v, expected the query alone %v", backend.body, expected)
+	}
+}